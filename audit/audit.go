@@ -0,0 +1,135 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+type Config struct {
+	// Path of the append-only log file, entries are only kept in memory if empty
+	Path string `toml:"path"`
+	// Recent is the number of entries kept for display
+	Recent int `toml:"recent"`
+}
+
+// Entry records a single administrative change
+type Entry struct {
+	Timestamp   time.Time `json:"timestamp"`
+	Action      string    `json:"action"`
+	Id          string    `json:"id"`
+	Application string    `json:"application"`
+	Name        string    `json:"name"`
+	User        string    `json:"user,omitempty"`
+}
+
+// Log appends entries as JSON lines and keeps the most recent ones in memory
+type Log struct {
+	file   *os.File
+	limit  int
+	recent []Entry
+	mutex  sync.Mutex
+}
+
+func NewLog(config Config) (*Log, error) {
+	limit := config.Recent
+	if limit == 0 {
+		limit = 100
+	}
+	l := &Log{limit: limit}
+	if config.Path == "" {
+		return l, nil
+	}
+
+	if err := l.load(config.Path); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(config.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("open audit log: %w", err)
+	}
+	l.file = file
+	return l, nil
+}
+
+// load restores recent entries from an existing log file
+func (l *Log) load(path string) error {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read audit log: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			log.Println("audit: skipping invalid entry", err)
+			continue
+		}
+		l.remember(entry)
+	}
+	return scanner.Err()
+}
+
+// remember keeps entry in the recent list, expects the mutex to be held or exclusive access
+func (l *Log) remember(entry Entry) {
+	l.recent = append(l.recent, entry)
+	if len(l.recent) > l.limit {
+		l.recent = l.recent[len(l.recent)-l.limit:]
+	}
+}
+
+// Record appends an entry, failures are logged since the change already happened
+func (l *Log) Record(entry Entry) {
+	if l == nil {
+		return
+	}
+	if entry.Timestamp.IsZero() {
+		entry.Timestamp = time.Now()
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.remember(entry)
+	if l.file == nil {
+		return
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		log.Println("audit: marshal", err)
+		return
+	}
+	if _, err := l.file.Write(append(line, '\n')); err != nil {
+		log.Println("audit: write", err)
+	}
+}
+
+// Recent returns the most recent entries, newest first
+func (l *Log) Recent() []Entry {
+	if l == nil {
+		return nil
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	res := make([]Entry, len(l.recent))
+	for i, entry := range l.recent {
+		res[len(res)-1-i] = entry
+	}
+	return res
+}
+
+// Close closes the log file
+func (l *Log) Close() error {
+	if l == nil || l.file == nil {
+		return nil
+	}
+	return l.file.Close()
+}
@@ -5,13 +5,15 @@ import (
 	"flag"
 	"io/ioutil"
 	"log"
+	"log/slog"
 	"os"
 	"os/signal"
 	"syscall"
-	"time"
 
 	"github.com/pelletier/go-toml"
+	"github.com/voc/rtmp-auth/geoip"
 	"github.com/voc/rtmp-auth/http"
+	"github.com/voc/rtmp-auth/mail"
 	"github.com/voc/rtmp-auth/store"
 )
 
@@ -56,6 +58,9 @@ func main() {
 			File: store.FileBackendConfig{
 				Path: "store.db",
 			},
+			SQLite: store.SQLiteBackendConfig{
+				Path: "store.sqlite",
+			},
 		},
 	}
 	var configPath = flag.String("config", "config.toml", "Config toml")
@@ -63,6 +68,7 @@ func main() {
 	var frontendAddr = flag.String("frontendAddr", "", "Frontend bind address")
 	var insecure = flag.Bool("insecure", false, "Set to allow non-secure CSRF cookie")
 	var prefix = flag.String("subpath", "", "Set to allow running behind reverse-proxy at that subpath")
+	var restore = flag.String("restore", "", "Replace the state file with this backup and exit")
 	flag.Parse()
 
 	if *apiAddr != "" {
@@ -87,6 +93,21 @@ func main() {
 		log.Fatal("parse config", err)
 	}
 
+	if config.HTTP.LogFormat == "json" {
+		// also routes the standard logger through the handler
+		slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, nil)))
+	}
+
+	if *restore != "" {
+		if config.Store.Backend != "file" {
+			log.Fatal("restore: backups are only kept by the file backend")
+		}
+		if err := store.RestoreFileBackup(config.Store.File, *restore); err != nil {
+			log.Fatal("restore: ", err)
+		}
+		return
+	}
+
 	out, _ := json.Marshal(&config)
 	log.Println("using config", string(out))
 
@@ -95,30 +116,49 @@ func main() {
 		log.Fatal("Failed to create store", err)
 	}
 
+	if config.HTTP.GeoIPDatabase != "" {
+		db, err := geoip.Open(config.HTTP.GeoIPDatabase)
+		if err != nil {
+			log.Fatal(err)
+		}
+		store.SetGeoIP(db)
+	}
+	if config.HTTP.TokenSecret != "" {
+		store.SetTokenSecret([]byte(config.HTTP.TokenSecret))
+		store.SetOneTimeTokens(config.HTTP.OneTimeTokens)
+	}
+	store.SetOpenApplications(config.HTTP.OpenApplications)
+	store.SetApplicationQuotas(config.HTTP.ApplicationQuotas)
+	store.SetKeyPrefixes(config.HTTP.KeyPrefixes)
+	store.SetStaleTimeout(config.HTTP.StaleTimeout)
+
+	store.SetLiveExpiryGrace(config.HTTP.LiveExpiryGrace)
+	store.SetAutoBlock(config.HTTP.AutoBlockLimit, config.HTTP.AutoBlockWindow)
+
+	if config.HTTP.ExpiryWarning > 0 {
+		store.SetExpiryWarning(config.HTTP.ExpiryWarning)
+	}
+
+	if len(config.HTTP.Mail.To) > 0 {
+		notifier, err := mail.NewNotifier(config.HTTP.Mail)
+		if err != nil {
+			log.Fatal(err)
+		}
+		store.Subscribe(notifier.Notify)
+	}
+
 	// Set up servers
 	api := http.NewAPI(config.APIAddress, config.HTTP, store)
 	frontend := http.NewFrontend(config.FrontendAddress, config.HTTP, store)
 
-	// Periodically expire old streams
-	ticker := time.NewTicker(5 * time.Minute)
-	stopPolling := make(chan struct{})
-	go func() {
-		for {
-			select {
-			case <-stopPolling:
-				return
-			case <-ticker.C:
-				store.Expire()
-			}
-		}
-	}()
-
 	// Handle signals
 	waitForSignal()
 	log.Println("Shutting down")
 
-	// Shut everything down
-	close(stopPolling)
+	// Shut everything down, servers first so in-flight requests still reach the store
 	api.Stop()
 	frontend.Stop()
+	if err := store.Close(); err != nil {
+		log.Println("store close:", err)
+	}
 }
@@ -0,0 +1,322 @@
+// Package geoip resolves the country of an address from a MaxMind DB file like GeoLite2-Country.mmdb
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"os"
+)
+
+// metadataMarker precedes the metadata map at the end of the file
+var metadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+// ErrNotFound means the database has no entry for the address, e.g. for private networks
+var ErrNotFound = errors.New("geoip: address not found")
+
+// DB is a MaxMind DB loaded into memory, safe for concurrent use
+type DB struct {
+	data       []byte
+	nodeCount  uint
+	recordSize uint
+	ipVersion  uint
+	// dataStart is the offset of the data section, ipv4Start the node of ::/96 in IPv6 trees
+	dataStart uint
+	ipv4Start uint
+}
+
+// Open reads the database at path
+func Open(path string) (*DB, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("geoip: %w", err)
+	}
+	db, err := parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("geoip: %s: %w", path, err)
+	}
+	return db, nil
+}
+
+func parse(data []byte) (*DB, error) {
+	start := bytes.LastIndex(data, metadataMarker)
+	if start == -1 {
+		return nil, errors.New("no metadata, not a MaxMind DB")
+	}
+	metaStart := uint(start + len(metadataMarker))
+	value, _, err := (&decoder{data: data[metaStart:]}).decode(0)
+	if err != nil {
+		return nil, fmt.Errorf("metadata: %w", err)
+	}
+	meta, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("metadata: not a map")
+	}
+	db := &DB{
+		data:       data,
+		nodeCount:  metaUint(meta, "node_count"),
+		recordSize: metaUint(meta, "record_size"),
+		ipVersion:  metaUint(meta, "ip_version"),
+	}
+	if db.recordSize != 24 && db.recordSize != 28 && db.recordSize != 32 {
+		return nil, fmt.Errorf("unsupported record size %d", db.recordSize)
+	}
+	// The search tree is followed by 16 zero bytes
+	db.dataStart = db.nodeCount*db.recordSize/4 + 16
+	if db.dataStart > metaStart {
+		return nil, errors.New("search tree exceeds the file")
+	}
+	if db.ipVersion == 6 {
+		node := uint(0)
+		for i := 0; i < 96 && node < db.nodeCount; i++ {
+			node = db.record(node, 0)
+		}
+		db.ipv4Start = node
+	}
+	return db, nil
+}
+
+func metaUint(meta map[string]interface{}, key string) uint {
+	value, _ := meta[key].(uint64)
+	return uint(value)
+}
+
+// record returns the left (bit 0) or right (bit 1) record of node
+func (db *DB) record(node uint, bit uint) uint {
+	size := db.recordSize / 4
+	b := db.data[node*size : (node+1)*size]
+	switch db.recordSize {
+	case 24:
+		b = b[bit*3:]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	case 28:
+		if bit == 0 {
+			return uint(b[3]&0xf0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0f)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		return uint(binary.BigEndian.Uint32(b[bit*4:]))
+	}
+}
+
+// lookup returns the data record of ip
+func (db *DB) lookup(ip net.IP) (interface{}, error) {
+	node := uint(0)
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+		node = db.ipv4Start
+	} else if db.ipVersion == 4 {
+		return nil, ErrNotFound
+	}
+	for i := 0; i < len(ip)*8 && node < db.nodeCount; i++ {
+		node = db.record(node, uint(ip[i/8]>>(7-i%8))&1)
+	}
+	if node == db.nodeCount {
+		return nil, ErrNotFound
+	}
+	if node < db.nodeCount {
+		return nil, errors.New("geoip: search tree ends in a node")
+	}
+	offset := node - db.nodeCount - 16
+	d := &decoder{data: db.data[db.dataStart:]}
+	value, _, err := d.decode(offset)
+	return value, err
+}
+
+// Country returns the ISO 3166-1 alpha-2 code of the country of ip, like "DE",
+// falling back to the registered country for addresses without a location
+func (db *DB) Country(ip net.IP) (string, error) {
+	value, err := db.lookup(ip)
+	if err != nil {
+		return "", err
+	}
+	record, _ := value.(map[string]interface{})
+	for _, key := range []string{"country", "registered_country"} {
+		country, _ := record[key].(map[string]interface{})
+		if code, _ := country["iso_code"].(string); code != "" {
+			return code, nil
+		}
+	}
+	return "", ErrNotFound
+}
+
+// decoder reads values of the MaxMind DB data section format
+type decoder struct {
+	data []byte
+}
+
+const (
+	typeExtended = iota
+	typePointer
+	typeString
+	typeDouble
+	typeBytes
+	typeUint16
+	typeUint32
+	typeMap
+	typeInt32
+	typeUint64
+	typeUint128
+	typeArray
+	typeContainer
+	typeEndMarker
+	typeBool
+	typeFloat
+)
+
+var errTruncated = errors.New("truncated data")
+
+// decode returns the value at offset and the offset following it
+func (d *decoder) decode(offset uint) (interface{}, uint, error) {
+	if offset >= uint(len(d.data)) {
+		return nil, 0, errTruncated
+	}
+	ctrl := d.data[offset]
+	offset++
+	kind := uint(ctrl >> 5)
+	if kind == typePointer {
+		pointer, next, err := d.pointer(ctrl, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		// Pointers may not point to pointers, which also rules out loops
+		if pointer < uint(len(d.data)) && d.data[pointer]>>5 == typePointer {
+			return nil, 0, errors.New("pointer to pointer")
+		}
+		value, _, err := d.decode(pointer)
+		return value, next, err
+	}
+	if kind == typeExtended {
+		if offset >= uint(len(d.data)) {
+			return nil, 0, errTruncated
+		}
+		kind = 7 + uint(d.data[offset])
+		offset++
+	}
+	size, offset, err := d.size(ctrl, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	switch kind {
+	case typeMap:
+		res := make(map[string]interface{}, size)
+		for i := uint(0); i < size; i++ {
+			key, next, err := d.decode(offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			name, ok := key.(string)
+			if !ok {
+				return nil, 0, errors.New("map key is not a string")
+			}
+			value, next, err := d.decode(next)
+			if err != nil {
+				return nil, 0, err
+			}
+			res[name] = value
+			offset = next
+		}
+		return res, offset, nil
+	case typeArray:
+		res := make([]interface{}, 0, size)
+		for i := uint(0); i < size; i++ {
+			value, next, err := d.decode(offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			res = append(res, value)
+			offset = next
+		}
+		return res, offset, nil
+	case typeBool:
+		return size != 0, offset, nil
+	}
+
+	if offset+size > uint(len(d.data)) {
+		return nil, 0, errTruncated
+	}
+	b := d.data[offset : offset+size]
+	offset += size
+	switch kind {
+	case typeString:
+		return string(b), offset, nil
+	case typeBytes, typeUint128:
+		return append([]byte(nil), b...), offset, nil
+	case typeDouble:
+		if size != 8 {
+			return nil, 0, errors.New("invalid double size")
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), offset, nil
+	case typeFloat:
+		if size != 4 {
+			return nil, 0, errors.New("invalid float size")
+		}
+		return math.Float32frombits(binary.BigEndian.Uint32(b)), offset, nil
+	case typeUint16, typeUint32, typeUint64:
+		var value uint64
+		for _, c := range b {
+			value = value<<8 | uint64(c)
+		}
+		return value, offset, nil
+	case typeInt32:
+		var value uint32
+		for _, c := range b {
+			value = value<<8 | uint32(c)
+		}
+		return int32(value), offset, nil
+	case typeContainer, typeEndMarker:
+		return nil, offset, nil
+	}
+	return nil, 0, fmt.Errorf("unknown data type %d", kind)
+}
+
+// size reads the payload size encoded in ctrl and the bytes following it
+func (d *decoder) size(ctrl byte, offset uint) (uint, uint, error) {
+	size := uint(ctrl & 0x1f)
+	if size < 29 {
+		return size, offset, nil
+	}
+	extra := size - 28
+	if offset+extra > uint(len(d.data)) {
+		return 0, 0, errTruncated
+	}
+	var value uint
+	for _, c := range d.data[offset : offset+extra] {
+		value = value<<8 | uint(c)
+	}
+	switch size {
+	case 29:
+		size = 29 + value
+	case 30:
+		size = 285 + value
+	default:
+		size = 65821 + value
+	}
+	return size, offset + extra, nil
+}
+
+// pointer reads the data section offset a pointer refers to
+func (d *decoder) pointer(ctrl byte, offset uint) (uint, uint, error) {
+	length := uint(ctrl>>3)&0x3 + 1
+	if offset+length > uint(len(d.data)) {
+		return 0, 0, errTruncated
+	}
+	var value uint
+	if length < 4 {
+		value = uint(ctrl & 0x7)
+	}
+	for _, c := range d.data[offset : offset+length] {
+		value = value<<8 | uint(c)
+	}
+	switch length {
+	case 2:
+		value += 2048
+	case 3:
+		value += 526336
+	}
+	return value, offset + length, nil
+}
@@ -0,0 +1,253 @@
+package http
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/csrf"
+	"github.com/voc/rtmp-auth/store"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	sessionCookie   = "rtmp-auth-session"
+	sessionLifetime = 12 * time.Hour
+)
+
+type userKey struct{}
+
+// requestUser returns the authenticated admin of the request, empty if admin auth is disabled
+func requestUser(r *http.Request) string {
+	user, _ := r.Context().Value(userKey{}).(string)
+	return user
+}
+
+// adminAuth checks admin credentials from ServerConfig.Users
+type adminAuth struct {
+	users   map[string]string
+	session bool
+	secret  []byte
+	prefix  string
+	secure  bool
+	proxies trustedProxies
+	// dummy is compared for unknown users, a bcrypt hash of the cost of the configured ones if any are hashed
+	dummy string
+}
+
+func newAdminAuth(config ServerConfig, secret []byte) *adminAuth {
+	if len(config.Users) == 0 {
+		return nil
+	}
+	return &adminAuth{
+		users:   config.Users,
+		session: config.AuthMode == "session",
+		secret:  secret,
+		prefix:  config.Prefix,
+		secure:  !config.Insecure,
+		proxies: parseTrustedProxies(config.TrustedProxies),
+		dummy:   dummyPassword(config.Users),
+	}
+}
+
+// dummyPassword returns a hash taking as long to compare as the hashed passwords in users,
+// so unknown users can't be told apart by the response time. Empty if no password is hashed
+func dummyPassword(users map[string]string) string {
+	for _, password := range users {
+		if !store.IsHashedKey(password) {
+			continue
+		}
+		cost, err := bcrypt.Cost([]byte(password))
+		if err != nil {
+			continue
+		}
+		// Never matches as the user is unknown anyway
+		hash, err := bcrypt.GenerateFromPassword([]byte("rtmp-auth dummy password"), cost)
+		if err != nil {
+			log.Println("failed to hash dummy password:", err)
+			return ""
+		}
+		return string(hash)
+	}
+	return ""
+}
+
+// check compares the credentials at constant time, passwords may be bcrypt hashes
+func (a *adminAuth) check(user string, password string) bool {
+	expected, ok := a.users[user]
+	if !ok {
+		// compare anyway to not leak which users exist
+		expected = a.dummy
+	}
+	var match bool
+	if store.IsHashedKey(expected) {
+		match = bcrypt.CompareHashAndPassword([]byte(expected), []byte(password)) == nil
+	} else {
+		match = subtle.ConstantTimeCompare([]byte(expected), []byte(password)) == 1
+	}
+	return ok && match
+}
+
+func (a *adminAuth) sign(payload string) string {
+	mac := hmac.New(sha256.New, a.secret)
+	mac.Write([]byte(payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// newSession returns a signed cookie value of user and expiry
+func (a *adminAuth) newSession(user string, expiry time.Time) string {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(user)) + "|" + strconv.FormatInt(expiry.Unix(), 10)
+	return payload + "|" + a.sign(payload)
+}
+
+// sessionUser validates the session cookie and returns its user
+func (a *adminAuth) sessionUser(r *http.Request) (string, bool) {
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return "", false
+	}
+	i := strings.LastIndex(cookie.Value, "|")
+	if i < 0 {
+		return "", false
+	}
+	payload, signature := cookie.Value[:i], cookie.Value[i+1:]
+	if !hmac.Equal([]byte(signature), []byte(a.sign(payload))) {
+		return "", false
+	}
+	parts := strings.SplitN(payload, "|", 2)
+	if len(parts) != 2 {
+		return "", false
+	}
+	expiry, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || time.Now().Unix() > expiry {
+		return "", false
+	}
+	user, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", false
+	}
+	// sessions end when the user is removed from the config
+	if _, ok := a.users[string(user)]; !ok {
+		return "", false
+	}
+	return string(user), true
+}
+
+func (a *adminAuth) setCookie(w http.ResponseWriter, r *http.Request, value string, expiry time.Time) {
+	path := urlPrefix(r, a.prefix)
+	if path == "" {
+		path = "/"
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    value,
+		Path:     path,
+		Expires:  expiry,
+		HttpOnly: true,
+		Secure:   a.secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// Middleware requires an authenticated admin for all requests except the login page and static files.
+// Basic auth is always accepted so scripts can use the api in session mode
+func (a *adminAuth) Middleware(next http.Handler) http.Handler {
+	if a == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := strings.TrimPrefix(r.URL.Path, a.prefix)
+		if strings.HasPrefix(path, "/public/") || (a.session && path == "/login") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		user, password, hasBasic := r.BasicAuth()
+		authenticated := hasBasic && a.check(user, password)
+		if !authenticated && a.session {
+			user, authenticated = a.sessionUser(r)
+		}
+		if !authenticated {
+			if hasBasic {
+				log.Printf("admin login of '%s' from %s failed\n", user, clientIP(r, a.proxies))
+			}
+			if a.session && !strings.HasPrefix(path, "/api/") {
+				http.Redirect(w, r, urlPrefix(r, a.prefix)+"/login", http.StatusSeeOther)
+				return
+			}
+			w.Header().Set("WWW-Authenticate", `Basic realm="rtmp-auth"`)
+			http.Error(w, "401 Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userKey{}, user)))
+	})
+}
+
+type LoginData struct {
+	Config       ServerConfig
+	CsrfTemplate template.HTML
+	Errors       []error
+	Text         Text
+}
+
+// T returns the text of key in the locale of the request
+func (data LoginData) T(key string) string {
+	return data.Text.Get(key)
+}
+
+func LoginFormHandler(config ServerConfig) handleFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		config := requestConfig(r, config)
+		data := LoginData{
+			Config:       config,
+			CsrfTemplate: csrf.TemplateField(r),
+			Text:         requestText(config, r),
+		}
+		if err := templates.ExecuteTemplate(w, "login.html", data); err != nil {
+			log.Println("Template failed", err)
+		}
+	}
+}
+
+func LoginHandler(auth *adminAuth, config ServerConfig) handleFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		config := requestConfig(r, config)
+		user := r.PostFormValue("user")
+		if !auth.check(user, r.PostFormValue("password")) {
+			log.Printf("admin login of '%s' from %s failed\n", user, clientIP(r, auth.proxies))
+			w.WriteHeader(http.StatusUnauthorized)
+			data := LoginData{
+				Config:       config,
+				CsrfTemplate: csrf.TemplateField(r),
+				Errors:       []error{fmt.Errorf("invalid user or password")},
+				Text:         requestText(config, r),
+			}
+			if err := templates.ExecuteTemplate(w, "login.html", data); err != nil {
+				log.Println("Template failed", err)
+			}
+			return
+		}
+		expiry := time.Now().Add(sessionLifetime)
+		auth.setCookie(w, r, auth.newSession(user, expiry), expiry)
+		log.Printf("admin %s logged in from %s\n", user, clientIP(r, auth.proxies))
+		http.Redirect(w, r, config.Prefix+"/", http.StatusSeeOther)
+	}
+}
+
+func LogoutHandler(auth *adminAuth, config ServerConfig) handleFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		config := requestConfig(r, config)
+		auth.setCookie(w, r, "", time.Unix(0, 0))
+		http.Redirect(w, r, config.Prefix+"/login", http.StatusSeeOther)
+	}
+}
@@ -0,0 +1,29 @@
+package http
+
+import (
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestAdminCheck(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("hashed-secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := newAdminAuth(ServerConfig{Users: map[string]string{"alice": string(hash), "bob": "plain-secret"}}, []byte("secret"))
+	if !a.check("alice", "hashed-secret") || !a.check("bob", "plain-secret") {
+		t.Error("valid credentials rejected")
+	}
+	if a.check("alice", "plain-secret") || a.check("bob", "") {
+		t.Error("wrong password accepted")
+	}
+	if a.check("mallory", "") || a.check("mallory", "rtmp-auth dummy password") || a.check("", "") {
+		t.Error("unknown user accepted")
+	}
+	// Unknown users pay for a bcrypt comparison like the hashed ones
+	want, _ := bcrypt.Cost(hash)
+	if cost, err := bcrypt.Cost([]byte(a.dummy)); err != nil || cost != want {
+		t.Errorf("dummy password %q has cost %d, %v, want %d", a.dummy, cost, err, want)
+	}
+}
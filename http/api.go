@@ -0,0 +1,640 @@
+package http
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/voc/rtmp-auth/audit"
+	"github.com/voc/rtmp-auth/storage"
+	"github.com/voc/rtmp-auth/store"
+)
+
+// APIStream is the JSON representation of a stream
+type APIStream struct {
+	Id          string   `json:"id"`
+	Application string   `json:"application"`
+	Name        string   `json:"name"`
+	AuthKeys    []string `json:"auth_keys,omitempty"`
+	// PlayKey is only included with the keys, HasPlayKey tells if one is set
+	PlayKey     string   `json:"play_key,omitempty"`
+	HasPlayKey  bool     `json:"has_play_key"`
+	AuthExpire  int64    `json:"auth_expire"`
+	Blocked     bool     `json:"blocked"`
+	Disabled    bool     `json:"disabled"`
+	Active      bool     `json:"active"`
+	ActiveNames []string `json:"active_names,omitempty"`
+	Notes       string   `json:"notes"`
+	AllowedIPs  []string `json:"allowed_ips,omitempty"`
+	DeniedIPs   []string `json:"denied_ips,omitempty"`
+	// LastActive is the unix time of the last publish, 0 if never published
+	LastActive   int64 `json:"last_active"`
+	PublishCount int64 `json:"publish_count"`
+	// Publishers is the number of active publishers, at most MaxPublishers per name
+	Publishers    int32 `json:"publishers"`
+	MaxPublishers int32 `json:"max_publishers"`
+	// MaxBitrateKbps is the expected maximum bitrate, 0 if unlimited
+	MaxBitrateKbps int32 `json:"max_bitrate_kbps"`
+	// MaxSessionDuration is the session cap in seconds, SessionStarted the unix time the current session started
+	MaxSessionDuration int64    `json:"max_session_duration"`
+	BlockAfterSession  bool     `json:"block_after_session"`
+	SessionStarted     int64    `json:"session_started"`
+	Tags               []string `json:"tags,omitempty"`
+	// ActiveFrom and ActiveUntil are the unix times of the activation window, 0 if unbounded
+	ActiveFrom  int64 `json:"active_from"`
+	ActiveUntil int64 `json:"active_until"`
+	// Owner is the user managing the stream, empty if added without admin auth
+	Owner string `json:"owner,omitempty"`
+	// AllowedCountries are the country codes publishing is restricted to, empty for any
+	AllowedCountries []string `json:"allowed_countries,omitempty"`
+}
+
+func newAPIStream(stream *storage.Stream, includeKey bool) APIStream {
+	res := APIStream{
+		Id:             stream.Id,
+		Application:    stream.Application,
+		Name:           stream.Name,
+		AuthExpire:     stream.AuthExpire,
+		HasPlayKey:     stream.PlayKey != "",
+		Blocked:        stream.Blocked,
+		Disabled:       stream.Disabled,
+		Active:         stream.Active,
+		ActiveNames:    stream.ActiveNames,
+		Notes:          stream.Notes,
+		Owner:          stream.Owner,
+		AllowedIPs:     stream.AllowedIps,
+		DeniedIPs:      stream.DeniedIps,
+		LastActive:     stream.LastActive,
+		PublishCount:   stream.PublishCount,
+		Publishers:     store.ActivePublishers(stream),
+		MaxBitrateKbps: stream.MaxBitrateKbps,
+		MaxPublishers:  store.MaxPublishers(stream),
+
+		MaxSessionDuration: stream.MaxSessionDuration,
+		BlockAfterSession:  stream.BlockAfterSession,
+		SessionStarted:     stream.SessionStarted,
+		Tags:               stream.Tags,
+		ActiveFrom:         stream.ActiveFrom,
+		ActiveUntil:        stream.ActiveUntil,
+		AllowedCountries:   stream.AllowedCountries,
+	}
+	if includeKey {
+		res.AuthKeys = store.StreamKeys(stream)
+		res.PlayKey = stream.PlayKey
+	}
+	return res
+}
+
+// createdStream returns the keys as given, the store may only keep their hashes
+func createdStream(stream *storage.Stream, input StreamInput) APIStream {
+	created := newAPIStream(stream, false)
+	if input.AuthKey != "" {
+		created.AuthKeys = []string{input.AuthKey}
+	}
+	created.AuthKeys = append(created.AuthKeys, input.AuthKeys...)
+	created.PlayKey = input.PlayKey
+	return created
+}
+
+// writeJSON encodes value as the JSON response body
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		log.Println("json encode failed", err)
+	}
+}
+
+// writeJSONErrors responds with a list of error messages
+func writeJSONErrors(w http.ResponseWriter, status int, errs []error) {
+	messages := make([]string, 0, len(errs))
+	for _, err := range errs {
+		messages = append(messages, err.Error())
+	}
+	writeJSON(w, status, map[string][]string{"errors": messages})
+}
+
+// ListStreamsHandler returns all streams as JSON, ?tag= filters by tags and may be repeated.
+// Auth keys are only included when requested with ?include_key=true
+func ListStreamsHandler(store *store.Store, config ServerConfig) handleFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		state, err := store.Get()
+		if err != nil {
+			log.Println("get", err)
+			http.Error(w, "500 Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		sort.SliceStable(state.Streams, func(i, j int) bool {
+			return state.Streams[i].Name < state.Streams[j].Name
+		})
+
+		includeKey, _ := strconv.ParseBool(r.URL.Query().Get("include_key"))
+		filter := StreamFilter{Tags: parseTagFilter(r.URL.Query())}
+		streams := make([]APIStream, 0, len(state.Streams))
+		for _, stream := range filterStreams(ownedStreams(config, r, state.Streams), filter) {
+			streams = append(streams, newAPIStream(stream, includeKey))
+		}
+		writeJSON(w, http.StatusOK, streams)
+	}
+}
+
+// GetStreamHandler returns the stream with the id of the path as JSON, 404 if there is none the user may see.
+// Auth keys are only included when requested with ?include_key=true
+func GetStreamHandler(store *store.Store, config ServerConfig) handleFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		state, err := store.Get()
+		if err != nil {
+			log.Println("get", err)
+			http.Error(w, "500 Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		id := mux.Vars(r)["id"]
+		includeKey, _ := strconv.ParseBool(r.URL.Query().Get("include_key"))
+		for _, stream := range ownedStreams(config, r, state.Streams) {
+			if stream.Id == id {
+				writeJSON(w, http.StatusOK, newAPIStream(stream, includeKey))
+				return
+			}
+		}
+		writeJSONErrors(w, http.StatusNotFound, []error{fmt.Errorf("stream %v not found", id)})
+	}
+}
+
+// ActiveStream is the JSON representation of a live stream
+type ActiveStream struct {
+	Id          string `json:"id"`
+	Application string `json:"application"`
+	Name        string `json:"name"`
+	// ActiveNames are the names live under a pattern stream
+	ActiveNames []string `json:"active_names,omitempty"`
+	// Since is the unix time the current session started
+	Since      int64 `json:"since"`
+	Publishers int32 `json:"publishers"`
+}
+
+func newActiveStream(stream *storage.Stream) ActiveStream {
+	return ActiveStream{
+		Id:          stream.Id,
+		Application: stream.Application,
+		Name:        stream.Name,
+		ActiveNames: stream.ActiveNames,
+		Since:       stream.LastActive,
+		Publishers:  store.ActivePublishers(stream),
+	}
+}
+
+// ActiveStreamsHandler returns only the live streams, for widgets polling who is live.
+// Responses carry an ETag, so unchanged polls are answered with 304 Not Modified
+func ActiveStreamsHandler(store *store.Store, config ServerConfig) handleFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		state, err := store.Get()
+		if err != nil {
+			log.Println("get", err)
+			http.Error(w, "500 Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		streams := make([]ActiveStream, 0)
+		for _, stream := range ownedStreams(config, r, state.Streams) {
+			if !stream.Active {
+				continue
+			}
+			streams = append(streams, newActiveStream(stream))
+		}
+		sort.SliceStable(streams, func(i, j int) bool {
+			if streams[i].Application != streams[j].Application {
+				return streams[i].Application < streams[j].Application
+			}
+			return streams[i].Name < streams[j].Name
+		})
+
+		body, err := json.Marshal(streams)
+		if err != nil {
+			log.Println("json encode failed", err)
+			http.Error(w, "500 Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		sum := sha256.Sum256(body)
+		etag := `"` + hex.EncodeToString(sum[:8]) + `"`
+		w.Header().Set("ETag", etag)
+		// Caches have to revalidate, the streams may go live any moment
+		w.Header().Set("Cache-Control", "private, no-cache")
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(append(body, '\n'))
+	}
+}
+
+// CreateStreamHandler adds a stream from a JSON body
+func CreateStreamHandler(store *store.Store, config ServerConfig, auditLog *audit.Log) handleFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		// Requiring JSON keeps cross-site form posts out, as there is no CSRF token
+		mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil || mediaType != "application/json" {
+			writeJSONErrors(w, http.StatusUnsupportedMediaType,
+				[]error{fmt.Errorf("content type must be application/json")})
+			return
+		}
+
+		var input StreamInput
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			writeJSONErrors(w, http.StatusBadRequest, []error{fmt.Errorf("invalid body: %w", err)})
+			return
+		}
+
+		stream, errs := validateStream(input, config)
+		if len(errs) > 0 {
+			writeJSONErrors(w, http.StatusBadRequest, errs)
+			return
+		}
+		if stream.Owner, err = streamOwner(config, r, input.Owner); err != nil {
+			writeJSONErrors(w, http.StatusBadRequest, []error{err})
+			return
+		}
+		if err := checkCollision(store, stream, ""); err != nil {
+			writeJSONErrors(w, http.StatusConflict, []error{err})
+			return
+		}
+
+		if err := store.AddStream(stream); isDuplicate(err) || isQuotaReached(err) {
+			writeJSONErrors(w, http.StatusConflict, []error{err})
+			return
+		} else if err != nil {
+			log.Println(err)
+			writeJSONErrors(w, http.StatusInternalServerError, []error{fmt.Errorf("failed to add stream: %w", err)})
+			return
+		}
+		auditLog.Record(audit.Entry{Action: "add", Id: stream.Id, Application: stream.Application, Name: stream.Name, User: requestUser(r)})
+
+		writeJSON(w, http.StatusCreated, createdStream(stream, input))
+	}
+}
+
+// BlockRequest selects the streams to block or unblock by application and name
+type BlockRequest struct {
+	Application string `json:"application"`
+	Name        string `json:"name"`
+	Blocked     bool   `json:"blocked"`
+	// Force drops the publishers through the rtmp server api after blocking, see KickConfig
+	Force bool `json:"force"`
+}
+
+// BlockResult is a stream changed by BlockByNameHandler, Kick is set if the block was forced
+type BlockResult struct {
+	APIStream
+	Kick *KickResult `json:"kick,omitempty"`
+}
+
+func isNotFound(err error) bool {
+	return errors.Is(err, store.ErrNotFound)
+}
+
+func neverExpires(err error) bool {
+	return errors.Is(err, store.ErrNeverExpires)
+}
+
+// BlockByNameHandler blocks or unblocks the streams of an application and name from a JSON body
+// and returns them, for automation which doesn't know stream ids
+func BlockByNameHandler(store *store.Store, config ServerConfig, kick *kicker, auditLog *audit.Log) handleFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil || mediaType != "application/json" {
+			writeJSONErrors(w, http.StatusUnsupportedMediaType,
+				[]error{fmt.Errorf("content type must be application/json")})
+			return
+		}
+
+		var input BlockRequest
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			writeJSONErrors(w, http.StatusBadRequest, []error{fmt.Errorf("invalid body: %w", err)})
+			return
+		}
+		if input.Application == "" || input.Name == "" {
+			writeJSONErrors(w, http.StatusBadRequest, []error{fmt.Errorf("application and name must be set")})
+			return
+		}
+		if input.Force && kick == nil {
+			writeJSONErrors(w, http.StatusBadRequest, []error{errNoKick})
+			return
+		}
+
+		err = checkOwnerByName(r, store, config, input.Application, input.Name)
+		var streams []*storage.Stream
+		if err == nil {
+			streams, err = store.SetBlockedByName(input.Application, input.Name, input.Blocked)
+		}
+		if isNotFound(err) {
+			writeJSONErrors(w, http.StatusNotFound, []error{err})
+			return
+		} else if err != nil {
+			log.Println(err)
+			writeJSONErrors(w, http.StatusInternalServerError, []error{fmt.Errorf("failed to block stream: %w", err)})
+			return
+		}
+
+		action := "unblock"
+		if input.Blocked {
+			action = "block"
+		}
+		res := make([]BlockResult, 0, len(streams))
+		for _, stream := range streams {
+			slog.Info("stream", "action", action, "id", stream.Id, "app", stream.Application, "name", stream.Name)
+			auditLog.Record(audit.Entry{Action: action, Id: stream.Id, Application: stream.Application, Name: stream.Name, User: requestUser(r)})
+			result := BlockResult{APIStream: newAPIStream(stream, false)}
+			if input.Force && input.Blocked {
+				kicked := kickStream(kick, stream.Id, stream.Application, stream.Name, r, auditLog)
+				result.Kick = &kicked
+			}
+			res = append(res, result)
+		}
+		writeJSON(w, http.StatusOK, res)
+	}
+}
+
+// ApplicationBlockRequest blocks or unblocks all streams of the application in the path
+type ApplicationBlockRequest struct {
+	Blocked bool `json:"blocked"`
+}
+
+// ApplicationBlockResult counts the streams of the application and those whose blocked state changed
+type ApplicationBlockResult struct {
+	Application string `json:"application"`
+	Blocked     bool   `json:"blocked"`
+	Streams     int    `json:"streams"`
+	Changed     int    `json:"changed"`
+}
+
+// BlockApplicationHandler blocks or unblocks every stream of the application from the path at once,
+// e.g. during an incident. Repeating a request changes nothing
+func BlockApplicationHandler(store *store.Store, auditLog *audit.Log) handleFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil || mediaType != "application/json" {
+			writeJSONErrors(w, http.StatusUnsupportedMediaType,
+				[]error{fmt.Errorf("content type must be application/json")})
+			return
+		}
+
+		var input ApplicationBlockRequest
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			writeJSONErrors(w, http.StatusBadRequest, []error{fmt.Errorf("invalid body: %w", err)})
+			return
+		}
+
+		app := mux.Vars(r)["app"]
+		matched, changed, err := store.SetBlockedByApplication(app, input.Blocked)
+		if isNotFound(err) {
+			writeJSONErrors(w, http.StatusNotFound, []error{err})
+			return
+		} else if err != nil {
+			log.Println(err)
+			writeJSONErrors(w, http.StatusInternalServerError, []error{fmt.Errorf("failed to block application: %w", err)})
+			return
+		}
+
+		action := "unblock application"
+		if input.Blocked {
+			action = "block application"
+		}
+		slog.Info("stream", "action", action, "app", app, "streams", matched, "changed", len(changed))
+		auditLog.Record(audit.Entry{Action: action, Application: app, User: requestUser(r)})
+		writeJSON(w, http.StatusOK, ApplicationBlockResult{
+			Application: app,
+			Blocked:     input.Blocked,
+			Streams:     matched,
+			Changed:     len(changed),
+		})
+	}
+}
+
+// Maintenance is the maintenance mode, while it's enabled new publishes are denied
+type Maintenance struct {
+	Enabled bool `json:"enabled"`
+}
+
+// MaintenanceStatusHandler returns whether maintenance mode is on
+func MaintenanceStatusHandler(store *store.Store) handleFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		state, err := store.Get()
+		if err != nil {
+			log.Println(err)
+			writeJSONErrors(w, http.StatusInternalServerError, []error{fmt.Errorf("failed to read state")})
+			return
+		}
+		writeJSON(w, http.StatusOK, Maintenance{Enabled: state.Maintenance})
+	}
+}
+
+// SetMaintenanceHandler turns maintenance mode on or off, e.g. {"enabled": true}
+func SetMaintenanceHandler(store *store.Store, auditLog *audit.Log) handleFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil || mediaType != "application/json" {
+			writeJSONErrors(w, http.StatusUnsupportedMediaType,
+				[]error{fmt.Errorf("content type must be application/json")})
+			return
+		}
+
+		var input Maintenance
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			writeJSONErrors(w, http.StatusBadRequest, []error{fmt.Errorf("invalid body: %w", err)})
+			return
+		}
+
+		if err := store.SetMaintenance(input.Enabled); err != nil {
+			log.Println(err)
+			writeJSONErrors(w, http.StatusInternalServerError, []error{fmt.Errorf("failed to set maintenance mode: %w", err)})
+			return
+		}
+
+		action := "end maintenance"
+		if input.Enabled {
+			action = "start maintenance"
+		}
+		slog.Info("maintenance", "action", action)
+		auditLog.Record(audit.Entry{Action: action, User: requestUser(r)})
+		writeJSON(w, http.StatusOK, input)
+	}
+}
+
+// ExtendRequest carries the new expiry of a stream, an ISO8601 duration added to the current expiry,
+// an absolute RFC3339 time or "never"
+type ExtendRequest struct {
+	AuthExpire string `json:"auth_expire"`
+}
+
+// ExtendHandler changes the expiry of the stream with the id from the path and returns the stream,
+// e.g. {"auth_expire": "PT30M"} pushes the expiry back by 30 minutes
+func ExtendHandler(store *store.Store, config ServerConfig, auditLog *audit.Log) handleFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil || mediaType != "application/json" {
+			writeJSONErrors(w, http.StatusUnsupportedMediaType,
+				[]error{fmt.Errorf("content type must be application/json")})
+			return
+		}
+
+		var input ExtendRequest
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			writeJSONErrors(w, http.StatusBadRequest, []error{fmt.Errorf("invalid body: %w", err)})
+			return
+		}
+
+		var expiry int64
+		var by time.Duration
+		if input.AuthExpire == "never" {
+			expiry = -1
+		} else if d, ok := parseISODuration(input.AuthExpire); ok && d > 0 {
+			by = d
+		} else if parsed := parseExpiry(input.AuthExpire); input.AuthExpire != "" && parsed != nil {
+			expiry = *parsed
+		} else {
+			writeJSONErrors(w, http.StatusBadRequest, []error{fmt.Errorf("invalid auth expiry: '%v'", input.AuthExpire)})
+			return
+		}
+
+		id := mux.Vars(r)["id"]
+		err = checkOwner(r, store, config, id)
+		var stream *storage.Stream
+		if err == nil {
+			stream, err = store.ExtendExpiry(id, expiry, by)
+		}
+		if isNotFound(err) {
+			writeJSONErrors(w, http.StatusNotFound, []error{err})
+			return
+		} else if neverExpires(err) {
+			writeJSONErrors(w, http.StatusConflict, []error{err})
+			return
+		} else if err != nil {
+			log.Println(err)
+			writeJSONErrors(w, http.StatusInternalServerError, []error{fmt.Errorf("failed to extend stream: %w", err)})
+			return
+		}
+
+		slog.Info("stream", "action", "extend", "id", stream.Id, "app", stream.Application, "name", stream.Name,
+			"expiry", formatExpiry(stream.AuthExpire))
+		auditLog.Record(audit.Entry{Action: "extend", Id: stream.Id, Application: stream.Application, Name: stream.Name, User: requestUser(r)})
+		writeJSON(w, http.StatusOK, newAPIStream(stream, false))
+	}
+}
+
+// TokenRequest selects the stream and validity of a new publish token
+type TokenRequest struct {
+	Application string `json:"application"`
+	Name        string `json:"name"`
+	// AuthExpire is an ISO8601 duration or RFC3339 time, like the expiry of streams
+	AuthExpire string `json:"auth_expire"`
+}
+
+// TokenHandler issues signed publish tokens from a JSON body, one-time tokens if config.OneTimeTokens is set
+func TokenHandler(config ServerConfig) handleFunc {
+	secret := []byte(config.TokenSecret)
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil || mediaType != "application/json" {
+			writeJSONErrors(w, http.StatusUnsupportedMediaType,
+				[]error{fmt.Errorf("content type must be application/json")})
+			return
+		}
+
+		var input TokenRequest
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			writeJSONErrors(w, http.StatusBadRequest, []error{fmt.Errorf("invalid body: %w", err)})
+			return
+		}
+
+		var errs []error
+		if input.Name == "" {
+			errs = append(errs, fmt.Errorf("stream name must be set"))
+		}
+		// Tokens can't be revoked, so they always expire
+		expiry := parseExpiry(input.AuthExpire)
+		if input.AuthExpire == "" || expiry == nil || *expiry == -1 {
+			errs = append(errs, fmt.Errorf("invalid auth expiry: '%v'", input.AuthExpire))
+		}
+		if len(errs) > 0 {
+			writeJSONErrors(w, http.StatusBadRequest, errs)
+			return
+		}
+
+		token := store.MintToken(secret, input.Application, input.Name, time.Unix(*expiry, 0))
+		if config.OneTimeTokens {
+			if token, err = store.MintOnceToken(secret, input.Application, input.Name, time.Unix(*expiry, 0)); err != nil {
+				log.Println("mint token:", err)
+				writeJSONErrors(w, http.StatusInternalServerError, []error{fmt.Errorf("failed to mint token")})
+				return
+			}
+		}
+		log.Printf("Issued token for %v/%v, expires %v", input.Application, input.Name, formatExpiry(*expiry))
+		writeJSON(w, http.StatusCreated, map[string]interface{}{
+			"token":       token,
+			"auth_expire": *expiry,
+		})
+	}
+}
+
+// CheckResponse is the result of a dry-run auth check
+type CheckResponse struct {
+	Authorized bool   `json:"authorized"`
+	Reason     string `json:"reason"`
+}
+
+// CheckHandler runs the publish auth for app, name and auth from the query without marking the
+// stream active or counting wrong keys. Streams with ip restrictions need the ip parameter to pass
+func CheckHandler(store *store.Store, config ServerConfig) handleFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if query.Get("name") == "" {
+			writeJSONErrors(w, http.StatusBadRequest, []error{fmt.Errorf("stream name must be set")})
+			return
+		}
+		// Checks must not count towards the auto-block or write upgraded keys
+		result := store.DryRunAuth(r.Context(), query.Get("app"), query.Get("name"), query.Get("auth"), normalizeIP(query.Get("ip")))
+		// Streams of other owners look like missing ones
+		if result.Id != "" && checkOwner(r, store, config, result.Id) != nil {
+			result = notFoundResult
+		}
+		writeJSON(w, http.StatusOK, CheckResponse{
+			Authorized: result.Authorized,
+			Reason:     result.Reason.String(),
+		})
+	}
+}
+
+// AuditHandler returns the recent audit entries as JSON, newest first
+func AuditHandler(auditLog *audit.Log) handleFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		entries := auditLog.Recent()
+		if entries == nil {
+			entries = []audit.Entry{}
+		}
+		writeJSON(w, http.StatusOK, entries)
+	}
+}
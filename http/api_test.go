@@ -0,0 +1,49 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Wrong keys sent to /api/check never auto-block a stream, unlike wrong publish keys
+func TestCheckHandlerDoesNotBlock(t *testing.T) {
+	s := newTestStore(t)
+	stream := addTestStream(t, s, "live", "foo", "secret123")
+	s.SetAutoBlock(3, time.Minute)
+	blocked := func() bool {
+		t.Helper()
+		state, err := s.Get()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return state.Streams[0].Blocked
+	}
+
+	handler := CheckHandler(s, ServerConfig{})
+	for i := 0; i < 10; i++ {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest(http.MethodGet, "/api/check?app=live&name=foo&auth=wrong", nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("check answered %d, want 200", w.Code)
+		}
+	}
+	if blocked() {
+		t.Fatal("stream blocked by checks")
+	}
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodGet, "/api/check?app=live&name=foo&auth=secret123", nil))
+	if body := w.Body.String(); body != "{\"authorized\":true,\"reason\":\"ok\"}\n" {
+		t.Errorf("check with the key answered %s, want authorized", body)
+	}
+
+	// The same wrong keys as publishes do block
+	publish := NginxAuthHandler(s, ServerConfig{})
+	for i := 0; i < 3; i++ {
+		postAuth(publish, "/auth/nginx", "application/x-www-form-urlencoded", "app=live&name=foo&call=publish&auth=wrong")
+	}
+	if !blocked() {
+		t.Errorf("stream %s not blocked by wrong publish keys", stream.Id)
+	}
+}
@@ -0,0 +1,93 @@
+package http
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/voc/rtmp-auth/store"
+)
+
+// trustedProxies are networks whose X-Forwarded-For and X-Real-IP headers are honored
+type trustedProxies []*net.IPNet
+
+// parseTrustedProxies parses addresses and CIDRs, invalid entries are logged and skipped
+func parseTrustedProxies(values []string) trustedProxies {
+	var proxies trustedProxies
+	for _, value := range values {
+		cidr, ok := store.ParseNetwork(strings.TrimSpace(value))
+		if !ok {
+			log.Printf("Ignoring invalid trusted proxy '%s'\n", value)
+			continue
+		}
+		_, network, _ := net.ParseCIDR(cidr)
+		proxies = append(proxies, network)
+	}
+	return proxies
+}
+
+func (proxies trustedProxies) contains(ip net.IP) bool {
+	for _, network := range proxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseIP parses an address which may carry brackets, a port or an IPv6 zone as found in headers
+func parseIP(value string) net.IP {
+	value = strings.TrimSpace(value)
+	if host, _, err := net.SplitHostPort(value); err == nil {
+		value = host
+	}
+	value = strings.TrimSuffix(strings.TrimPrefix(value, "["), "]")
+	if i := strings.IndexByte(value, '%'); i != -1 {
+		value = value[:i]
+	}
+	return net.ParseIP(value)
+}
+
+// normalizeIP returns the canonical form of an address, IPv4-mapped IPv6
+// addresses become plain IPv4 and IPv6 is compressed. Unparseable values are returned as is
+func normalizeIP(value string) string {
+	ip := parseIP(value)
+	if ip == nil {
+		return value
+	}
+	return ip.String()
+}
+
+// clientIP returns the canonical address of the requesting client.
+// X-Forwarded-For and X-Real-IP are only honored if the connection comes from a trusted proxy,
+// the rightmost untrusted X-Forwarded-For entry is used since earlier entries may be spoofed
+func clientIP(r *http.Request, proxies trustedProxies) string {
+	ip := parseIP(r.RemoteAddr)
+	if ip == nil {
+		return r.RemoteAddr
+	}
+	if !proxies.contains(ip) {
+		return ip.String()
+	}
+
+	forwarded := strings.Join(r.Header.Values("X-Forwarded-For"), ",")
+	if forwarded == "" {
+		if real := parseIP(r.Header.Get("X-Real-IP")); real != nil {
+			return real.String()
+		}
+		return ip.String()
+	}
+	hops := strings.Split(forwarded, ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := parseIP(hops[i])
+		if hop == nil {
+			break
+		}
+		ip = hop
+		if !proxies.contains(hop) {
+			break
+		}
+	}
+	return ip.String()
+}
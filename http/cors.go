@@ -0,0 +1,123 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSConfig allows browser apps on other origins to call the JSON API, only the same origin may if Origins is empty
+type CORSConfig struct {
+	// Origins like https://dashboard.example.com allowed to call the api, "*" allows any
+	Origins []string `toml:"origins"`
+	// Methods allowed in preflights, GET and POST if empty
+	Methods []string `toml:"methods"`
+	// Headers allowed in preflights, Content-Type and Authorization if empty
+	Headers []string `toml:"headers"`
+	// Credentials sends cookies and basic auth along, needed with auth-mode. Requires explicit origins
+	Credentials bool `toml:"credentials"`
+	// MaxAge is how long browsers may cache a preflight
+	MaxAge time.Duration `toml:"max-age"`
+}
+
+var (
+	defaultCORSMethods = []string{"GET", "POST"}
+	defaultCORSHeaders = []string{"Content-Type", "Authorization"}
+)
+
+// checkCORS validates the allowed origins, they are compared to the Origin header as is
+func checkCORS(config ServerConfig) error {
+	for _, origin := range config.CORS.Origins {
+		if origin == "*" {
+			if config.CORS.Credentials {
+				return fmt.Errorf("cors: origin \"*\" can't be used with credentials")
+			}
+			continue
+		}
+		u, err := url.Parse(origin)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" ||
+			u.Path != "" || u.RawQuery != "" || u.User != nil {
+			return fmt.Errorf("cors: invalid origin %q, use scheme://host[:port]", origin)
+		}
+	}
+	return nil
+}
+
+// apiCORS answers preflights and adds the CORS headers to requests to the api below prefix.
+// It wraps the router, preflights match no route and carry no credentials for the auth middleware
+func apiCORS(config ServerConfig) func(next http.Handler) http.Handler {
+	cors := config.CORS
+	methods := cors.Methods
+	if len(methods) == 0 {
+		methods = defaultCORSMethods
+	}
+	headers := cors.Headers
+	if len(headers) == 0 {
+		headers = defaultCORSHeaders
+	}
+	return func(next http.Handler) http.Handler {
+		if len(cors.Origins) == 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !strings.HasPrefix(r.URL.Path, config.Prefix+"/api/") {
+				next.ServeHTTP(w, r)
+				return
+			}
+			origin := r.Header.Get("Origin")
+			w.Header().Add("Vary", "Origin")
+			allowed := origin != "" && (slices.Contains(cors.Origins, origin) || slices.Contains(cors.Origins, "*"))
+			preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+			if !allowed {
+				if preflight {
+					http.Error(w, "403 Forbidden", http.StatusForbidden)
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if cors.Credentials {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+			} else if slices.Contains(cors.Origins, "*") {
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+			} else {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+			}
+			if !preflight {
+				// Polls of /api/active compare the ETag
+				w.Header().Set("Access-Control-Expose-Headers", "ETag")
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			method := r.Header.Get("Access-Control-Request-Method")
+			if !containsFold(methods, method) {
+				http.Error(w, "403 Forbidden", http.StatusForbidden)
+				return
+			}
+			for _, header := range strings.Split(r.Header.Get("Access-Control-Request-Headers"), ",") {
+				if header = strings.TrimSpace(header); header != "" && !containsFold(headers, header) {
+					http.Error(w, "403 Forbidden", http.StatusForbidden)
+					return
+				}
+			}
+			w.Header().Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+			w.Header().Set("Access-Control-Allow-Headers", strings.Join(headers, ", "))
+			if cors.MaxAge > 0 {
+				w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(cors.MaxAge.Seconds())))
+			}
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+}
+
+// containsFold reports whether values contains value ignoring case, as methods and header names are compared
+func containsFold(values []string, value string) bool {
+	return slices.ContainsFunc(values, func(v string) bool { return strings.EqualFold(v, value) })
+}
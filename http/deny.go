@@ -0,0 +1,80 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+
+	"github.com/voc/rtmp-auth/store"
+)
+
+// DenyResponse is the answer to an auth request denied for a reason
+type DenyResponse struct {
+	// Status must be a 4xx or 5xx code, every supported rtmp server rejects those
+	Status int `toml:"status"`
+	// Body is sent instead of the status text if set
+	Body string `toml:"body"`
+}
+
+// denyDefault holds the responses applying to all rtmp servers in ServerConfig.DenyResponses
+const denyDefault = "default"
+
+// handlerReasons are the reasons the auth handler denies requests for besides the store's auth reasons
+var handlerReasons = []string{"publisher_limit", "rate_limited", "invalid_request", "unknown_action"}
+
+// isDenyReason reports whether requests can be denied for reason
+func isDenyReason(reason string) bool {
+	if parsed, ok := store.ParseReason(reason); ok {
+		return parsed != store.ReasonOK
+	}
+	return slices.Contains(handlerReasons, reason)
+}
+
+// checkDenyResponses verifies the configured deny responses are for known rtmp servers and reasons
+// and can't be mistaken for success
+func checkDenyResponses(config ServerConfig) error {
+	for backend, responses := range config.DenyResponses {
+		if _, ok := authBackends[backend]; !ok && backend != denyDefault {
+			return fmt.Errorf("deny-responses: unknown backend %q, use default, nginx, srs, mediamtx or nms", backend)
+		}
+		for reason, response := range responses {
+			if !isDenyReason(reason) {
+				return fmt.Errorf("deny-responses.%s: unknown reason %q", backend, reason)
+			}
+			if response.Status != 0 && (response.Status < 400 || response.Status > 599) {
+				return fmt.Errorf("deny-responses.%s.%s: status %d is not a 4xx or 5xx code",
+					backend, reason, response.Status)
+			}
+		}
+	}
+	return nil
+}
+
+// denyResponse returns the configured response for reason, the backend specific one takes precedence.
+// status is used if none is configured
+func denyResponse(config ServerConfig, backend authBackend, reason string, status int) DenyResponse {
+	res := DenyResponse{Status: status}
+	for _, key := range []string{denyDefault, string(backend)} {
+		configured, ok := config.DenyResponses[key][reason]
+		if !ok {
+			continue
+		}
+		if configured.Status != 0 {
+			res.Status = configured.Status
+		}
+		if configured.Body != "" {
+			res.Body = configured.Body
+		}
+	}
+	return res
+}
+
+// writeDenial answers a denied auth request with the response configured for reason
+func writeDenial(w http.ResponseWriter, config ServerConfig, backend authBackend, reason string, status int) {
+	res := denyResponse(config, backend, reason, status)
+	if res.Body == "" {
+		writeAuthResponse(w, backend, res.Status)
+		return
+	}
+	http.Error(w, res.Body, res.Status)
+}
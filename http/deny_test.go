@@ -0,0 +1,43 @@
+package http
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestCheckDenyResponses(t *testing.T) {
+	tests := []struct {
+		responses map[string]map[string]DenyResponse
+		ok        bool
+	}{
+		{map[string]map[string]DenyResponse{"default": {"blocked": {Status: 403}}}, true},
+		{map[string]map[string]DenyResponse{"srs": {"expired": {Status: 403, Body: "expired"}, "rate_limited": {}}}, true},
+		{map[string]map[string]DenyResponse{"nginx": {"unknown_action": {Status: 400}, "publisher_limit": {Status: 409}}}, true},
+		{map[string]map[string]DenyResponse{"ngnix": {"blocked": {Status: 403}}}, false},
+		{map[string]map[string]DenyResponse{"default": {"blokced": {Status: 403}}}, false},
+		{map[string]map[string]DenyResponse{"default": {"ok": {Status: 403}}}, false},
+		{map[string]map[string]DenyResponse{"default": {"blocked": {Status: 200}}}, false},
+	}
+	for _, test := range tests {
+		err := checkDenyResponses(ServerConfig{DenyResponses: test.responses})
+		if (err == nil) != test.ok {
+			t.Errorf("checkDenyResponses(%v) = %v, want ok %v", test.responses, err, test.ok)
+		}
+	}
+}
+
+func TestDenyResponsePrecedence(t *testing.T) {
+	config := ServerConfig{DenyResponses: map[string]map[string]DenyResponse{
+		"default": {"blocked": {Status: 403, Body: "blocked"}},
+		"srs":     {"blocked": {Body: "stream blocked"}},
+	}}
+	if res := denyResponse(config, backendNginx, "blocked", http.StatusUnauthorized); res != (DenyResponse{403, "blocked"}) {
+		t.Errorf("nginx got %v, want the default", res)
+	}
+	if res := denyResponse(config, backendSRS, "blocked", http.StatusUnauthorized); res != (DenyResponse{403, "stream blocked"}) {
+		t.Errorf("srs got %v, want its body with the default status", res)
+	}
+	if res := denyResponse(config, backendSRS, "bad_key", http.StatusUnauthorized); res != (DenyResponse{Status: 401}) {
+		t.Errorf("unconfigured reason got %v, want 401", res)
+	}
+}
@@ -0,0 +1,71 @@
+package http
+
+import (
+	"encoding/csv"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/voc/rtmp-auth/storage"
+	"github.com/voc/rtmp-auth/store"
+)
+
+var exportHeader = []string{"id", "application", "name", "auth_expire", "blocked", "disabled", "active", "notes", "owner"}
+
+// exportRecord returns the CSV columns of a stream
+func exportRecord(stream *storage.Stream, includeKey bool) []string {
+	record := []string{
+		stream.Id,
+		stream.Application,
+		stream.Name,
+		formatExpiry(stream.AuthExpire),
+		strconv.FormatBool(stream.Blocked),
+		strconv.FormatBool(stream.Disabled),
+		strconv.FormatBool(stream.Active),
+		stream.Notes,
+		stream.Owner,
+	}
+	if includeKey {
+		record = append(record, strings.Join(store.StreamKeys(stream), " "), stream.PlayKey)
+	}
+	return record
+}
+
+// ExportHandler writes the streams the user may see as CSV, auth keys are only included with ?include_key=true
+func ExportHandler(store *store.Store, config ServerConfig) handleFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		state, err := store.Get()
+		if err != nil {
+			log.Println("get", err)
+			http.Error(w, "500 Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		sort.SliceStable(state.Streams, func(i, j int) bool {
+			return state.Streams[i].Name < state.Streams[j].Name
+		})
+		includeKey, _ := strconv.ParseBool(r.URL.Query().Get("include_key"))
+
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="streams.csv"`)
+
+		// The csv writer flushes its buffer to the response as it fills up
+		out := csv.NewWriter(w)
+		header := exportHeader
+		if includeKey {
+			header = append(header[:len(header):len(header)], "auth_keys", "play_key")
+		}
+		out.Write(header)
+		for _, stream := range ownedStreams(config, r, state.Streams) {
+			if err := out.Write(exportRecord(stream, includeKey)); err != nil {
+				log.Println("export:", err)
+				return
+			}
+		}
+		out.Flush()
+		if err := out.Error(); err != nil {
+			log.Println("export:", err)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package http
+
+import (
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/voc/rtmp-auth/store"
+)
+
+// eventHistory keeps the most recent stream events in a ring buffer
+type eventHistory struct {
+	mutex  sync.Mutex
+	events []store.Event
+	// next is the position the next event is written to once the buffer is full
+	next  int
+	limit int
+}
+
+func newEventHistory(limit int) *eventHistory {
+	if limit <= 0 {
+		limit = 50
+	}
+	return &eventHistory{limit: limit}
+}
+
+// record adds an event, replacing the oldest one if the history is full
+func (h *eventHistory) record(event store.Event) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if len(h.events) < h.limit {
+		h.events = append(h.events, event)
+		return
+	}
+	h.events[h.next] = event
+	h.next = (h.next + 1) % h.limit
+}
+
+// recent returns the events newest first
+func (h *eventHistory) recent() []store.Event {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	res := make([]store.Event, 0, len(h.events))
+	for i := len(h.events) - 1; i >= 0; i-- {
+		res = append(res, h.events[(h.next+i)%len(h.events)])
+	}
+	return res
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Link    atomLink    `xml:"link"`
+	Author  atomAuthor  `xml:"author"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomEntry struct {
+	Title   string `xml:"title"`
+	ID      string `xml:"id"`
+	Updated string `xml:"updated"`
+	Summary string `xml:"summary"`
+}
+
+// FeedHandler serves the recent publish, unpublish and expiring events as Atom feed.
+// The history is kept in memory and starts empty on restart
+func FeedHandler(history *eventHistory, config ServerConfig) handleFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		config := requestConfig(r, config)
+		scheme := "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+		self := fmt.Sprintf("%s://%s%s/feed.atom", scheme, r.Host, config.Prefix)
+
+		events := history.recent()
+		feed := atomFeed{
+			Title:   "rtmp-auth stream activity",
+			ID:      self,
+			Updated: time.Now().UTC().Format(time.RFC3339),
+			Link:    atomLink{Href: self, Rel: "self"},
+			Author:  atomAuthor{Name: "rtmp-auth"},
+			Entries: make([]atomEntry, 0, len(events)),
+		}
+		if len(events) > 0 {
+			feed.Updated = time.Unix(events[0].Timestamp, 0).UTC().Format(time.RFC3339)
+		}
+		for _, event := range events {
+			timestamp := time.Unix(event.Timestamp, 0).UTC()
+			title := fmt.Sprintf("%s/%s started", event.Application, event.Name)
+			summary := fmt.Sprintf("%s/%s started publishing at %s", event.Application, event.Name,
+				timestamp.Format(time.RFC1123))
+			switch event.Action {
+			case store.EventUnpublish:
+				title = fmt.Sprintf("%s/%s stopped", event.Application, event.Name)
+				summary = fmt.Sprintf("%s/%s stopped publishing at %s", event.Application, event.Name,
+					timestamp.Format(time.RFC1123))
+			case store.EventExpiring:
+				title = fmt.Sprintf("%s/%s expires soon", event.Application, event.Name)
+				summary = fmt.Sprintf("The key of %s/%s expires at %s", event.Application, event.Name,
+					time.Unix(event.AuthExpire, 0).UTC().Format(time.RFC1123))
+			case store.EventAutoBlock:
+				title = fmt.Sprintf("%s/%s auto-blocked", event.Application, event.Name)
+				summary = fmt.Sprintf("%s/%s was blocked at %s after %d wrong keys", event.Application, event.Name,
+					timestamp.Format(time.RFC1123), event.Failures)
+			case store.EventBlock, store.EventUnblock, store.EventDisable, store.EventEnable:
+				verb := strings.TrimSuffix(event.Action, "e") + "ed"
+				title = fmt.Sprintf("%s/%s %s", event.Application, event.Name, verb)
+				summary = fmt.Sprintf("%s/%s was %s at %s", event.Application, event.Name, verb,
+					timestamp.Format(time.RFC1123))
+			}
+			feed.Entries = append(feed.Entries, atomEntry{
+				Title:   title,
+				ID:      fmt.Sprintf("urn:rtmp-auth:%s:%s:%d:%s", event.Id, event.Action, event.Timestamp, event.Name),
+				Updated: timestamp.Format(time.RFC3339),
+				Summary: summary,
+			})
+		}
+
+		w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+		w.Write([]byte(xml.Header))
+		if err := xml.NewEncoder(w).Encode(feed); err != nil {
+			log.Println("feed:", err)
+		}
+	}
+}
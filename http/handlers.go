@@ -1,26 +1,36 @@
 package http
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log"
+	"log/slog"
+	"mime"
 	"net/http"
 	"net/url"
 	"regexp"
+	"slices"
 	"sort"
 	"strconv"
+	"strings"
 	"time"
+	"unicode"
 
 	"github.com/gorilla/csrf"
+	"github.com/voc/rtmp-auth/audit"
 	"github.com/voc/rtmp-auth/storage"
 	"github.com/voc/rtmp-auth/store"
+	"github.com/voc/rtmp-auth/webhook"
 )
 
 type handleFunc func(http.ResponseWriter, *http.Request)
 
-var durationRegex = regexp.MustCompile(`P([\d\.]+Y)?([\d\.]+M)?([\d\.]+D)?T?([\d\.]+H)?([\d\.]+M)?([\d\.]+?S)?`)
+// durationRegex matches ISO8601 durations, M before the T separator means
+// months and M after it means minutes
+var durationRegex = regexp.MustCompile(`^P([\d\.]+Y)?([\d\.]+M)?([\d\.]+D)?(?:T([\d\.]+H)?([\d\.]+M)?([\d\.]+S)?)?$`)
 
 func parseDurationPart(value string, unit time.Duration) time.Duration {
 	if len(value) != 0 {
@@ -31,24 +41,31 @@ func parseDurationPart(value string, unit time.Duration) time.Duration {
 	return 0
 }
 
+// parseISODuration parses an ISO8601 duration, ok is false if str isn't one
+func parseISODuration(str string) (d time.Duration, ok bool) {
+	matches := durationRegex.FindStringSubmatch(str)
+	if matches == nil {
+		return 0, false
+	}
+	years := parseDurationPart(matches[1], time.Hour*24*365)
+	months := parseDurationPart(matches[2], time.Hour*24*30)
+	days := parseDurationPart(matches[3], time.Hour*24)
+	hours := parseDurationPart(matches[4], time.Hour)
+	minutes := parseDurationPart(matches[5], time.Second*60)
+	seconds := parseDurationPart(matches[6], time.Second)
+	return time.Duration(years + months + days + hours + minutes + seconds), true
+}
+
 // Parse expiration time
 func parseExpiry(str string) *int64 {
 	// Allow empty string for "never"
-	if str == "" {
+	if str == "" || str == "never" {
 		never := int64(-1)
 		return &never
 	}
 
 	// Try to parse as ISO8601 duration
-	matches := durationRegex.FindStringSubmatch(str)
-	if matches != nil {
-		years := parseDurationPart(matches[1], time.Hour*24*365)
-		months := parseDurationPart(matches[2], time.Hour*24*30)
-		days := parseDurationPart(matches[3], time.Hour*24)
-		hours := parseDurationPart(matches[4], time.Hour)
-		minutes := parseDurationPart(matches[5], time.Second*60)
-		seconds := parseDurationPart(matches[6], time.Second)
-		d := time.Duration(years + months + days + hours + minutes + seconds)
+	if d, ok := parseISODuration(str); ok {
 		if d == 0 {
 			return nil
 		}
@@ -66,6 +83,288 @@ func parseExpiry(str string) *int64 {
 	return &expiry
 }
 
+// dateTimeLocalLayout is the value of datetime-local inputs, browsers add seconds if they aren't zero
+const dateTimeLocalLayout = "2006-01-02T15:04"
+
+// formExpiry returns the expiry of a stream form as accepted by parseExpiry. expire_mode picks
+// the relative auth_expire, the absolute auth_expire_at datetime-local value or never. The time is
+// in the zone of tz_offset, the getTimezoneOffset() of the browser, or in server time without it
+func formExpiry(r *http.Request) string {
+	switch r.PostFormValue("expire_mode") {
+	case "never":
+		return "never"
+	case "absolute":
+		value := r.PostFormValue("auth_expire_at")
+		if value == "" {
+			return ""
+		}
+		zone := time.Local
+		if offset, err := strconv.Atoi(r.PostFormValue("tz_offset")); err == nil {
+			zone = time.FixedZone("", -offset*60)
+		}
+		t, err := time.ParseInLocation(dateTimeLocalLayout, value, zone)
+		if err != nil {
+			t, err = time.ParseInLocation(dateTimeLocalLayout+":05", value, zone)
+		}
+		if err != nil {
+			// Rejected by parseExpiry with the value in the error
+			return value
+		}
+		return t.Format(time.RFC3339)
+	default:
+		return r.PostFormValue("auth_expire")
+	}
+}
+
+// Format expiration time for display
+func formatExpiry(expiry int64) string {
+	if expiry == -1 {
+		return "never"
+	}
+	return time.Unix(expiry, 0).Format(time.RFC3339)
+}
+
+// StreamInput holds the user supplied fields of a new stream
+type StreamInput struct {
+	Application string   `json:"application"`
+	Name        string   `json:"name"`
+	AuthKey     string   `json:"auth_key"`
+	AuthKeys    []string `json:"auth_keys"`
+	// PlayKey is checked instead of the publish keys on play, empty to use them
+	PlayKey    string   `json:"play_key"`
+	AuthExpire string   `json:"auth_expire"`
+	Notes      string   `json:"notes"`
+	AllowedIPs []string `json:"allowed_ips"`
+	DeniedIPs  []string `json:"denied_ips"`
+	// MaxPublishers limits concurrent publishers per name, 0 for the default of 1
+	MaxPublishers int32 `json:"max_publishers"`
+	// MaxBitrateKbps is the expected maximum bitrate for monitoring, 0 for unlimited
+	MaxBitrateKbps int32 `json:"max_bitrate_kbps"`
+	// MaxSession caps the length of a publishing session as Go duration like "2h", empty for no cap
+	MaxSession        string   `json:"max_session"`
+	BlockAfterSession bool     `json:"block_after_session"`
+	Tags              []string `json:"tags"`
+	// ActiveFrom and ActiveUntil bound when publishing is allowed as RFC3339 times, empty for no bound
+	ActiveFrom  string `json:"active_from"`
+	ActiveUntil string `json:"active_until"`
+	// Owner is the user managing the stream, only admins may choose it
+	Owner string `json:"owner"`
+	// AllowedCountries are the country codes publishing is restricted to, empty for any
+	AllowedCountries []string `json:"allowed_countries"`
+	// generatedKey tells the AuthKey is random, so it skips the key policy
+	generatedKey bool
+}
+
+// splitList splits a comma or whitespace separated form value
+func splitList(value string) []string {
+	return strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	})
+}
+
+// splitTags splits a comma separated form value, tags may contain spaces
+func splitTags(value string) []string {
+	var tags []string
+	for _, tag := range strings.Split(value, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// parseWindowBound parses an optional RFC3339 time of the activation window, 0 if empty
+func parseWindowBound(value string) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return 0, err
+	}
+	return t.Unix(), nil
+}
+
+// parseCount parses an optional form number, invalid values return -1 to fail validation
+func parseCount(value string) int32 {
+	if value == "" {
+		return 0
+	}
+	count, err := strconv.ParseInt(value, 10, 32)
+	if err != nil {
+		return -1
+	}
+	return int32(count)
+}
+
+// parseNetworks normalizes a list of addresses and CIDRs
+func parseNetworks(values []string, field string) ([]string, []error) {
+	var networks []string
+	var errs []error
+	for _, value := range values {
+		network, ok := store.ParseNetwork(value)
+		if !ok {
+			errs = append(errs, fmt.Errorf("invalid %s entry: '%v'", field, value))
+			continue
+		}
+		networks = append(networks, network)
+	}
+	return networks, errs
+}
+
+// parseCountries checks ISO 3166-1 alpha-2 country codes and returns them in upper case
+func parseCountries(values []string, field string) ([]string, []error) {
+	var countries []string
+	var errs []error
+	for _, value := range values {
+		country := strings.ToUpper(value)
+		if len(country) != 2 || strings.Trim(country, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") != "" {
+			errs = append(errs, fmt.Errorf("invalid %s entry: '%v', use two letter codes like DE", field, value))
+			continue
+		}
+		if !slices.Contains(countries, country) {
+			countries = append(countries, country)
+		}
+	}
+	return countries, errs
+}
+
+// validateStream checks the input and returns the stream to add
+func validateStream(input StreamInput, config ServerConfig) (*storage.Stream, []error) {
+	var errs []error
+
+	// Fall back to the application default if no expiry was given
+	expireValue := input.AuthExpire
+	defaultExpiry, hasDefault := config.DefaultExpiry[input.Application]
+	useDefault := expireValue == "" && hasDefault
+	if useDefault {
+		expireValue = defaultExpiry
+	}
+	expiry := parseExpiry(expireValue)
+	if expiry == nil {
+		if useDefault {
+			errs = append(errs, fmt.Errorf("invalid default auth expiry for application %v: '%v'", input.Application, expireValue))
+		} else {
+			errs = append(errs, fmt.Errorf("invalid auth expiry: '%v'", expireValue))
+		}
+	}
+
+	// The rtmp server would never ask for an application outside the configured ones
+	if len(config.Applications) > 0 && !slices.Contains(config.Applications, input.Application) {
+		errs = append(errs, fmt.Errorf("unknown application: '%v'", input.Application))
+	} else if err := validateName("application", input.Application, applicationPattern(config)); err != nil {
+		errs = append(errs, err)
+	}
+
+	if len(input.Name) == 0 {
+		errs = append(errs, fmt.Errorf("stream name must be set"))
+	} else if !store.ValidPattern(input.Name) {
+		errs = append(errs, fmt.Errorf("invalid stream name pattern: '%v'", input.Name))
+	} else if err := validateStreamName(input.Name, namePattern(config)); err != nil {
+		errs = append(errs, err)
+	}
+
+	if input.MaxPublishers < 0 {
+		errs = append(errs, fmt.Errorf("max publishers must be a positive number"))
+	}
+	if input.MaxBitrateKbps < 0 {
+		errs = append(errs, fmt.Errorf("max bitrate must be a positive number"))
+	}
+
+	var tags []string
+	for _, tag := range input.Tags {
+		if tag = strings.TrimSpace(tag); tag != "" && !slices.Contains(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+
+	activeFrom, err := parseWindowBound(input.ActiveFrom)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("invalid active from: '%v'", input.ActiveFrom))
+	}
+	activeUntil, err := parseWindowBound(input.ActiveUntil)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("invalid active until: '%v'", input.ActiveUntil))
+	}
+	if activeFrom != 0 && activeUntil != 0 && activeUntil <= activeFrom {
+		errs = append(errs, fmt.Errorf("active until must be after active from"))
+	}
+
+	var maxSession time.Duration
+	if input.MaxSession != "" {
+		maxSession, err = time.ParseDuration(input.MaxSession)
+		if err != nil || maxSession < time.Second {
+			errs = append(errs, fmt.Errorf("invalid max session: '%v'", input.MaxSession))
+		}
+	}
+
+	for i, key := range append([]string{input.AuthKey}, input.AuthKeys...) {
+		err := validateKey("publish key", key)
+		// Empty keys are generated or keep the current ones
+		if err == nil && key != "" && !(i == 0 && input.generatedKey) {
+			err = checkKeyStrength(config.KeyPolicy, "publish key", key)
+		}
+		if err == nil && key != "" {
+			err = checkKeyPrefix(config, input.Application, "publish key", key)
+		}
+		if err != nil {
+			errs = append(errs, err)
+			break
+		}
+	}
+	if err := validateKey("play key", input.PlayKey); err != nil {
+		errs = append(errs, err)
+	} else if input.PlayKey != "" && (input.PlayKey == input.AuthKey || slices.Contains(input.AuthKeys, input.PlayKey)) {
+		// Viewers would be able to publish
+		errs = append(errs, fmt.Errorf("play key must differ from the publish keys"))
+	} else if input.PlayKey != "" {
+		if err := checkKeyStrength(config.KeyPolicy, "play key", input.PlayKey); err != nil {
+			errs = append(errs, err)
+		} else if err := checkKeyPrefix(config, input.Application, "play key", input.PlayKey); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	allowed, allowedErrs := parseNetworks(input.AllowedIPs, "allowed ips")
+	errs = append(errs, allowedErrs...)
+	denied, deniedErrs := parseNetworks(input.DeniedIPs, "denied ips")
+	errs = append(errs, deniedErrs...)
+	countries, countryErrs := parseCountries(input.AllowedCountries, "allowed countries")
+	errs = append(errs, countryErrs...)
+
+	// TODO: more validation
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	return &storage.Stream{
+		Name:          input.Name,
+		Application:   input.Application,
+		AuthKey:       input.AuthKey,
+		AuthKeys:      append([]string(nil), input.AuthKeys...),
+		PlayKey:       input.PlayKey,
+		AuthExpire:    *expiry,
+		Notes:         input.Notes,
+		AllowedIps:    allowed,
+		DeniedIps:     denied,
+		MaxPublishers: input.MaxPublishers,
+
+		MaxBitrateKbps:     input.MaxBitrateKbps,
+		MaxSessionDuration: int64(maxSession / time.Second),
+		BlockAfterSession:  input.BlockAfterSession,
+		Tags:               tags,
+		ActiveFrom:         activeFrom,
+		ActiveUntil:        activeUntil,
+		AllowedCountries:   countries,
+	}, nil
+}
+
+// SRSPublish is the body of an SRS http hook, e.g. from SRS 5.0
+// {"server_id":"vid-0xk989d","service_id":"r8h3r3b9","action":"on_publish","client_id":"0p5kn1j8",
+// "ip":"172.17.0.1","vhost":"__defaultVhost__","app":"live","tcUrl":"rtmp://127.0.0.1/live",
+// "stream":"livestream","param":"?secret=s3cr3t&expire=1715000000","stream_url":"/live/livestream",
+// "stream_id":"vid-124q9y3"}
+// on_dvr and on_hls additionally carry the written file
 type SRSPublish struct {
 	Action string `json:"action"`
 	IP     string `json:"ip"`
@@ -76,122 +375,929 @@ type SRSPublish struct {
 	Param  string `json:"param"`
 }
 
-func handleSRSRequest(r *http.Request) (app string, name string, auth string, action string, err error) {
+func handleSRSRequest(r *http.Request, proxies trustedProxies, params []string) (app string, name string, auth string, action string, ip string, err error) {
 	var publish SRSPublish
 
-	if r.ContentLength == 0 {
+	// The body may arrive chunked or across several reads
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("Failed to read body: %s", err)
+		return
+	}
+	if len(body) == 0 {
 		err = errors.New("empty body")
 		return
 	}
 
-	body := make([]byte, r.ContentLength)
-	_, err = r.Body.Read(body)
+	err = json.Unmarshal(body, &publish)
 	if err != nil {
-		log.Printf("Failed to read body: %s", err)
 		return
 	}
 
-	log.Printf("SRS request: %s\n", body)
+	// skip question mark
+	if len(publish.Param) > 0 {
+		publish.Param = publish.Param[1:]
+	}
 
-	err = json.Unmarshal(body, &publish)
+	val, err := url.ParseQuery(publish.Param)
+	if err != nil {
+		return
+	}
+	app = publish.App
+	name = publish.Stream
+	auth = authParam(val, params)
+	// An expire parameter can only shorten the validity of the key
+	if expire := val.Get("expire"); expire != "" {
+		expiry, parseErr := strconv.ParseInt(expire, 10, 64)
+		if parseErr != nil {
+			err = fmt.Errorf("invalid expire parameter '%s'", expire)
+			return
+		}
+		if expiry < time.Now().Unix() {
+			err = fmt.Errorf("url of %s/%s expired", app, name)
+			return
+		}
+	}
+	action = publish.Action
+	// SRS passes the publisher address, the connection is only the client if it's missing
+	ip = normalizeIP(publish.IP)
+	if ip == "" {
+		ip = clientIP(r, proxies)
+	}
+	return
+}
+
+func handleNginxRequest(r *http.Request, proxies trustedProxies, params []string) (app string, name string, auth string, action string, ip string, err error) {
+	// ParseForm ignores bodies of other content types, servers labeling their form data differently are parsed anyway
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/x-www-form-urlencoded" {
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	err = r.ParseForm()
+	if err != nil {
+		return
+	}
+
+	app = r.PostForm.Get("app")
+	name = r.PostForm.Get("name")
+	auth = authParam(r.PostForm, params)
+	action = r.PostForm.Get("call")
+	// nginx-rtmp passes the publisher address, others are identified by the connection
+	ip = normalizeIP(r.PostForm.Get("addr"))
+	if ip == "" {
+		ip = clientIP(r, proxies)
+	}
+	return
+}
+
+// MediaMTXAuth is the body of a MediaMTX external authentication request, e.g.
+// {"user":"","password":"","token":"","ip":"127.0.0.1","action":"publish",
+// "path":"stream/foo","protocol":"rtmp","id":null,"query":"auth=secret"}
+type MediaMTXAuth struct {
+	User     string `json:"user"`
+	Password string `json:"password"`
+	IP       string `json:"ip"`
+	Action   string `json:"action"`
+	Path     string `json:"path"`
+	Protocol string `json:"protocol"`
+	Query    string `json:"query"`
+}
+
+// handleMediaMTXRequest parses a MediaMTX authHTTPAddress request.
+// The last path element is the stream name, everything before it the application.
+// The key is taken from the auth query parameters or the password
+func handleMediaMTXRequest(r *http.Request, params []string, actions map[string]string) (app string, name string, auth string, action string, ip string, err error) {
+	var req MediaMTXAuth
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return
+	}
+
+	if err = json.Unmarshal(body, &req); err != nil {
+		return
+	}
+
+	if mapped, ok := actions[req.Action]; ok {
+		action = mapped
+	} else {
+		switch req.Action {
+		case "publish":
+			action = "publish"
+		case "read", "playback":
+			action = "play"
+		default:
+			err = fmt.Errorf("unsupported action %s", req.Action)
+			return
+		}
+	}
+
+	val, err := url.ParseQuery(req.Query)
 	if err != nil {
 		return
 	}
+	if i := strings.LastIndex(req.Path, "/"); i >= 0 {
+		app = req.Path[:i]
+		name = req.Path[i+1:]
+	} else {
+		name = req.Path
+	}
+	auth = authParam(val, params)
+	if auth == "" {
+		auth = req.Password
+	}
+	ip = normalizeIP(req.IP)
+	return
+}
+
+// nmsActions maps Node-Media-Server session events to the internal actions.
+// Only one of the pre and post events should be forwarded, each publish counts as a publisher
+var nmsActions = map[string]string{
+	"prePublish":  "publish",
+	"postPublish": "publish",
+	"donePublish": "publish_done",
+	"prePlay":     "play",
+	"postPlay":    "play",
+	"donePlay":    "play_done",
+}
+
+// handleNMSRequest parses a Node-Media-Server event forwarded as form data, e.g.
+// action=prePublish&StreamPath=%2Flive%2Ffoo&args=%7B%22auth%22%3A%22secret%22%7D&ip=10.0.0.2
+// StreamPath is split into application and stream name at the last slash, args are the url
+// parameters of the session as JSON object or query string
+func handleNMSRequest(r *http.Request, proxies trustedProxies, params []string, actions map[string]string) (app string, name string, auth string, action string, ip string, err error) {
+	if err = r.ParseForm(); err != nil {
+		return
+	}
+
+	event := r.PostForm.Get("action")
+	action, ok := actions[event]
+	if !ok {
+		action, ok = nmsActions[event]
+	}
+	if !ok {
+		err = fmt.Errorf("unsupported action %s", event)
+		return
+	}
+
+	path := strings.TrimPrefix(r.PostForm.Get("StreamPath"), "/")
+	if i := strings.LastIndex(path, "/"); i >= 0 {
+		app = path[:i]
+		name = path[i+1:]
+	} else {
+		name = path
+	}
+
+	args := r.PostForm.Get("args")
+	if strings.HasPrefix(args, "{") {
+		var values map[string]interface{}
+		if err = json.Unmarshal([]byte(args), &values); err != nil {
+			return
+		}
+		for _, param := range params {
+			if value, ok := values[param].(string); ok && value != "" {
+				auth = value
+				break
+			}
+		}
+	} else {
+		var values url.Values
+		if values, err = url.ParseQuery(strings.TrimPrefix(args, "?")); err != nil {
+			return
+		}
+		auth = authParam(values, params)
+	}
+
+	ip = normalizeIP(r.PostForm.Get("ip"))
+	if ip == "" {
+		ip = clientIP(r, proxies)
+	}
+	return
+}
+
+// authBackend is the rtmp server an auth request was sent by
+type authBackend string
+
+const (
+	backendNginx    authBackend = "nginx"
+	backendSRS      authBackend = "srs"
+	backendMediaMTX authBackend = "mediamtx"
+	backendNMS      authBackend = "nms"
+)
+
+// authBackends are the parsers ServerConfig.AuthBackend can select
+var authBackends = map[string]authBackend{
+	"nginx":    backendNginx,
+	"srs":      backendSRS,
+	"mediamtx": backendMediaMTX,
+	"nms":      backendNMS,
+}
+
+// parseAuthBackend parses ServerConfig.AuthBackend, empty or auto detects the backend per request
+func parseAuthBackend(config ServerConfig) (authBackend, error) {
+	switch config.AuthBackend {
+	case "", "auto":
+		return "", nil
+	}
+	if backend, ok := authBackends[config.AuthBackend]; ok {
+		return backend, nil
+	}
+	return "", fmt.Errorf("auth-backend: unknown backend %q, use auto, nginx, srs, mediamtx or nms", config.AuthBackend)
+}
+
+// detectBackend tells SRS, which posts JSON, from the form posts of nginx-rtmp and srtrelay.
+// Parameters like a charset don't matter, anything but JSON is parsed as form
+func detectBackend(r *http.Request) authBackend {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err == nil && mediaType == "application/json" {
+		return backendSRS
+	}
+	return backendNginx
+}
+
+// backendAuthParams are the parameters the key is read from if auth-params isn't set.
+// SRS deployments commonly sign urls with secret or token instead of auth
+var backendAuthParams = map[authBackend][]string{
+	backendSRS: {"auth", "secret", "token"},
+}
+
+// authParams returns the parameter names the key of backend is read from, in order of precedence
+func authParams(config ServerConfig, backend authBackend) []string {
+	if len(config.AuthParams) > 0 {
+		return config.AuthParams
+	}
+	if params, ok := backendAuthParams[backend]; ok {
+		return params
+	}
+	return []string{"auth"}
+}
+
+// authParam returns the first non-empty value of params, so the order of params is the precedence
+func authParam(values url.Values, params []string) string {
+	for _, param := range params {
+		if value := values.Get(param); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// canonicalActions are the verbs ServerConfig.Actions may map to
+var canonicalActions = []string{"publish", "unpublish", "play"}
+
+// checkActions verifies the action mapping only maps to canonical verbs
+func checkActions(config ServerConfig) error {
+	for from, to := range config.Actions {
+		if from == "" {
+			return errors.New("actions: action names must not be empty")
+		}
+		if !slices.Contains(canonicalActions, to) {
+			return fmt.Errorf("actions: %s maps to unknown action %q, use publish, unpublish or play", from, to)
+		}
+	}
+	return nil
+}
+
+// checkAuthParams rejects empty parameter names in ServerConfig.AuthParams
+func checkAuthParams(config ServerConfig) error {
+	for _, param := range config.AuthParams {
+		if strings.TrimSpace(param) == "" {
+			return errors.New("auth-params: parameter names must not be empty")
+		}
+	}
+	return nil
+}
+
+// parseAuthRequest extracts app, name, auth, action and publisher ip with the parser of the backend.
+// Actions found in the actions mapping are replaced by the verb they map to
+func parseAuthRequest(r *http.Request, backend authBackend, proxies trustedProxies, params []string, actions map[string]string) (app string, name string, auth string, action string, ip string, err error) {
+	switch backend {
+	case backendSRS:
+		app, name, auth, action, ip, err = handleSRSRequest(r, proxies, params)
+	case backendMediaMTX:
+		// MediaMTX and NMS reject unknown actions, so they consult the mapping themselves
+		return handleMediaMTXRequest(r, params, actions)
+	case backendNMS:
+		return handleNMSRequest(r, proxies, params, actions)
+	default:
+		// Form DATA from nginx-rtmp/srtrelay
+		app, name, auth, action, ip, err = handleNginxRequest(r, proxies, params)
+	}
+	if mapped, ok := actions[action]; ok {
+		action = mapped
+	}
+	return
+}
+
+// defaultMaxBodySize is the default limit of auth request bodies, callbacks are far smaller
+const defaultMaxBodySize = 64 << 10
+
+func maxBodySize(config ServerConfig) int64 {
+	if config.MaxBodySize > 0 {
+		return config.MaxBodySize
+	}
+	return defaultMaxBodySize
+}
+
+// publishPolicy parses ServerConfig.DuplicatePublish, deny is the default
+func publishPolicy(config ServerConfig) (store.PublishPolicy, error) {
+	switch config.DuplicatePublish {
+	case "", "deny":
+		return store.PolicyDeny, nil
+	case "takeover":
+		return store.PolicyTakeover, nil
+	}
+	return store.PolicyDeny, fmt.Errorf("duplicate-publish: unknown policy %q, use deny or takeover", config.DuplicatePublish)
+}
+
+// writeAuthResponse answers a failed auth request with the status, see writeSuccess for authorized ones.
+// Every backend treats an error status as a denial
+func writeAuthResponse(w http.ResponseWriter, backend authBackend, status int) {
+	http.Error(w, fmt.Sprintf("%d %s", status, http.StatusText(status)), status)
+}
+
+// AuthHandler checks requests for authentication with the parser selected by ServerConfig.AuthBackend,
+// detecting the rtmp server by content type by default
+func AuthHandler(store *store.Store, config ServerConfig) handleFunc {
+	// Validated in NewAPI
+	backend, _ := parseAuthBackend(config)
+	return authHandler(store, config, backend)
+}
+
+// NginxAuthHandler checks nginx-rtmp and srtrelay requests for authentication
+func NginxAuthHandler(store *store.Store, config ServerConfig) handleFunc {
+	return authHandler(store, config, backendNginx)
+}
+
+// SRSAuthHandler checks SRS requests for authentication
+func SRSAuthHandler(store *store.Store, config ServerConfig) handleFunc {
+	return authHandler(store, config, backendSRS)
+}
+
+// MediaMTXAuthHandler checks MediaMTX requests for authentication
+func MediaMTXAuthHandler(store *store.Store, config ServerConfig) handleFunc {
+	return authHandler(store, config, backendMediaMTX)
+}
+
+// NMSAuthHandler checks Node-Media-Server events for authentication
+func NMSAuthHandler(store *store.Store, config ServerConfig) handleFunc {
+	return authHandler(store, config, backendNMS)
+}
+
+// checkAuth runs the play or publish auth
+func checkAuth(ctx context.Context, store *store.Store, play bool, app string, name string, auth string, ip string) store.AuthResult {
+	if play {
+		return store.CheckPlayAuth(ctx, app, name, auth, ip)
+	}
+	return store.CheckAuth(ctx, app, name, auth, ip)
+}
+
+// isUnavailable reports whether an auth check failed because the store couldn't be read in time
+func isUnavailable(result store.AuthResult) bool {
+	return result.Reason == store.ReasonUnavailable
+}
+
+// isCancelled reports whether err is caused by a cancelled or timed out request context
+func isCancelled(err error) bool {
+	return errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled)
+}
+
+func authHandler(store *store.Store, config ServerConfig, fixed authBackend) handleFunc {
+	limiter := newFailureLimiter(config.AuthFailureLimit, config.AuthFailureWindow)
+	proxies := parseTrustedProxies(config.TrustedProxies)
+	// Validated in NewAPI
+	policy, _ := publishPolicy(config)
+	timeout := authTimeout(config)
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+		// Answer quickly if the store is slow instead of stalling the rtmp server's callback
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+
+		backend := fixed
+		if backend == "" {
+			backend = detectBackend(r)
+		}
+
+		// Parsers read the body to its end, so chunked requests without a ContentLength work as well.
+		// A declared length beyond the limit is rejected without reading
+		limit := maxBodySize(config)
+		if r.ContentLength > limit {
+			log.Printf("Rejected auth request body larger than %d bytes\n", limit)
+			writeAuthResponse(w, backend, http.StatusRequestEntityTooLarge)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+		app, name, auth, action, ip, err := parseAuthRequest(r, backend, proxies, authParams(config, backend), config.Actions)
+		if tooLarge := (*http.MaxBytesError)(nil); errors.As(err, &tooLarge) {
+			log.Printf("Rejected auth request body larger than %d bytes\n", tooLarge.Limit)
+			writeAuthResponse(w, backend, http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err != nil {
+			log.Println("Failed to parse play data:", err)
+			writeDenial(w, config, backend, "invalid_request", http.StatusUnauthorized)
+			return
+		}
+		// From here on name is the real stream name, so the key isn't tracked or logged
+		if config.StreamKeyDelimiter != "" && auth == "" {
+			if stripped, key, ok := store.SplitNameKey(app, name, config.StreamKeyDelimiter); ok {
+				name, auth = stripped, key
+			}
+		}
+		logAuthRequest(config, backend, app, name, auth, action, ip)
+
+		appLabel := metricLabel(app, config.Applications)
+		actionLabel := metricLabel(action, knownActions)
+
+		if limiter.Limited(ip) {
+			authFailure.WithLabelValues(appLabel, actionLabel, "rate_limited").Inc()
+			slog.Warn("auth", "action", action, "app", app, "name", name, "ip", ip, "result", "rate_limited")
+			writeDenial(w, config, backend, "rate_limited", http.StatusTooManyRequests)
+			return
+		}
+
+		// Unknown actions are checked like a publish, which hides misconfigured callbacks unless they are reported
+		if !slices.Contains(knownActions, action) {
+			unknownActions.WithLabelValues(appLabel).Inc()
+			slog.Warn("auth", "action", action, "app", app, "name", name, "ip", ip, "result", "unknown_action")
+			if config.StrictActions {
+				authFailure.WithLabelValues(appLabel, actionLabel, "unknown_action").Inc()
+				writeDenial(w, config, backend, "unknown_action", http.StatusBadRequest)
+				return
+			}
+		}
+
+		// SRS reports recordings and segments of streams it already authorized, they need no check
+		if action == "on_dvr" || action == "on_hls" {
+			store.Touch(app, name)
+			slog.Info("auth", "action", action, "app", app, "name", name, "ip", ip, "result", "ignored")
+			writeSuccess(w, config, backend)
+			return
+		}
+
+		// Viewers leaving and nginx's on_done only report the end of a session. They are acknowledged
+		// without a check, so a viewer's play key isn't counted as a wrong publish key
+		if action == "play_done" || action == "done" {
+			slog.Info("auth", "action", action, "app", app, "name", name, "ip", ip, "result", "ignored")
+			writeSuccess(w, config, backend)
+			return
+		}
+
+		result := checkAuth(ctx, store, action == "on_play" || action == "play", app, name, auth, ip)
+		id := result.Id
+		unpublish := action == "on_unpublish" || action == "unpublish" || action == "publish_done"
+		if isUnavailable(result) {
+			writeUnavailable(w, config, backend, appLabel, actionLabel, action, app, name, ip)
+			return
+		}
+		if !result.Authorized {
+			// The publisher is gone either way, a stream blocked or expired while live must not keep its slot
+			if unpublish && id != "" {
+				store.SetInactive(ctx, app, name, ip)
+			}
+			authFailure.WithLabelValues(appLabel, actionLabel, result.Reason.String()).Inc()
+			limiter.Fail(ip)
+			slog.Warn("auth", "action", action, "id", id, "app", app, "name", name, "ip", ip,
+				"result", "unauthorized", "reason", result.Reason.String())
+			writeDenial(w, config, backend, result.Reason.String(), http.StatusUnauthorized)
+			return
+		}
+		limiter.Reset(ip)
+
+		if action == "on_publish" || action == "publish" {
+			// A one-time token authorizes repeated auth of its session, but no further publish.
+			// Replays are rejected before they can take over the stream
+			if used, err := store.TokenUsed(ctx, auth); used || isCancelled(err) {
+				if used {
+					limiter.Fail(ip)
+					writeReplayed(w, config, backend, appLabel, actionLabel, action, id, app, name, ip)
+				} else {
+					writeUnavailable(w, config, backend, appLabel, actionLabel, action, app, name, ip)
+				}
+				return
+			}
+			// Publishes to open applications are tracked under a stream added on the fly
+			if id == "" && store.IsOpen(app) {
+				if id, err = store.OpenStream(app, name); err != nil {
+					log.Println("open stream:", err)
+				}
+			}
+			// Streamless tokens aren't tracked
+			if id != "" {
+				tookOver, err := store.SetActive(ctx, id, name, ip, policy)
+				if isCancelled(err) {
+					writeUnavailable(w, config, backend, appLabel, actionLabel, action, app, name, ip)
+					return
+				} else if isPublisherLimit(err) {
+					authFailure.WithLabelValues(appLabel, actionLabel, "publisher_limit").Inc()
+					slog.Warn("auth", "action", action, "id", id, "app", app, "name", name, "ip", ip, "result", "publisher_limit")
+					writeDenial(w, config, backend, "publisher_limit", http.StatusConflict)
+					return
+				} else if err != nil {
+					log.Println("set active:", err)
+				} else if tookOver {
+					slog.Warn("auth", "action", action, "id", id, "app", app, "name", name, "ip", ip, "result", "takeover")
+				}
+			}
+			// The nonce is only used up by an accepted publish, a concurrent publish with the same token
+			// may have used it since the check and is undone
+			if err := store.UseToken(ctx, auth); err != nil {
+				if id != "" {
+					store.SetInactive(ctx, app, name, ip)
+				}
+				if isTokenReplayed(err) {
+					limiter.Fail(ip)
+					writeReplayed(w, config, backend, appLabel, actionLabel, action, id, app, name, ip)
+				} else {
+					log.Println("use token:", err)
+					writeUnavailable(w, config, backend, appLabel, actionLabel, action, app, name, ip)
+				}
+				return
+			}
+		} else if unpublish {
+			if !store.SetInactive(ctx, app, name, ip) && ctx.Err() != nil {
+				writeUnavailable(w, config, backend, appLabel, actionLabel, action, app, name, ip)
+				return
+			}
+		} else if action == "update_publish" {
+			// nginx repeats the auth during the session with on_update, which keeps stale reaping off
+			store.Touch(app, name)
+		}
+
+		authSuccess.WithLabelValues(appLabel, actionLabel).Inc()
+		slog.Info("auth", "action", action, "id", id, "app", app, "name", name, "ip", ip, "result", "ok")
+		writeSuccess(w, config, backend)
+	}
+}
+
+// writeUnavailable answers an auth request which ran into the auth timeout with 503,
+// so the rtmp server's callback doesn't hang on a slow store
+func writeUnavailable(w http.ResponseWriter, config ServerConfig, backend authBackend, appLabel string, actionLabel string,
+	action string, app string, name string, ip string) {
+	reason := store.ReasonUnavailable.String()
+	authFailure.WithLabelValues(appLabel, actionLabel, reason).Inc()
+	slog.Warn("auth", "action", action, "app", app, "name", name, "ip", ip, "result", reason)
+	writeDenial(w, config, backend, reason, http.StatusServiceUnavailable)
+}
+
+// writeReplayed denies a publish with a one-time token which was used for a publish before
+func writeReplayed(w http.ResponseWriter, config ServerConfig, backend authBackend, appLabel string, actionLabel string,
+	action string, id string, app string, name string, ip string) {
+	reason := store.ReasonTokenReplayed.String()
+	authFailure.WithLabelValues(appLabel, actionLabel, reason).Inc()
+	slog.Warn("auth", "action", action, "id", id, "app", app, "name", name, "ip", ip,
+		"result", "unauthorized", "reason", reason)
+	writeDenial(w, config, backend, reason, http.StatusUnauthorized)
+}
+
+func isPublisherLimit(err error) bool {
+	return errors.Is(err, store.ErrPublisherLimit)
+}
+
+func isTokenReplayed(err error) bool {
+	return errors.Is(err, store.ErrTokenReplayed)
+}
+
+func isQuotaReached(err error) bool {
+	return errors.Is(err, store.ErrQuotaReached)
+}
+
+// lookupStream returns application and name of the stream with id, including removed ones
+func lookupStream(store *store.Store, id string) (app string, name string) {
+	state, err := store.Get()
+	if err != nil {
+		return
+	}
+	removed, err := store.Removed()
+	if err != nil {
+		return
+	}
+	for _, stream := range append(state.Streams, removed...) {
+		if stream.Id == id {
+			return stream.Application, stream.Name
+		}
+	}
+	return
+}
+
+func FormHandler(store *store.Store, config ServerConfig, hooks *webhook.Notifier) handleFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		config := requestConfig(r, config)
+		var errs []error
+		state, err := requestState(r, store, config)
+		if err != nil {
+			errs = append(errs, err)
+		}
+
+		streamSort := parseSort(r.URL.Query())
+		sortStreams(state.Streams, streamSort)
+
+		var messages []string
+		if added := r.URL.Query().Get("added"); added != "" {
+			for _, stream := range state.Streams {
+				if stream.Id == added {
+					messages = append(messages, fmt.Sprintf("added stream %v/%v, expires %v",
+						stream.Application, stream.Name, formatExpiry(stream.AuthExpire)))
+				}
+			}
+		}
+
+		// Offer to undo a removal right after it, or list all removed streams
+		var undo *storage.Stream
+		var removed []*storage.Stream
+		showRemoved, _ := strconv.ParseBool(r.URL.Query().Get("show_removed"))
+		if undoID := r.URL.Query().Get("undo"); undoID != "" || showRemoved {
+			streams, err := requestRemoved(r, store, config)
+			if err != nil {
+				errs = append(errs, err)
+			}
+			for _, stream := range streams {
+				if stream.Id == undoID {
+					undo = stream
+				}
+			}
+			if showRemoved {
+				sortStreams(streams, streamSort)
+				removed = streams
+			}
+		}
+
+		// The delivery log may show urls of all webhooks, so it's left to admins
+		showWebhooks, _ := strconv.ParseBool(r.URL.Query().Get("show_webhooks"))
+		var deliveries []webhook.Delivery
+		if showWebhooks && hooks != nil && isAdmin(config, r) {
+			deliveries = hooks.Deliveries()
+		} else {
+			showWebhooks = false
+		}
 
-	// skip question mark
-	if len(publish.Param) > 0 {
-		publish.Param = publish.Param[1:]
-	}
+		filter := StreamFilter{
+			Query:       strings.TrimSpace(r.URL.Query().Get("q")),
+			Application: r.URL.Query().Get("app"),
+			Tags:        parseTagFilter(r.URL.Query()),
+		}
+		// The summary covers all streams, not just the filtered ones
+		summary := summarize(state.Streams, time.Now())
+		state.Streams = filterStreams(state.Streams, filter)
 
-	val, err := url.ParseQuery(publish.Param)
-	if err != nil {
-		return
+		size := config.PageSize
+		if size <= 0 {
+			size = defaultPageSize
+		}
+		if value, err := strconv.Atoi(r.URL.Query().Get("size")); err == nil && value > 0 {
+			size = value
+		}
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		query := r.URL.Query()
+		query.Del("page")
+		query.Del("size")
+		query.Del("added")
+		query.Del("undo")
+		var pagination Pagination
+		state.Streams, pagination = paginate(state.Streams, page, size, query)
+
+		data := TemplateData{
+			State:        state,
+			Config:       config,
+			CsrfTemplate: csrf.TemplateField(r),
+			Admin:        isAdmin(config, r),
+			Text:         requestText(config, r),
+			Errors:       errs,
+			Messages:     messages,
+			Filter:       filter,
+			Pagination:   &pagination,
+			Sort:         &streamSort,
+			PublishURLs:  publishURLs(config, state.Streams),
+			Summary:      &summary,
+			Undo:         undo,
+			ShowRemoved:  showRemoved,
+			Removed:      removed,
+			ShowWebhooks: showWebhooks,
+			Deliveries:   deliveries,
+		}
+		err = templates.ExecuteTemplate(w, "form.html", data)
+		if err != nil {
+			log.Println("Template failed", err)
+		}
 	}
-	app = publish.App
-	name = publish.Stream
-	auth = val.Get("auth")
-	action = publish.Action
-	return
 }
 
-func handleNginxRequest(r *http.Request) (app string, name string, auth string, action string, err error) {
-	err = r.ParseForm()
-	if err != nil {
-		return
-	}
+func AddHandler(store *store.Store, config ServerConfig, auditLog *audit.Log) handleFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		config := requestConfig(r, config)
+		input := StreamInput{
+			Application:    r.PostFormValue("application"),
+			Name:           r.PostFormValue("name"),
+			AuthKey:        r.PostFormValue("auth_key"),
+			PlayKey:        r.PostFormValue("play_key"),
+			AuthExpire:     formExpiry(r),
+			Notes:          r.PostFormValue("notes"),
+			AllowedIPs:     splitList(r.PostFormValue("allowed_ips")),
+			DeniedIPs:      splitList(r.PostFormValue("denied_ips")),
+			MaxPublishers:  parseCount(r.PostFormValue("max_publishers")),
+			MaxBitrateKbps: parseCount(r.PostFormValue("max_bitrate_kbps")),
 
-	app = r.PostForm.Get("app")
-	name = r.PostForm.Get("name")
-	auth = r.PostForm.Get("auth")
-	action = r.PostForm.Get("call")
-	log.Printf("Nginx request: %s %s %s %s", app, name, auth, action)
+			MaxSession:        r.PostFormValue("max_session"),
+			BlockAfterSession: r.PostFormValue("block_after_session") == "true",
+			Tags:              splitTags(r.PostFormValue("tags")),
+			ActiveFrom:        r.PostFormValue("active_from"),
+			ActiveUntil:       r.PostFormValue("active_until"),
+			Owner:             r.PostFormValue("owner"),
+			AllowedCountries:  splitList(r.PostFormValue("allowed_countries")),
+		}
+		input, presetErr := resolvePreset(config, r.PostFormValue("preset"), input)
+		// Operators tend to pick weak keys, so a blank key gets a random one
+		var generated string
+		if input.AuthKey == "" {
+			var err error
+			if generated, err = generateKey(); err != nil {
+				log.Println("generate key", err)
+				http.Error(w, "500 Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			generated = config.KeyPrefixes[input.Application] + generated
+			input.AuthKey = generated
+			input.generatedKey = true
+		}
+		stream, errs := validateStream(input, config)
+		if presetErr != nil {
+			errs = []error{presetErr}
+		}
+		if len(errs) == 0 {
+			var err error
+			if stream.Owner, err = streamOwner(config, r, input.Owner); err != nil {
+				errs = append(errs, err)
+			} else if err := checkCollision(store, stream, ""); err != nil {
+				errs = append(errs, err)
+			}
+		}
 
-	var body []byte
-	if r.ContentLength != 0 {
-		body, err = io.ReadAll(r.Body)
-		if err != nil {
-			return
+		if len(errs) == 0 {
+			action, verb := "add", "added"
+			var err error
+			if r.PostFormValue("overwrite") == "true" {
+				var id string
+				// Only own streams can be replaced
+				if err = checkOwnerByName(r, store, config, stream.Application, stream.Name); isNotFound(err) {
+					err = fmt.Errorf("stream %v/%v belongs to another owner", stream.Application, stream.Name)
+				} else if err == nil {
+					if id, err = store.OverwriteStream(stream); id != stream.Id {
+						action, verb = "update", "updated"
+						stream.Id = id
+					}
+				}
+			} else {
+				err = store.AddStream(stream)
+			}
+			if isDuplicate(err) {
+				errs = append(errs, fmt.Errorf("stream %v/%v already exists, check overwrite to replace it",
+					stream.Application, stream.Name))
+			} else if isQuotaReached(err) {
+				errs = append(errs, err)
+			} else if err != nil {
+				errs = append(errs, fmt.Errorf("failed to add stream: %w", err))
+			} else {
+				slog.Info("stream", "action", action, "id", stream.Id, "app", stream.Application, "name", stream.Name)
+				auditLog.Record(audit.Entry{Action: action, Id: stream.Id, Application: stream.Application, Name: stream.Name, User: requestUser(r)})
+				// The key can't be shown after a redirect if the store only keeps its hash
+				if generated != "" {
+					message := fmt.Sprintf("%v stream %v/%v, expires %v",
+						verb, stream.Application, stream.Name, formatExpiry(stream.AuthExpire))
+					renderGeneratedKey(w, r, store, config, message, generated)
+					return
+				}
+				// Let the form confirm the resolved expiry
+				http.Redirect(w, r, config.Prefix+"/?added="+url.QueryEscape(stream.Id), http.StatusSeeOther)
+				return
+			}
 		}
 
-		log.Printf("Nginx request body: %s\n", string(body))
-	} else {
-		log.Println("Nginx request: empty body")
+		state, err := requestState(r, store, config)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		data := TemplateData{
+			State:        state,
+			Config:       config,
+			CsrfTemplate: csrf.TemplateField(r),
+			Admin:        isAdmin(config, r),
+			Text:         requestText(config, r),
+			Errors:       errs,
+		}
+		err = templates.ExecuteTemplate(w, "form.html", data)
+		if err != nil {
+			log.Println("Template failed", err)
+		}
 	}
-
-	return
 }
 
-// AuthHandler checks requests for authentication
-func AuthHandler(store *store.Store) handleFunc {
+func RemoveHandler(store *store.Store, config ServerConfig, auditLog *audit.Log) handleFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		defer r.Body.Close()
+		config := requestConfig(r, config)
+		var errs []error
+		id := r.PostFormValue("id")
+		app, name := lookupStream(store, id)
 
-		var app string
-		var name string
-		var auth string
-		var action string
-		var err error
-		if r.Header.Get("Content-Type") == "application/json" {
-			// SRS handler
-			app, name, auth, action, err = handleSRSRequest(r)
-		} else {
-			// Form DATA from nginx-rtmp/srtrelay
-			app, name, auth, action, err = handleNginxRequest(r)
+		// Streams are only marked removed unless forced, so a misclick can be undone
+		force, _ := strconv.ParseBool(r.PostFormValue("force"))
+		action := "remove"
+		err := checkOwner(r, store, config, id)
+		if err == nil && force {
+			action = "purge"
+			err = store.PurgeStream(id)
+		} else if err == nil {
+			err = store.RemoveStream(id)
 		}
 		if err != nil {
-			log.Println("Failed to parse play data:", err)
-			http.Error(w, "401 Unauthorized", http.StatusUnauthorized)
-			return
+			log.Println(err)
+			errs = append(errs, fmt.Errorf("failed to remove stream: %w", err))
+			state, err := requestState(r, store, config)
+			if err != nil {
+				errs = append(errs, err)
+			}
+			data := TemplateData{
+				State:        state,
+				Config:       config,
+				CsrfTemplate: csrf.TemplateField(r),
+				Admin:        isAdmin(config, r),
+				Text:         requestText(config, r),
+				Errors:       errs,
+			}
+			err = templates.ExecuteTemplate(w, "form.html", data)
+			if err != nil {
+				log.Println("Template failed", err)
+			}
+		} else {
+			slog.Info("stream", "action", action, "id", id, "app", app, "name", name)
+			auditLog.Record(audit.Entry{Action: action, Id: id, Application: app, Name: name, User: requestUser(r)})
+			if force {
+				http.Redirect(w, r, config.Prefix+"/?show_removed=true", http.StatusSeeOther)
+			} else {
+				http.Redirect(w, r, config.Prefix+"/?undo="+url.QueryEscape(id), http.StatusSeeOther)
+			}
 		}
+	}
+}
 
-		success, id := store.Auth(app, name, auth)
-		if !success {
-			log.Printf("%s %s %s/%s unauthorized\n", action, id, app, name)
-			http.Error(w, "401 Unauthorized", http.StatusUnauthorized)
-			return
-		}
+// RestoreHandler undoes the removal of a stream
+func RestoreHandler(store *store.Store, config ServerConfig, auditLog *audit.Log) handleFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		config := requestConfig(r, config)
+		var errs []error
+		id := r.PostFormValue("id")
+		app, name := lookupStream(store, id)
 
-		if action == "on_publish" || action == "publish" {
-			store.SetActive(id)
-		} else if action == "on_unpublish" || action == "unpublish" {
-			store.SetInactive(app, name)
+		err := checkOwner(r, store, config, id)
+		if err == nil {
+			err = store.RestoreStream(id)
+		}
+		if err != nil {
+			log.Println(err)
+			errs = append(errs, fmt.Errorf("failed to restore stream: %w", err))
+			state, err := requestState(r, store, config)
+			if err != nil {
+				errs = append(errs, err)
+			}
+			data := TemplateData{
+				State:        state,
+				Config:       config,
+				CsrfTemplate: csrf.TemplateField(r),
+				Admin:        isAdmin(config, r),
+				Text:         requestText(config, r),
+				Errors:       errs,
+			}
+			err = templates.ExecuteTemplate(w, "form.html", data)
+			if err != nil {
+				log.Println("Template failed", err)
+			}
+		} else {
+			slog.Info("stream", "action", "restore", "id", id, "app", app, "name", name)
+			auditLog.Record(audit.Entry{Action: "restore", Id: id, Application: app, Name: name, User: requestUser(r)})
+			http.Redirect(w, r, config.Prefix+"/", http.StatusSeeOther)
 		}
-
-		log.Printf("%s %s %s/%s ok\n", action, id, app, name)
-
-		// SRS needs zero response
-		w.Write([]byte("0"))
 	}
 }
 
-func FormHandler(store *store.Store, config ServerConfig) handleFunc {
+func EditHandler(store *store.Store, config ServerConfig) handleFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		config := requestConfig(r, config)
 		var errs []error
-		state, err := store.Get()
+		state, err := requestState(r, store, config)
 		if err != nil {
 			errs = append(errs, err)
 		}
 
+		id := r.URL.Query().Get("id")
+		var edit *storage.Stream
+		for _, stream := range state.Streams {
+			if stream.Id == id {
+				edit = stream
+			}
+		}
+		if edit == nil {
+			errs = append(errs, fmt.Errorf("stream %v not found", id))
+		}
+
 		sort.SliceStable(state.Streams, func(i, j int) bool {
 			return state.Streams[i].Name < state.Streams[j].Name
 		})
@@ -200,7 +1306,10 @@ func FormHandler(store *store.Store, config ServerConfig) handleFunc {
 			State:        state,
 			Config:       config,
 			CsrfTemplate: csrf.TemplateField(r),
+			Admin:        isAdmin(config, r),
+			Text:         requestText(config, r),
 			Errors:       errs,
+			Edit:         edit,
 		}
 		err = templates.ExecuteTemplate(w, "form.html", data)
 		if err != nil {
@@ -209,47 +1318,77 @@ func FormHandler(store *store.Store, config ServerConfig) handleFunc {
 	}
 }
 
-func AddHandler(store *store.Store, config ServerConfig) handleFunc {
+func UpdateHandler(store *store.Store, config ServerConfig, auditLog *audit.Log) handleFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		var errs []error
+		config := requestConfig(r, config)
+		id := r.PostFormValue("id")
+		input := StreamInput{
+			Application:    r.PostFormValue("application"),
+			Name:           r.PostFormValue("name"),
+			AuthKey:        r.PostFormValue("auth_key"),
+			PlayKey:        r.PostFormValue("play_key"),
+			AuthExpire:     formExpiry(r),
+			Notes:          r.PostFormValue("notes"),
+			AllowedIPs:     splitList(r.PostFormValue("allowed_ips")),
+			DeniedIPs:      splitList(r.PostFormValue("denied_ips")),
+			MaxPublishers:  parseCount(r.PostFormValue("max_publishers")),
+			MaxBitrateKbps: parseCount(r.PostFormValue("max_bitrate_kbps")),
 
-		expiry := parseExpiry(r.PostFormValue("auth_expire"))
-		if expiry == nil {
-			errs = append(errs, fmt.Errorf("invalid auth expiry: '%v'", r.PostFormValue("auth_expire")))
+			MaxSession:        r.PostFormValue("max_session"),
+			BlockAfterSession: r.PostFormValue("block_after_session") == "true",
+			Tags:              splitTags(r.PostFormValue("tags")),
+			ActiveFrom:        r.PostFormValue("active_from"),
+			ActiveUntil:       r.PostFormValue("active_until"),
+			Owner:             r.PostFormValue("owner"),
+			AllowedCountries:  splitList(r.PostFormValue("allowed_countries")),
 		}
-
-		name := r.PostFormValue("name")
-		if len(name) == 0 {
-			errs = append(errs, fmt.Errorf("stream name must be set"))
+		stream, errs := validateStream(input, config)
+		if len(errs) == 0 {
+			var err error
+			if stream.Owner, err = streamOwner(config, r, input.Owner); err != nil {
+				errs = append(errs, err)
+			} else if err := checkCollision(store, stream, id); err != nil {
+				errs = append(errs, err)
+			}
 		}
 
-		// TODO: more validation
 		if len(errs) == 0 {
-			stream := &storage.Stream{
-				Name:        name,
-				Application: r.PostFormValue("application"),
-				AuthKey:     r.PostFormValue("auth_key"),
-				AuthExpire:  *expiry,
-				Notes:       r.PostFormValue("notes"),
+			err := checkOwner(r, store, config, id)
+			if err == nil {
+				err = store.UpdateStream(id, stream)
+			}
+			if err == nil && stream.PlayKey == "" && r.PostFormValue("remove_play_key") == "true" {
+				err = store.SetPlayKey(id, "")
 			}
-
-			err := store.AddStream(stream)
 			if err != nil {
-				errs = append(errs, fmt.Errorf("failed to add stream: %w", err))
+				errs = append(errs, fmt.Errorf("failed to update stream: %w", err))
 			} else {
-				http.Redirect(w, r, config.Prefix, http.StatusSeeOther)
+				slog.Info("stream", "action", "update", "id", id, "app", stream.Application, "name", stream.Name)
+				auditLog.Record(audit.Entry{Action: "update", Id: id, Application: stream.Application, Name: stream.Name, User: requestUser(r)})
+				http.Redirect(w, r, config.Prefix+"/", http.StatusSeeOther)
+				return
 			}
 		}
 
-		state, err := store.Get()
+		state, err := requestState(r, store, config)
 		if err != nil {
 			errs = append(errs, err)
 		}
+		// Stay in the edit view
+		var edit *storage.Stream
+		for _, stream := range state.Streams {
+			if stream.Id == id {
+				edit = stream
+			}
+		}
 		data := TemplateData{
 			State:        state,
 			Config:       config,
 			CsrfTemplate: csrf.TemplateField(r),
+			Admin:        isAdmin(config, r),
+			Text:         requestText(config, r),
 			Errors:       errs,
+			Edit:         edit,
 		}
 		err = templates.ExecuteTemplate(w, "form.html", data)
 		if err != nil {
@@ -258,16 +1397,136 @@ func AddHandler(store *store.Store, config ServerConfig) handleFunc {
 	}
 }
 
-func RemoveHandler(store *store.Store, config ServerConfig) handleFunc {
+func AddKeyHandler(store *store.Store, config ServerConfig, auditLog *audit.Log) handleFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		config := requestConfig(r, config)
 		var errs []error
 		id := r.PostFormValue("id")
 
-		err := store.RemoveStream(id)
+		key := r.PostFormValue("auth_key")
+		err := checkOwner(r, store, config, id)
+		if err == nil {
+			err = validateKey("publish key", key)
+		}
+		if err == nil {
+			err = checkKeyStrength(config.KeyPolicy, "publish key", key)
+		}
+		if err == nil {
+			app, _ := lookupStream(store, id)
+			err = checkKeyPrefix(config, app, "publish key", key)
+		}
+		if err == nil {
+			err = store.AddKey(id, key)
+		}
 		if err != nil {
 			log.Println(err)
-			errs = append(errs, fmt.Errorf("failed to remove stream: %w", err))
-			state, err := store.Get()
+			errs = append(errs, fmt.Errorf("failed to add key: %w", err))
+			state, err := requestState(r, store, config)
+			if err != nil {
+				errs = append(errs, err)
+			}
+			data := TemplateData{
+				State:        state,
+				Config:       config,
+				CsrfTemplate: csrf.TemplateField(r),
+				Admin:        isAdmin(config, r),
+				Text:         requestText(config, r),
+				Errors:       errs,
+			}
+			err = templates.ExecuteTemplate(w, "form.html", data)
+			if err != nil {
+				log.Println("Template failed", err)
+			}
+		} else {
+			log.Printf("Added key to stream %v", id)
+			app, name := lookupStream(store, id)
+			auditLog.Record(audit.Entry{Action: "add key", Id: id, Application: app, Name: name, User: requestUser(r)})
+			http.Redirect(w, r, config.Prefix+"/", http.StatusSeeOther)
+		}
+	}
+}
+
+// generateKey is the random key used for streams added without one
+var generateKey = store.GenerateKey
+
+// renderGeneratedKey shows the form with a newly generated key so it can be copied
+func renderGeneratedKey(w http.ResponseWriter, r *http.Request, store *store.Store, config ServerConfig, message string, key string) {
+	var errs []error
+	state, err := requestState(r, store, config)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	data := TemplateData{
+		State:        state,
+		Config:       config,
+		CsrfTemplate: csrf.TemplateField(r),
+		Admin:        isAdmin(config, r),
+		Text:         requestText(config, r),
+		Errors:       errs,
+		Messages:     []string{message},
+		GeneratedKey: key,
+	}
+	if err := templates.ExecuteTemplate(w, "form.html", data); err != nil {
+		log.Println("Template failed", err)
+	}
+}
+
+// RegenerateKeyHandler replaces all keys of a stream with a random one
+func RegenerateKeyHandler(store *store.Store, config ServerConfig, auditLog *audit.Log) handleFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		config := requestConfig(r, config)
+		id := r.PostFormValue("id")
+
+		var key string
+		err := checkOwner(r, store, config, id)
+		if err == nil {
+			key, err = store.RegenerateKey(id)
+		}
+		if err != nil {
+			log.Println(err)
+			errs := []error{fmt.Errorf("failed to regenerate key: %w", err)}
+			state, err := requestState(r, store, config)
+			if err != nil {
+				errs = append(errs, err)
+			}
+			data := TemplateData{
+				State:        state,
+				Config:       config,
+				CsrfTemplate: csrf.TemplateField(r),
+				Admin:        isAdmin(config, r),
+				Text:         requestText(config, r),
+				Errors:       errs,
+			}
+			if err := templates.ExecuteTemplate(w, "form.html", data); err != nil {
+				log.Println("Template failed", err)
+			}
+			return
+		}
+
+		log.Printf("Regenerated key of stream %v", id)
+		app, name := lookupStream(store, id)
+		auditLog.Record(audit.Entry{Action: "regenerate key", Id: id, Application: app, Name: name, User: requestUser(r)})
+		renderGeneratedKey(w, r, store, config, fmt.Sprintf("regenerated key of stream %v/%v", app, name), key)
+	}
+}
+
+func RemoveKeyHandler(store *store.Store, config ServerConfig, auditLog *audit.Log) handleFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		config := requestConfig(r, config)
+		var errs []error
+		id := r.PostFormValue("id")
+
+		index, err := strconv.Atoi(r.PostFormValue("index"))
+		if err == nil {
+			err = checkOwner(r, store, config, id)
+		}
+		if err == nil {
+			err = store.RemoveKey(id, index)
+		}
+		if err != nil {
+			log.Println(err)
+			errs = append(errs, fmt.Errorf("failed to remove key: %w", err))
+			state, err := requestState(r, store, config)
 			if err != nil {
 				errs = append(errs, err)
 			}
@@ -275,6 +1534,8 @@ func RemoveHandler(store *store.Store, config ServerConfig) handleFunc {
 				State:        state,
 				Config:       config,
 				CsrfTemplate: csrf.TemplateField(r),
+				Admin:        isAdmin(config, r),
+				Text:         requestText(config, r),
 				Errors:       errs,
 			}
 			err = templates.ExecuteTemplate(w, "form.html", data)
@@ -282,15 +1543,21 @@ func RemoveHandler(store *store.Store, config ServerConfig) handleFunc {
 				log.Println("Template failed", err)
 			}
 		} else {
-			http.Redirect(w, r, config.Prefix, http.StatusSeeOther)
+			log.Printf("Removed key %v from stream %v", index, id)
+			app, name := lookupStream(store, id)
+			auditLog.Record(audit.Entry{Action: "remove key", Id: id, Application: app, Name: name, User: requestUser(r)})
+			http.Redirect(w, r, config.Prefix+"/", http.StatusSeeOther)
 		}
 	}
 }
 
-func BlockHandler(store *store.Store, config ServerConfig) handleFunc {
+// BlockHandler toggles the blocked state of a stream, with force=true a block also drops its publishers
+func BlockHandler(store *store.Store, config ServerConfig, kick *kicker, auditLog *audit.Log) handleFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		config := requestConfig(r, config)
 		var errs []error
 		id := r.PostFormValue("id")
+		force, _ := strconv.ParseBool(r.PostFormValue("force"))
 		last, _ := strconv.ParseBool(r.PostFormValue("blocked"))
 		new := false
 		action := "unblock"
@@ -301,7 +1568,7 @@ func BlockHandler(store *store.Store, config ServerConfig) handleFunc {
 
 		// Get Application/Name for stream id
 		var app, name string
-		state, err := store.Get()
+		state, err := requestState(r, store, config)
 		if err != nil {
 			errs = append(errs, err)
 		}
@@ -312,17 +1579,29 @@ func BlockHandler(store *store.Store, config ServerConfig) handleFunc {
 			}
 		}
 
-		err = store.SetBlocked(id, new)
+		err = checkOwner(r, store, config, id)
+		if err == nil {
+			err = store.SetBlocked(id, new)
+		}
 		if err != nil {
 			log.Println(err)
 			errs = append(errs, fmt.Errorf("failed to %v stream %v (%v/%v)", action, id, app, name))
+		} else {
+			slog.Info("stream", "action", action, "id", id, "app", app, "name", name)
+			auditLog.Record(audit.Entry{Action: action, Id: id, Application: app, Name: name, User: requestUser(r)})
+			if force && new {
+				if res := kickStream(kick, id, app, name, r, auditLog); !res.Kicked {
+					errs = append(errs, fmt.Errorf("blocked stream %v/%v but failed to drop its publishers: %v", app, name, res.Error))
+				}
+			}
 		}
-		log.Printf("%ved Stream %v (%v/%v)", action, id, app, name)
 		if len(errs) > 0 {
 			data := TemplateData{
 				State:        state,
 				Config:       config,
 				CsrfTemplate: csrf.TemplateField(r),
+				Admin:        isAdmin(config, r),
+				Text:         requestText(config, r),
 				Errors:       errs,
 			}
 			err = templates.ExecuteTemplate(w, "form.html", data)
@@ -330,7 +1609,92 @@ func BlockHandler(store *store.Store, config ServerConfig) handleFunc {
 				log.Println("Template failed", err)
 			}
 		} else {
-			http.Redirect(w, r, config.Prefix, http.StatusSeeOther)
+			http.Redirect(w, r, config.Prefix+"/", http.StatusSeeOther)
+		}
+	}
+}
+
+// DisableHandler toggles the disabled state of a stream, an administrative hold apart from blocking
+func DisableHandler(store *store.Store, config ServerConfig, auditLog *audit.Log) handleFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		config := requestConfig(r, config)
+		var errs []error
+		id := r.PostFormValue("id")
+		last, _ := strconv.ParseBool(r.PostFormValue("disabled"))
+		action := "enable"
+		if !last {
+			action = "disable"
+		}
+		app, name := lookupStream(store, id)
+
+		err := checkOwner(r, store, config, id)
+		if err == nil {
+			err = store.SetDisabled(id, !last)
+		}
+		if err != nil {
+			log.Println(err)
+			errs = append(errs, fmt.Errorf("failed to %v stream %v (%v/%v)", action, id, app, name))
+		} else {
+			slog.Info("stream", "action", action, "id", id, "app", app, "name", name)
+			auditLog.Record(audit.Entry{Action: action, Id: id, Application: app, Name: name, User: requestUser(r)})
+			http.Redirect(w, r, config.Prefix+"/", http.StatusSeeOther)
+			return
+		}
+
+		state, err := requestState(r, store, config)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		data := TemplateData{
+			State:        state,
+			Config:       config,
+			CsrfTemplate: csrf.TemplateField(r),
+			Admin:        isAdmin(config, r),
+			Text:         requestText(config, r),
+			Errors:       errs,
+		}
+		if err := templates.ExecuteTemplate(w, "form.html", data); err != nil {
+			log.Println("Template failed", err)
+		}
+	}
+}
+
+// MaintenanceHandler turns maintenance mode on or off, the form carries the current state
+func MaintenanceHandler(store *store.Store, config ServerConfig, auditLog *audit.Log) handleFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		config := requestConfig(r, config)
+		var errs []error
+		last, _ := strconv.ParseBool(r.PostFormValue("maintenance"))
+		action := "end maintenance"
+		if !last {
+			action = "start maintenance"
+		}
+
+		err := store.SetMaintenance(!last)
+		if err != nil {
+			log.Println(err)
+			errs = append(errs, fmt.Errorf("failed to %v", action))
+		} else {
+			slog.Info("maintenance", "action", action)
+			auditLog.Record(audit.Entry{Action: action, User: requestUser(r)})
+			http.Redirect(w, r, config.Prefix+"/", http.StatusSeeOther)
+			return
+		}
+
+		state, err := requestState(r, store, config)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		data := TemplateData{
+			State:        state,
+			Config:       config,
+			CsrfTemplate: csrf.TemplateField(r),
+			Admin:        isAdmin(config, r),
+			Text:         requestText(config, r),
+			Errors:       errs,
+		}
+		if err := templates.ExecuteTemplate(w, "form.html", data); err != nil {
+			log.Println("Template failed", err)
 		}
 	}
 }
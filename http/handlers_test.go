@@ -0,0 +1,665 @@
+package http
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/voc/rtmp-auth/storage"
+	"github.com/voc/rtmp-auth/store"
+)
+
+// newTestStore returns a store kept in a temporary state file, keys are stored as is
+func newTestStore(t *testing.T) *store.Store {
+	t.Helper()
+	s, err := store.NewStore(store.StoreConfig{
+		Backend:       "file",
+		File:          store.FileBackendConfig{Path: filepath.Join(t.TempDir(), "store.db")},
+		PlaintextKeys: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// addTestStream adds app/name with the publish key and returns it
+func addTestStream(t *testing.T, s *store.Store, app string, name string, key string) *storage.Stream {
+	t.Helper()
+	stream := &storage.Stream{Application: app, Name: name, AuthKeys: []string{key}, AuthExpire: -1}
+	if err := s.AddStream(stream); err != nil {
+		t.Fatal(err)
+	}
+	return stream
+}
+
+// postAuth sends an auth request with body of contentType to handler
+func postAuth(handler handleFunc, path string, contentType string, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	r.Header.Set("Content-Type", contentType)
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w
+}
+
+func TestParseISODuration(t *testing.T) {
+	day := 24 * time.Hour
+	tests := []struct {
+		in   string
+		want time.Duration
+		ok   bool
+	}{
+		{"P1M", 30 * day, true},
+		{"PT1M", time.Minute, true},
+		{"P1MT1M", 30*day + time.Minute, true},
+		{"P1D", day, true},
+		{"PT1.5H", 90 * time.Minute, true},
+		{"P1Y2M3DT4H5M6S", 365*day + 60*day + 3*day + 4*time.Hour + 5*time.Minute + 6*time.Second, true},
+		{"P", 0, true},
+		{"PT", 0, true},
+		{"", 0, false},
+		{"junk", 0, false},
+		{"1D", 0, false},
+		{"P1X", 0, false},
+		{"PT1D", 0, false},
+		{"P1H", 0, false},
+		{"P1DT", day, true},
+	}
+	for _, test := range tests {
+		got, ok := parseISODuration(test.in)
+		if ok != test.ok || got != test.want {
+			t.Errorf("parseISODuration(%q) = %v, %v, want %v, %v", test.in, got, ok, test.want, test.ok)
+		}
+	}
+}
+
+func TestParseExpiryRejectsEmptyDurations(t *testing.T) {
+	for _, in := range []string{"P", "PT", "junk"} {
+		if expiry := parseExpiry(in); expiry != nil {
+			t.Errorf("parseExpiry(%q) = %v, want nil", in, *expiry)
+		}
+	}
+	before := time.Now().Add(30 * time.Minute).Unix()
+	expiry := parseExpiry("PT30M")
+	if expiry == nil || *expiry < before || *expiry > before+1 {
+		t.Errorf("parseExpiry(PT30M) = %v, want about %v", expiry, before)
+	}
+}
+
+// mediaMTXPublish is an authHTTPAddress request of MediaMTX v1.9 for rtmp://host/live/foo?auth=secret123
+const mediaMTXPublish = `{"user":"","password":"","token":"","ip":"192.0.2.10","action":"publish",` +
+	`"path":"live/foo","protocol":"rtmp","id":"7f9c2d04-5bb3-4c6e-9fd2-2f0b1a9d6c38","query":"auth=secret123"}`
+
+func TestHandleMediaMTXRequest(t *testing.T) {
+	tests := []struct {
+		body                    string
+		app, name, auth, action string
+	}{
+		{mediaMTXPublish, "live", "foo", "secret123", "publish"},
+		{`{"ip":"192.0.2.10","action":"read","path":"live/foo","protocol":"rtsp","query":"auth=secret123"}`,
+			"live", "foo", "secret123", "play"},
+		{`{"user":"foo","password":"secret123","ip":"192.0.2.10","action":"playback","path":"live/foo","protocol":"webrtc","query":""}`,
+			"live", "foo", "secret123", "play"},
+		{`{"ip":"192.0.2.10","action":"publish","path":"events/2024/keynote","protocol":"srt","query":"auth=secret123"}`,
+			"events/2024", "keynote", "secret123", "publish"},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/auth/mediamtx", strings.NewReader(test.body))
+		app, name, auth, action, ip, err := handleMediaMTXRequest(r, []string{"auth"}, nil)
+		if err != nil {
+			t.Errorf("%s: %v", test.body, err)
+			continue
+		}
+		if app != test.app || name != test.name || auth != test.auth || action != test.action || ip != "192.0.2.10" {
+			t.Errorf("%s: parsed %q %q %q %q %q, want %q %q %q %q 192.0.2.10",
+				test.body, app, name, auth, action, ip, test.app, test.name, test.auth, test.action)
+		}
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/auth/mediamtx",
+		strings.NewReader(`{"ip":"192.0.2.10","action":"api","path":"","protocol":"","query":""}`))
+	if _, _, _, _, _, err := handleMediaMTXRequest(r, []string{"auth"}, nil); err == nil {
+		t.Error("api action parsed, want an error")
+	}
+}
+
+func TestMediaMTXAuthHandler(t *testing.T) {
+	s := newTestStore(t)
+	addTestStream(t, s, "live", "foo", "secret123")
+	handler := MediaMTXAuthHandler(s, ServerConfig{})
+
+	w := postAuth(handler, "/auth/mediamtx", "application/json", mediaMTXPublish)
+	if w.Code != http.StatusOK || w.Body.Len() != 0 {
+		t.Errorf("publish answered %d %q, want 200 without body", w.Code, w.Body.String())
+	}
+	w = postAuth(handler, "/auth/mediamtx", "application/json", strings.Replace(mediaMTXPublish, "secret123", "wrong", 1))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("publish with a wrong key answered %d, want 401", w.Code)
+	}
+}
+
+// nmsEvent is the form the README's Node-Media-Server script posts for an event with args {"auth":"secret123"}
+func nmsEvent(action string, path string) string {
+	return url.Values{"action": {action}, "StreamPath": {path}, "args": {`{"auth":"secret123"}`}, "ip": {"192.0.2.10"}}.Encode()
+}
+
+func TestHandleNMSRequest(t *testing.T) {
+	tests := []struct {
+		body                    string
+		app, name, auth, action string
+	}{
+		{nmsEvent("prePublish", "/live/foo"), "live", "foo", "secret123", "publish"},
+		{nmsEvent("postPublish", "/live/foo"), "live", "foo", "secret123", "publish"},
+		{nmsEvent("donePublish", "/live/foo"), "live", "foo", "secret123", "publish_done"},
+		{nmsEvent("prePlay", "/live/foo"), "live", "foo", "secret123", "play"},
+		{nmsEvent("donePlay", "/live/foo"), "live", "foo", "secret123", "play_done"},
+		{nmsEvent("prePublish", "/events/2024/keynote"), "events/2024", "keynote", "secret123", "publish"},
+		// Older scripts forward the raw query instead of the parsed args
+		{"action=prePublish&StreamPath=%2Flive%2Ffoo&args=%3Fauth%3Dsecret123&ip=192.0.2.10", "live", "foo", "secret123", "publish"},
+		{"action=prePublish&StreamPath=%2Flive%2Ffoo&args=&ip=192.0.2.10", "live", "foo", "", "publish"},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/auth/nms", strings.NewReader(test.body))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		app, name, auth, action, ip, err := handleNMSRequest(r, nil, []string{"auth"}, nil)
+		if err != nil {
+			t.Errorf("%s: %v", test.body, err)
+			continue
+		}
+		if app != test.app || name != test.name || auth != test.auth || action != test.action || ip != "192.0.2.10" {
+			t.Errorf("%s: parsed %q %q %q %q %q, want %q %q %q %q 192.0.2.10",
+				test.body, app, name, auth, action, ip, test.app, test.name, test.auth, test.action)
+		}
+	}
+
+	for _, body := range []string{
+		nmsEvent("preConnect", "/live/foo"),
+		"action=prePublish&StreamPath=%2Flive%2Ffoo&args=%7Bbroken",
+	} {
+		r := httptest.NewRequest(http.MethodPost, "/auth/nms", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if _, _, _, _, _, err := handleNMSRequest(r, nil, []string{"auth"}, nil); err == nil {
+			t.Errorf("%s: parsed, want an error", body)
+		}
+	}
+
+	// Mapped events and the client address without an ip field
+	r := httptest.NewRequest(http.MethodPost, "/auth/nms", strings.NewReader("action=preConnect&StreamPath=%2Flive%2Ffoo"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.RemoteAddr = "198.51.100.1:1234"
+	_, _, _, action, ip, err := handleNMSRequest(r, nil, []string{"auth"}, map[string]string{"preConnect": "play"})
+	if err != nil || action != "play" || ip != "198.51.100.1" {
+		t.Errorf("mapped preConnect parsed %q %q %v, want play 198.51.100.1", action, ip, err)
+	}
+}
+
+func TestNMSAuthHandler(t *testing.T) {
+	s := newTestStore(t)
+	stream := addTestStream(t, s, "live", "foo", "secret123")
+	handler := NMSAuthHandler(s, ServerConfig{})
+	active := func() bool {
+		t.Helper()
+		state, err := s.Get()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, s := range state.Streams {
+			if s.Id == stream.Id {
+				return s.Active
+			}
+		}
+		t.Fatal("stream not found")
+		return false
+	}
+
+	w := postAuth(handler, "/auth/nms", "application/x-www-form-urlencoded", nmsEvent("prePublish", "/live/foo"))
+	if w.Code != http.StatusOK || !active() {
+		t.Errorf("prePublish answered %d, active %v, want 200 and active", w.Code, active())
+	}
+	w = postAuth(handler, "/auth/nms", "application/x-www-form-urlencoded", nmsEvent("donePublish", "/live/foo"))
+	if w.Code != http.StatusOK || active() {
+		t.Errorf("donePublish answered %d, active %v, want 200 and inactive", w.Code, active())
+	}
+	w = postAuth(handler, "/auth/nms", "application/x-www-form-urlencoded",
+		strings.Replace(nmsEvent("prePublish", "/live/foo"), "secret123", "wrong", 1))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("prePublish with a wrong key answered %d, want 401", w.Code)
+	}
+	w = postAuth(handler, "/auth/nms", "application/x-www-form-urlencoded", nmsEvent("preConnect", "/live/foo"))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("unsupported event answered %d, want 401", w.Code)
+	}
+}
+
+// A viewer leaving with the play key is acknowledged without counting as a wrong key
+func TestNMSDonePlay(t *testing.T) {
+	s := newTestStore(t)
+	s.SetAutoBlock(1, time.Minute)
+	stream := &storage.Stream{Application: "live", Name: "foo", AuthKeys: []string{"secret123"}, PlayKey: "watch1234", AuthExpire: -1}
+	if err := s.AddStream(stream); err != nil {
+		t.Fatal(err)
+	}
+	handler := NMSAuthHandler(s, ServerConfig{AuthFailureLimit: 1, AuthFailureWindow: time.Minute})
+
+	donePlay := strings.Replace(nmsEvent("donePlay", "/live/foo"), "secret123", "watch1234", 1)
+	w := postAuth(handler, "/auth/nms", "application/x-www-form-urlencoded", donePlay)
+	if w.Code < 200 || w.Code > 299 {
+		t.Fatalf("donePlay with the play key answered %d, want 2xx", w.Code)
+	}
+	state, err := s.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Streams[0].Blocked {
+		t.Error("donePlay with the play key blocked the stream")
+	}
+	w = postAuth(handler, "/auth/nms", "application/x-www-form-urlencoded", nmsEvent("prePublish", "/live/foo"))
+	if w.Code != http.StatusOK {
+		t.Errorf("prePublish after donePlay answered %d, want 200", w.Code)
+	}
+}
+
+// authRequests are valid publish requests for live/foo with key secret123 in the format of each backend
+var authRequests = []struct {
+	backend     authBackend
+	contentType string
+	body        string
+}{
+	{backendNginx, "application/x-www-form-urlencoded",
+		"app=live&flashver=FMLE%2F3.0&swfurl=&tcurl=rtmp%3A%2F%2Flocalhost%2Flive&pageurl=&addr=192.0.2.10&clientid=1&call=publish&name=foo&type=live&auth=secret123"},
+	{backendSRS, "application/json",
+		`{"action":"on_publish","client_id":"1","ip":"192.0.2.10","vhost":"__defaultVhost__","app":"live","tcUrl":"rtmp://localhost/live","stream":"foo","param":"?auth=secret123"}`},
+	{backendMediaMTX, "application/json", mediaMTXPublish},
+	{backendNMS, "application/x-www-form-urlencoded",
+		"action=prePublish&id=1&ip=192.0.2.10&StreamPath=%2Flive%2Ffoo&args=%7B%22auth%22%3A%22secret123%22%7D"},
+}
+
+func TestAuthResponsePerBackend(t *testing.T) {
+	want := map[authBackend]string{backendNginx: "", backendSRS: "0", backendMediaMTX: "", backendNMS: ""}
+	for _, req := range authRequests {
+		s := newTestStore(t)
+		addTestStream(t, s, "live", "foo", "secret123")
+		handler := authHandler(s, ServerConfig{}, req.backend)
+
+		w := postAuth(handler, "/auth/"+string(req.backend), req.contentType, req.body)
+		if w.Code != http.StatusOK || w.Body.String() != want[req.backend] {
+			t.Errorf("%s: success answered %d %q, want 200 %q", req.backend, w.Code, w.Body.String(), want[req.backend])
+		}
+		w = postAuth(handler, "/auth/"+string(req.backend), req.contentType, strings.Replace(req.body, "secret123", "wrong", 1))
+		if w.Code != http.StatusUnauthorized || w.Body.String() != "401 Unauthorized\n" {
+			t.Errorf("%s: denial answered %d %q, want 401 \"401 Unauthorized\\n\"", req.backend, w.Code, w.Body.String())
+		}
+	}
+}
+
+// withAuthParam returns an auth request of authRequests passing the key in param instead of auth
+func withAuthParam(body string, param string) string {
+	body = strings.Replace(body, "auth=secret123", param+"=secret123", 1)
+	return strings.Replace(body, "%22auth%22", "%22"+param+"%22", 1)
+}
+
+func TestAuthParams(t *testing.T) {
+	tests := []struct {
+		params []string
+		param  string
+		want   map[authBackend]int
+	}{
+		{nil, "auth", nil},
+		{nil, "key", map[authBackend]int{backendNginx: 401, backendSRS: 401, backendMediaMTX: 401, backendNMS: 401}},
+		// SRS urls are commonly signed with secret or token
+		{nil, "secret", map[authBackend]int{backendNginx: 401, backendMediaMTX: 401, backendNMS: 401}},
+		{nil, "token", map[authBackend]int{backendNginx: 401, backendMediaMTX: 401, backendNMS: 401}},
+		{[]string{"auth", "key", "token", "password"}, "auth", nil},
+		{[]string{"auth", "key", "token", "password"}, "key", nil},
+		{[]string{"auth", "key", "token", "password"}, "token", nil},
+		{[]string{"auth", "key", "token", "password"}, "password", nil},
+		// The configured list replaces the defaults
+		{[]string{"key"}, "auth", map[authBackend]int{backendNginx: 401, backendSRS: 401, backendMediaMTX: 401, backendNMS: 401}},
+		{[]string{"key"}, "secret", map[authBackend]int{backendNginx: 401, backendSRS: 401, backendMediaMTX: 401, backendNMS: 401}},
+	}
+	for _, test := range tests {
+		for _, req := range authRequests {
+			s := newTestStore(t)
+			addTestStream(t, s, "live", "foo", "secret123")
+			handler := authHandler(s, ServerConfig{AuthParams: test.params}, req.backend)
+			want, ok := test.want[req.backend]
+			if !ok {
+				want = http.StatusOK
+			}
+			w := postAuth(handler, "/auth/"+string(req.backend), req.contentType, withAuthParam(req.body, test.param))
+			if w.Code != want {
+				t.Errorf("%s: key in %s with auth-params %v answered %d, want %d", req.backend, test.param, test.params, w.Code, want)
+			}
+		}
+	}
+}
+
+// The first non-empty parameter of auth-params is the key
+func TestAuthParamPrecedence(t *testing.T) {
+	s := newTestStore(t)
+	addTestStream(t, s, "live", "foo", "secret123")
+	handler := NginxAuthHandler(s, ServerConfig{AuthParams: []string{"key", "token"}})
+	publish := "app=live&name=foo&call=publish&addr=192.0.2.10&"
+	tests := []struct {
+		query string
+		want  int
+	}{
+		{"token=wrong&key=secret123", http.StatusOK},
+		{"key=wrong&token=secret123", http.StatusUnauthorized},
+		{"key=&token=secret123", http.StatusOK},
+	}
+	for _, test := range tests {
+		w := postAuth(handler, "/auth/nginx", "application/x-www-form-urlencoded", publish+test.query)
+		if w.Code != test.want {
+			t.Errorf("%s: answered %d, want %d", test.query, w.Code, test.want)
+		}
+		postAuth(handler, "/auth/nginx", "application/x-www-form-urlencoded", "app=live&name=foo&call=publish_done&key=secret123")
+	}
+
+	if err := checkAuthParams(ServerConfig{AuthParams: []string{"auth", " "}}); err == nil {
+		t.Error("empty parameter name accepted")
+	}
+}
+
+func TestAuthResponseDetectedBackend(t *testing.T) {
+	s := newTestStore(t)
+	addTestStream(t, s, "live", "foo", "secret123")
+	handler := AuthHandler(s, ServerConfig{})
+	for _, req := range authRequests[:2] {
+		w := postAuth(handler, "/auth", req.contentType, req.body)
+		want := ""
+		if req.backend == backendSRS {
+			want = "0"
+		}
+		if w.Code != http.StatusOK || w.Body.String() != want {
+			t.Errorf("%s: success answered %d %q, want 200 %q", req.backend, w.Code, w.Body.String(), want)
+		}
+		// Take the slot back for the next publish
+		postAuth(handler, "/auth", "application/x-www-form-urlencoded", "call=publish_done&app=live&name=foo&auth=secret123")
+	}
+}
+
+// slowBackend is a file backend whose cancellable reads and writes only return once their context is done
+type slowBackend struct {
+	store.Backend
+}
+
+func (b slowBackend) ReadContext(ctx context.Context) (*storage.State, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (b slowBackend) WriteContext(ctx context.Context, state *storage.State) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+// Auth requests on a stalled backend are answered with 503 once the auth timeout elapses
+func TestAuthTimeout(t *testing.T) {
+	backend, err := store.NewFileBackend(store.FileBackendConfig{Path: filepath.Join(t.TempDir(), "store.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := store.NewStoreWithBackend(store.StoreConfig{PlaintextKeys: true}, slowBackend{backend})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	addTestStream(t, s, "live", "foo", "secret123")
+
+	const timeout = 50 * time.Millisecond
+	for _, req := range authRequests {
+		handler := authHandler(s, ServerConfig{AuthTimeout: timeout}, req.backend)
+		start := time.Now()
+		w := postAuth(handler, "/auth/"+string(req.backend), req.contentType, req.body)
+		elapsed := time.Since(start)
+		if w.Code != http.StatusServiceUnavailable {
+			t.Errorf("%s: stalled publish answered %d, want 503", req.backend, w.Code)
+		}
+		if elapsed < timeout || elapsed > 20*timeout {
+			t.Errorf("%s: stalled publish answered after %v, want about %v", req.backend, elapsed, timeout)
+		}
+	}
+}
+
+func TestDetectBackend(t *testing.T) {
+	tests := []struct {
+		contentType string
+		want        authBackend
+	}{
+		{"application/json", backendSRS},
+		{"application/json; charset=utf-8", backendSRS},
+		{"Application/JSON", backendSRS},
+		{"application/x-www-form-urlencoded", backendNginx},
+		{"application/x-www-form-urlencoded; charset=UTF-8", backendNginx},
+		{"text/plain", backendNginx},
+		{"", backendNginx},
+		{"invalid;;", backendNginx},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/auth", nil)
+		r.Header.Set("Content-Type", test.contentType)
+		if got := detectBackend(r); got != test.want {
+			t.Errorf("detectBackend(%q) = %s, want %s", test.contentType, got, test.want)
+		}
+	}
+}
+
+// Content types with parameters or another label than the backend's still reach its parser
+func TestAuthContentTypes(t *testing.T) {
+	jsonBody := authRequests[1].body
+	formBody := authRequests[0].body
+	tests := []struct {
+		backend     string
+		contentType string
+		body        string
+	}{
+		{"", "application/json; charset=utf-8", jsonBody},
+		{"", "application/x-www-form-urlencoded; charset=UTF-8", formBody},
+		{"", "text/plain", formBody},
+		{"", "", formBody},
+		{"nginx", "text/plain;charset=UTF-8", formBody},
+		{"nginx", "application/json", formBody},
+		{"srs", "text/plain", jsonBody},
+		{"srs", "application/x-www-form-urlencoded", jsonBody},
+		{"auto", "application/json;charset=UTF-8", jsonBody},
+	}
+	for _, test := range tests {
+		s := newTestStore(t)
+		addTestStream(t, s, "live", "foo", "secret123")
+		handler := AuthHandler(s, ServerConfig{AuthBackend: test.backend})
+		w := postAuth(handler, "/auth", test.contentType, test.body)
+		if w.Code != http.StatusOK {
+			t.Errorf("auth-backend %q, %q: answered %d, want 200", test.backend, test.contentType, w.Code)
+		}
+	}
+
+	if _, err := parseAuthBackend(ServerConfig{AuthBackend: "wowza"}); err == nil {
+		t.Error("unknown auth-backend accepted")
+	}
+}
+
+// A one-time token is only used up by an accepted publish and denied for the next one
+func TestOneTimeTokenPublish(t *testing.T) {
+	s := newTestStore(t)
+	s.SetTokenSecret([]byte("token secret"))
+	s.SetOneTimeTokens(true)
+	stream := &storage.Stream{Application: "live", Name: "foo", AuthKeys: []string{"secret123"}, AuthExpire: -1, MaxPublishers: 1}
+	if err := s.AddStream(stream); err != nil {
+		t.Fatal(err)
+	}
+	token, err := store.MintOnceToken([]byte("token secret"), "live", "foo", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := NginxAuthHandler(s, ServerConfig{})
+	call := func(call string, auth string) int {
+		t.Helper()
+		body := url.Values{"app": {"live"}, "name": {"foo"}, "call": {call}, "addr": {"192.0.2.10"}, "auth": {auth}}
+		return postAuth(handler, "/auth/nginx", "application/x-www-form-urlencoded", body.Encode()).Code
+	}
+
+	// A publish denied by the publisher limit doesn't use up the token
+	if code := call("publish", "secret123"); code != http.StatusOK {
+		t.Fatalf("publish with the key answered %d, want 200", code)
+	}
+	if code := call("publish", token); code != http.StatusConflict {
+		t.Fatalf("token publish beyond the limit answered %d, want 409", code)
+	}
+	if used, _ := s.TokenUsed(context.Background(), token); used {
+		t.Fatal("token used up by a denied publish")
+	}
+	call("publish_done", "secret123")
+
+	if code := call("publish", token); code != http.StatusOK {
+		t.Fatalf("token publish answered %d, want 200", code)
+	}
+	if code := call("update_publish", token); code != http.StatusOK {
+		t.Errorf("update of the token's session answered %d, want 200", code)
+	}
+	if code := call("publish_done", token); code != http.StatusOK {
+		t.Errorf("unpublish of the token's session answered %d, want 200", code)
+	}
+	if code := call("publish", token); code != http.StatusUnauthorized {
+		t.Errorf("replayed token publish answered %d, want 401", code)
+	}
+}
+
+// srs5Publish is an on_publish callback of SRS 5.0 for rtmp://host/live/foo?secret=secret123&expire=<expire>
+const srs5Publish = `{"server_id":"vid-0xk989d","service_id":"plw27t19","action":"on_publish","client_id":"341w361a",` +
+	`"ip":"192.0.2.10","vhost":"__defaultVhost__","app":"live","tcUrl":"rtmp://192.0.2.1:1935/live","stream":"foo",` +
+	`"param":"?secret=secret123&expire=<expire>","stream_url":"/live/foo","stream_id":"vid-124q9y3"}`
+
+// srs5HLS is an on_hls callback of SRS 5.0, which carries no key
+const srs5HLS = `{"server_id":"vid-0xk989d","service_id":"plw27t19","action":"on_hls","client_id":"341w361a",` +
+	`"ip":"192.0.2.10","vhost":"__defaultVhost__","app":"live","tcUrl":"rtmp://192.0.2.1:1935/live","stream":"foo",` +
+	`"param":"","duration":9.36,"cwd":"/usr/local/srs","file":"./objs/nginx/html/live/foo-5.ts","url":"live/foo-5.ts",` +
+	`"m3u8":"./objs/nginx/html/live/foo.m3u8","m3u8_url":"live/foo.m3u8","seq_no":5,"stream_url":"/live/foo","stream_id":"vid-124q9y3"}`
+
+func srs5Request(expire int64) string {
+	return strings.Replace(srs5Publish, "<expire>", strconv.FormatInt(expire, 10), 1)
+}
+
+func TestHandleSRS5Request(t *testing.T) {
+	params := authParams(ServerConfig{}, backendSRS)
+	r := httptest.NewRequest(http.MethodPost, "/auth/srs", strings.NewReader(srs5Request(time.Now().Add(time.Hour).Unix())))
+	app, name, auth, action, ip, err := handleSRSRequest(r, nil, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if app != "live" || name != "foo" || auth != "secret123" || action != "on_publish" || ip != "192.0.2.10" {
+		t.Errorf("parsed %q %q %q %q %q, want live foo secret123 on_publish 192.0.2.10", app, name, auth, action, ip)
+	}
+
+	r = httptest.NewRequest(http.MethodPost, "/auth/srs", strings.NewReader(srs5Request(time.Now().Add(-time.Minute).Unix())))
+	if _, _, _, _, _, err := handleSRSRequest(r, nil, params); err == nil {
+		t.Error("expired url parsed, want an error")
+	}
+	r = httptest.NewRequest(http.MethodPost, "/auth/srs", strings.NewReader(strings.Replace(srs5Publish, "<expire>", "soon", 1)))
+	if _, _, _, _, _, err := handleSRSRequest(r, nil, params); err == nil {
+		t.Error("invalid expire parsed, want an error")
+	}
+}
+
+func TestSRS5Callbacks(t *testing.T) {
+	s := newTestStore(t)
+	addTestStream(t, s, "live", "foo", "secret123")
+	handler := SRSAuthHandler(s, ServerConfig{})
+	valid := srs5Request(time.Now().Add(time.Hour).Unix())
+	tests := []struct {
+		name string
+		body string
+		code int
+	}{
+		{"on_publish", valid, http.StatusOK},
+		{"on_hls without key", srs5HLS, http.StatusOK},
+		{"on_dvr without key", strings.Replace(srs5HLS, `"on_hls"`, `"on_dvr"`, 1), http.StatusOK},
+		{"on_play", strings.Replace(valid, `"on_publish"`, `"on_play"`, 1), http.StatusOK},
+		{"on_play with wrong key", strings.Replace(strings.Replace(valid, `"on_publish"`, `"on_play"`, 1), "secret123", "wrong", 1),
+			http.StatusUnauthorized},
+		{"on_publish expired", srs5Request(time.Now().Add(-time.Minute).Unix()), http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		w := postAuth(handler, "/auth/srs", "application/json", test.body)
+		if w.Code != test.code || (test.code == http.StatusOK && w.Body.String() != "0") {
+			t.Errorf("%s answered %d %q, want %d", test.name, w.Code, w.Body.String(), test.code)
+		}
+	}
+}
+
+func TestSRSRequestBody(t *testing.T) {
+	s := newTestStore(t)
+	addTestStream(t, s, "live", "foo", "secret123")
+	handler := SRSAuthHandler(s, ServerConfig{MaxBodySize: 1024})
+	valid := srs5Request(time.Now().Add(time.Hour).Unix())
+	oversize := strings.Replace(valid, `"param"`, `"padding":"`+strings.Repeat("x", 2048)+`","param"`, 1)
+	tests := []struct {
+		name   string
+		body   io.Reader
+		length int64
+		code   int
+	}{
+		// One byte per Read, as a slow connection delivers it
+		{"valid", iotest.OneByteReader(strings.NewReader(valid)), int64(len(valid)), http.StatusOK},
+		{"oversize", strings.NewReader(oversize), int64(len(oversize)), http.StatusRequestEntityTooLarge},
+		// Only the MaxBytesReader stops it
+		{"oversize chunked", strings.NewReader(oversize), -1, http.StatusRequestEntityTooLarge},
+		{"invalid json", strings.NewReader(`{"action":"on_publish",`), -1, http.StatusUnauthorized},
+		{"empty", strings.NewReader(""), 0, http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/auth/srs", test.body)
+		r.ContentLength = test.length
+		r.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		handler(w, r)
+		if w.Code != test.code {
+			t.Errorf("%s answered %d %q, want %d", test.name, w.Code, w.Body.String(), test.code)
+		}
+		// Free the slot of the valid publishes
+		if w.Code == http.StatusOK {
+			s.SetInactive(context.Background(), "live", "foo", "192.0.2.10")
+		}
+	}
+}
+
+// Requests sent with chunked transfer encoding have no ContentLength and still parse
+func TestChunkedAuthRequests(t *testing.T) {
+	for _, req := range authRequests {
+		s := newTestStore(t)
+		addTestStream(t, s, "live", "foo", "secret123")
+		handler := authHandler(s, ServerConfig{MaxBodySize: 1024}, req.backend)
+		var length int64
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			length = r.ContentLength
+			handler(w, r)
+		}))
+
+		// Hiding the length of the body makes the client send it chunked
+		post := func(body string) int {
+			t.Helper()
+			res, err := http.Post(server.URL, req.contentType, struct{ io.Reader }{strings.NewReader(body)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+			return res.StatusCode
+		}
+		if status := post(req.body); status != http.StatusOK || length != -1 {
+			t.Errorf("%s: chunked publish answered %d with ContentLength %d, want 200 with -1", req.backend, status, length)
+		}
+		if status := post(req.body + strings.Repeat(" ", 1024)); status != http.StatusRequestEntityTooLarge {
+			t.Errorf("%s: chunked publish beyond max-body-size answered %d, want 413", req.backend, status)
+		}
+		server.Close()
+	}
+}
@@ -0,0 +1,32 @@
+package http
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/voc/rtmp-auth/store"
+)
+
+// HealthHandler reports whether the store state can be read
+func HealthHandler(store *store.Store) handleFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := store.Ping(); err != nil {
+			log.Println("health:", err)
+			http.Error(w, "503 Service Unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok\n"))
+	}
+}
+
+// ReadyHandler additionally reports whether the storage backend is usable
+func ReadyHandler(store *store.Store) handleFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := store.Check(); err != nil {
+			log.Println("ready:", err)
+			http.Error(w, "503 Service Unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok\n"))
+	}
+}
@@ -0,0 +1,94 @@
+package http
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+
+	"github.com/gorilla/csrf"
+	"github.com/gorilla/mux"
+	"github.com/voc/rtmp-auth/storage"
+	"github.com/voc/rtmp-auth/store"
+)
+
+// APIConnectionEvent is the JSON representation of a publish or unpublish in the history of a stream
+type APIConnectionEvent struct {
+	// Time is the unix time of the auth request
+	Time int64 `json:"time"`
+	// Action is publish, reconnect, takeover or unpublish
+	Action string `json:"action"`
+	IP     string `json:"ip,omitempty"`
+	Name   string `json:"name"`
+}
+
+// StreamHistoryHandler returns the recent publishes and unpublishes of a stream as JSON, newest first
+func StreamHistoryHandler(store *store.Store, config ServerConfig) handleFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		err := checkOwner(r, store, config, id)
+		var events []*storage.ConnectionEvent
+		if err == nil {
+			events, err = store.History(id)
+		}
+		if isNotFound(err) {
+			writeJSONErrors(w, http.StatusNotFound, []error{err})
+			return
+		} else if err != nil {
+			log.Println("history", err)
+			writeJSONErrors(w, http.StatusInternalServerError, []error{fmt.Errorf("failed to get history: %w", err)})
+			return
+		}
+
+		res := make([]APIConnectionEvent, 0, len(events))
+		for _, event := range events {
+			res = append(res, APIConnectionEvent{Time: event.Time, Action: event.Action, IP: event.Ip, Name: event.Name})
+		}
+		writeJSON(w, http.StatusOK, res)
+	}
+}
+
+// HistoryHandler shows the stream list with the connection history of the stream ?id=
+func HistoryHandler(store *store.Store, config ServerConfig) handleFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		config := requestConfig(r, config)
+		var errs []error
+		state, err := requestState(r, store, config)
+		if err != nil {
+			errs = append(errs, err)
+		}
+
+		id := r.URL.Query().Get("id")
+		var stream *storage.Stream
+		for _, s := range state.Streams {
+			if s.Id == id {
+				stream = s
+			}
+		}
+		var events []*storage.ConnectionEvent
+		if stream == nil {
+			errs = append(errs, fmt.Errorf("stream %v not found", id))
+		} else if events, err = store.History(id); err != nil {
+			errs = append(errs, err)
+		}
+
+		sort.SliceStable(state.Streams, func(i, j int) bool {
+			return state.Streams[i].Name < state.Streams[j].Name
+		})
+
+		data := TemplateData{
+			State:         state,
+			Config:        config,
+			CsrfTemplate:  csrf.TemplateField(r),
+			Admin:         isAdmin(config, r),
+			Text:          requestText(config, r),
+			Errors:        errs,
+			History:       stream,
+			HistoryEvents: events,
+		}
+		err = templates.ExecuteTemplate(w, "form.html", data)
+		if err != nil {
+			log.Println("Template failed", err)
+		}
+	}
+}
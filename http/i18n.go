@@ -0,0 +1,272 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Catalog maps the message keys used by the templates to the text of one locale
+type Catalog map[string]string
+
+// Text holds the catalog chosen for a request, see requestText
+type Text struct {
+	// Locale is the name of the catalog, e.g. "de"
+	Locale  string
+	catalog Catalog
+}
+
+// Get returns the text of key, missing translations fall back to English and then to the key itself
+func (t Text) Get(key string) string {
+	if text, ok := t.catalog[key]; ok {
+		return text
+	}
+	if text, ok := englishCatalog[key]; ok {
+		return text
+	}
+	return key
+}
+
+// defaultLocale is the locale shipped with the templates
+const defaultLocale = "en"
+
+// catalogs by lowercase locale, loadLocaleDir adds those of the locale-dir on startup
+var catalogs = map[string]Catalog{defaultLocale: englishCatalog}
+
+var englishCatalog = Catalog{
+	"title":                "RTMP Admin",
+	"logout":               "Logout",
+	"streams":              "Streams",
+	"maintenance_mode":     "Maintenance mode",
+	"maintenance_info":     "New publishes are denied, live streams continue.",
+	"start_maintenance":    "Start maintenance",
+	"end_maintenance":      "End maintenance",
+	"error":                "Error",
+	"info":                 "Info",
+	"removed_stream":       "removed stream",
+	"undo":                 "Undo",
+	"generated_key":        "Generated key",
+	"generated_key_info":   "Copy the key now, it won't be shown again if keys are stored hashed.",
+	"copy":                 "Copy",
+	"summary_streams":      "streams",
+	"summary_live":         "live",
+	"summary_blocked":      "blocked",
+	"summary_disabled":     "disabled",
+	"summary_expired":      "expired",
+	"summary_expiring":     "expiring within 24h",
+	"search_placeholder":   "search name, application or notes",
+	"all_applications":     "all applications",
+	"search":               "Search",
+	"remove_tag_filter":    "remove tag filter",
+	"filter_by_tag":        "filter by tag",
+	"clear":                "Clear",
+	"show_removed":         "Show removed",
+	"hide_removed":         "Hide removed",
+	"export_csv":           "Export CSV",
+	"show_webhooks":        "Webhook log",
+	"hide_webhooks":        "Hide webhook log",
+	"webhook_deliveries":   "Webhook deliveries",
+	"test_webhooks":        "Send test event",
+	"no_deliveries":        "No deliveries yet",
+	"time":                 "Time",
+	"url":                  "URL",
+	"event":                "Event",
+	"status":               "Status",
+	"latency":              "Latency",
+	"history":              "History",
+	"history_of":           "Connection history of",
+	"no_history":           "No publishes yet",
+	"close_history":        "Close history",
+	"ip":                   "IP",
+	"removed_streams":      "Removed streams",
+	"removed":              "Removed",
+	"delete_permanently":   "Delete permanently",
+	"no_removed_streams":   "No removed streams",
+	"name":                 "Name",
+	"application":          "Application",
+	"live":                 "Live",
+	"auth":                 "Auth",
+	"blocked":              "Blocked",
+	"disabled":             "Disabled",
+	"expires":              "Expires",
+	"last_live":            "Last live",
+	"max_bitrate":          "Max bitrate",
+	"owner":                "Owner",
+	"notes":                "Notes",
+	"live_tag":             "live",
+	"disabled_tag":         "disabled",
+	"open_tag":             "open",
+	"ip_restricted_tag":    "ip restricted",
+	"publish_unavailable":  "publishing will fail while blocked, disabled or expired",
+	"key_hashed_append":    "key hashed, append it to the url",
+	"publish_keys":         "Publish keys",
+	"play_key_short":       "Play key",
+	"hashed":               "hashed",
+	"none":                 "none",
+	"remove":               "Remove",
+	"block_kick":           "Block and kick",
+	"kick_help":            "Block the stream and drop its publishers at the rtmp server",
+	"new_key":              "new key",
+	"add_key":              "Add key",
+	"regenerate":           "Regenerate",
+	"edit":                 "Edit",
+	"previous":             "Previous",
+	"next":                 "Next",
+	"page_of":              "Page %d of %d (%d streams)",
+	"add_stream":           "Add Stream",
+	"preset":               "Preset",
+	"preset_help":          "Fills the fields left empty with the settings of the preset",
+	"preset_application":   "application of the preset",
+	"edit_stream":          "Edit Stream",
+	"stream":               "Stream",
+	"enter_name":           "enter name",
+	"publish_key":          "Publish Key",
+	"keep_current_keys":    "keep current keys",
+	"random_key":           "random key",
+	"generate_key":         "Generate key",
+	"play_key":             "Play Key",
+	"play_key_help":        "Key for viewers, empty to play with the publish keys",
+	"keep_current_key":     "keep current key",
+	"publish_keys_default": "publish keys",
+	"remove_play_key":      "Remove play key",
+	"auth_expire":          "Auth Expire",
+	"auth_expire_help":     "ISO8601 Duration (e.g. P2DT10H) or empty for the application default (if any) or no expiry",
+	"never":                "never",
+	"expire_relative":      "relative",
+	"expire_absolute":      "absolute",
+	"expire_default":       "default or never",
+	"allowed_ips":          "Allowed IPs",
+	"allowed_ips_help":     "Comma separated addresses or CIDRs publishing is restricted to, empty for any",
+	"any":                  "any",
+	"denied_ips":           "Denied IPs",
+	"denied_ips_help":      "Comma separated addresses or CIDRs rejected even with a valid key",
+	"allowed_countries":    "Allowed Countries",
+	"countries_help":       "Comma separated country codes like DE, AT publishing is restricted to, checked with the GeoIP database",
+	"country_tag":          "country restricted",
+	"max_publishers":       "Max Publishers",
+	"max_publishers_help":  "Concurrent publishers allowed per stream name, further publishes are rejected",
+	"max_bitrate_kbps":     "Max Bitrate (kbps)",
+	"max_bitrate_help":     "Expected maximum bitrate for monitoring, it isn't enforced",
+	"unlimited":            "unlimited",
+	"max_session":          "Max Session",
+	"max_session_help":     "Publishing sessions are ended after this duration, e.g. 2h30m. Reconnects start a new session",
+	"no_limit":             "no limit",
+	"block_after_session":  "Block after the session ended",
+	"active_from":          "Active From",
+	"active_from_help":     "Publishing is only allowed within the window, RFC3339 time like 2024-05-03T18:00:00+02:00",
+	"no_start":             "no start",
+	"active_until":         "Active Until",
+	"no_end":               "no end",
+	"tags":                 "Tags",
+	"tags_placeholder":     "comma separated, e.g. customer-a, event",
+	"notes_placeholder":    "optional notes",
+	"yourself":             "yourself",
+	"overwrite":            "Overwrite an existing stream with the same application and name",
+	"submit":               "Submit",
+	"login_title":          "RTMP Admin Login",
+	"login":                "Login",
+	"user":                 "User",
+	"password":             "Password",
+}
+
+// loadLocaleDir adds a catalog for each *.json file of dir, named by the file, e.g. de.json or pt-BR.json.
+// The files are flat objects of message keys to text, keys they leave out are shown in English
+func loadLocaleDir(dir string) error {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	if len(paths) == 0 {
+		return fmt.Errorf("locale-dir %s: no *.json files", dir)
+	}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("locale-dir: %w", err)
+		}
+		var catalog Catalog
+		if err := json.Unmarshal(data, &catalog); err != nil {
+			return fmt.Errorf("locale-dir: %s: %w", path, err)
+		}
+		for key := range catalog {
+			if _, ok := englishCatalog[key]; !ok {
+				log.Printf("locale-dir: %s: unknown message key %s\n", path, key)
+			}
+		}
+		catalogs[strings.ToLower(strings.TrimSuffix(filepath.Base(path), ".json"))] = catalog
+		log.Println("Using locale", path)
+	}
+	return nil
+}
+
+// checkLocale verifies the default locale has a catalog
+func checkLocale(config ServerConfig) error {
+	if config.Locale == "" {
+		return nil
+	}
+	if _, ok := catalogs[strings.ToLower(config.Locale)]; !ok {
+		return fmt.Errorf("locale: no catalog for %s", config.Locale)
+	}
+	return nil
+}
+
+// requestText picks the catalog of the most preferred Accept-Language locale there is one for,
+// trying "de" for "de-AT" as well. Requests without a match get the configured default locale
+func requestText(config ServerConfig, r *http.Request) Text {
+	for _, locale := range acceptedLocales(r.Header.Get("Accept-Language")) {
+		if catalog, ok := catalogs[locale]; ok {
+			return Text{Locale: locale, catalog: catalog}
+		}
+		if base, _, found := strings.Cut(locale, "-"); found {
+			if catalog, ok := catalogs[base]; ok {
+				return Text{Locale: base, catalog: catalog}
+			}
+		}
+	}
+	locale := strings.ToLower(config.Locale)
+	if catalog, ok := catalogs[locale]; ok {
+		return Text{Locale: locale, catalog: catalog}
+	}
+	return Text{Locale: defaultLocale, catalog: englishCatalog}
+}
+
+// acceptedLocales returns the lowercase locales of an Accept-Language header ordered by their quality,
+// the wildcard and locales with quality 0 are left out
+func acceptedLocales(header string) []string {
+	type accepted struct {
+		locale  string
+		quality float64
+	}
+	var locales []accepted
+	for _, part := range strings.Split(header, ",") {
+		locale, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		locale = strings.ToLower(strings.TrimSpace(locale))
+		if locale == "" || locale == "*" {
+			continue
+		}
+		quality := 1.0
+		if value, found := strings.CutPrefix(strings.TrimSpace(params), "q="); found {
+			q, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			quality = q
+		}
+		if quality <= 0 {
+			continue
+		}
+		locales = append(locales, accepted{locale, quality})
+	}
+	sort.SliceStable(locales, func(i, j int) bool { return locales[i].quality > locales[j].quality })
+	res := make([]string, len(locales))
+	for i, locale := range locales {
+		res[i] = locale.locale
+	}
+	return res
+}
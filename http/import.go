@@ -0,0 +1,209 @@
+package http
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"mime"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/voc/rtmp-auth/audit"
+	"github.com/voc/rtmp-auth/storage"
+	"github.com/voc/rtmp-auth/store"
+)
+
+// ImportFailure describes why a row could not be imported, Index counts from 0 without the CSV header
+type ImportFailure struct {
+	Index  int      `json:"index"`
+	Errors []string `json:"errors"`
+}
+
+// ImportResult summarizes an import
+type ImportResult struct {
+	Created []APIStream     `json:"created"`
+	Skipped []int           `json:"skipped"`
+	Failed  []ImportFailure `json:"failed"`
+}
+
+func newImportFailure(index int, errs []error) ImportFailure {
+	failure := ImportFailure{Index: index}
+	for _, err := range errs {
+		failure.Errors = append(failure.Errors, err.Error())
+	}
+	return failure
+}
+
+func isDuplicate(err error) bool {
+	return errors.Is(err, store.ErrDuplicate)
+}
+
+// parseImportCSV reads stream definitions from CSV with a header row.
+// Columns are matched by name as written by the export, unknown ones like id or active are ignored
+func parseImportCSV(body io.Reader) ([]StreamInput, error) {
+	reader := csv.NewReader(body)
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("read header: %w", err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := columns["name"]; !ok {
+		return nil, fmt.Errorf("missing name column")
+	}
+
+	var inputs []StreamInput
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return inputs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		field := func(name string) string {
+			if i, ok := columns[name]; ok {
+				return record[i]
+			}
+			return ""
+		}
+		expiry := field("auth_expire")
+		if expiry == "never" {
+			expiry = ""
+		}
+		inputs = append(inputs, StreamInput{
+			Application:    field("application"),
+			Name:           field("name"),
+			AuthKey:        field("auth_key"),
+			AuthKeys:       strings.Fields(field("auth_keys")),
+			PlayKey:        field("play_key"),
+			AuthExpire:     expiry,
+			Notes:          field("notes"),
+			Owner:          field("owner"),
+			AllowedIPs:     splitList(field("allowed_ips")),
+			DeniedIPs:      splitList(field("denied_ips")),
+			MaxPublishers:  parseCount(field("max_publishers")),
+			MaxBitrateKbps: parseCount(field("max_bitrate_kbps")),
+
+			MaxSession:        field("max_session"),
+			BlockAfterSession: field("block_after_session") == "true",
+			Tags:              splitTags(field("tags")),
+			ActiveFrom:        field("active_from"),
+			ActiveUntil:       field("active_until"),
+			AllowedCountries:  splitList(field("allowed_countries")),
+		})
+	}
+}
+
+// ImportHandler adds streams from a JSON array or CSV body in a single write.
+// Nothing is added if any row is invalid. Existing streams with the same application and name
+// fail the import, unless ?duplicates=skip is given
+func ImportHandler(store *store.Store, config ServerConfig, auditLog *audit.Log) handleFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		var skipDuplicates bool
+		switch r.URL.Query().Get("duplicates") {
+		case "", "error":
+		case "skip":
+			skipDuplicates = true
+		default:
+			writeJSONErrors(w, http.StatusBadRequest, []error{fmt.Errorf("duplicates must be skip or error")})
+			return
+		}
+
+		// Requiring JSON or CSV keeps cross-site form posts out, as there is no CSRF token
+		var inputs []StreamInput
+		mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		switch {
+		case err == nil && mediaType == "application/json":
+			if err := json.NewDecoder(r.Body).Decode(&inputs); err != nil {
+				writeJSONErrors(w, http.StatusBadRequest, []error{fmt.Errorf("invalid body: %w", err)})
+				return
+			}
+		case err == nil && mediaType == "text/csv":
+			if inputs, err = parseImportCSV(r.Body); err != nil {
+				writeJSONErrors(w, http.StatusBadRequest, []error{fmt.Errorf("invalid body: %w", err)})
+				return
+			}
+		default:
+			writeJSONErrors(w, http.StatusUnsupportedMediaType,
+				[]error{fmt.Errorf("content type must be application/json or text/csv")})
+			return
+		}
+
+		state, err := store.Get()
+		if err != nil {
+			log.Println(err)
+			writeJSONErrors(w, http.StatusInternalServerError, []error{fmt.Errorf("failed to import streams: %w", err)})
+			return
+		}
+
+		result := ImportResult{Created: []APIStream{}, Skipped: []int{}, Failed: []ImportFailure{}}
+		streams := make([]*storage.Stream, len(inputs))
+		// Rows may also collide with each other
+		existing := slices.Clip(state.Streams)
+		for i, input := range inputs {
+			stream, errs := validateStream(input, config)
+			if len(errs) > 0 {
+				result.Failed = append(result.Failed, newImportFailure(i, errs))
+				continue
+			}
+			if stream.Owner, err = streamOwner(config, r, input.Owner); err != nil {
+				result.Failed = append(result.Failed, newImportFailure(i, []error{err}))
+				continue
+			}
+			if other := nameCollision(existing, stream, ""); other != nil {
+				result.Failed = append(result.Failed, newImportFailure(i, []error{collisionError(stream, other)}))
+				continue
+			}
+			streams[i] = stream
+			existing = append(existing, stream)
+		}
+		if len(result.Failed) > 0 {
+			writeJSON(w, http.StatusBadRequest, result)
+			return
+		}
+
+		duplicates, err := store.AddStreams(streams, skipDuplicates)
+		if isDuplicate(err) {
+			for _, i := range duplicates {
+				result.Failed = append(result.Failed, newImportFailure(i, []error{
+					fmt.Errorf("duplicate stream %v/%v", inputs[i].Application, inputs[i].Name)}))
+			}
+			writeJSON(w, http.StatusConflict, result)
+			return
+		}
+		if isQuotaReached(err) {
+			writeJSONErrors(w, http.StatusConflict, []error{err})
+			return
+		}
+		if err != nil {
+			log.Println(err)
+			writeJSONErrors(w, http.StatusInternalServerError, []error{fmt.Errorf("failed to import streams: %w", err)})
+			return
+		}
+
+		skipped := make(map[int]bool, len(duplicates))
+		for _, i := range duplicates {
+			skipped[i] = true
+		}
+		result.Skipped = append(result.Skipped, duplicates...)
+		for i, stream := range streams {
+			if skipped[i] {
+				continue
+			}
+			slog.Info("stream", "action", "add", "id", stream.Id, "app", stream.Application, "name", stream.Name)
+			auditLog.Record(audit.Entry{Action: "add", Id: stream.Id, Application: stream.Application, Name: stream.Name, User: requestUser(r)})
+			result.Created = append(result.Created, createdStream(stream, inputs[i]))
+		}
+		writeJSON(w, http.StatusCreated, result)
+	}
+}
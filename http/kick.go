@@ -0,0 +1,200 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/voc/rtmp-auth/audit"
+	"github.com/voc/rtmp-auth/webhook"
+)
+
+// KickConfig is the API of the rtmp server used to drop the publishers of blocked streams
+type KickConfig struct {
+	// Backend is nginx for the nginx-rtmp control module or srs for the SRS HTTP API
+	Backend string `toml:"backend"`
+	// URL is where the API is served, e.g. http://localhost:8080/control or http://localhost:1985
+	URL     string        `toml:"url"`
+	Timeout time.Duration `toml:"timeout"`
+}
+
+// MarshalJSON redacts the url, so logging the config doesn't leak its credentials
+func (c KickConfig) MarshalJSON() ([]byte, error) {
+	type config KickConfig
+	redacted := config(c)
+	if redacted.URL != "" {
+		redacted.URL = webhook.RedactURL(c.URL)
+	}
+	return json.Marshal(redacted)
+}
+
+// KickResult reports whether the publishers of a stream were dropped by the rtmp server
+type KickResult struct {
+	Kicked bool `json:"kicked"`
+	// Dropped is the number of connections the rtmp server closed
+	Dropped int    `json:"dropped"`
+	Error   string `json:"error,omitempty"`
+}
+
+var errNoKick = errors.New("kick: no rtmp server api configured")
+
+// kicker drops publishers through the API of the rtmp server, so blocking takes effect
+// without waiting for an auth request the publisher may never send
+type kicker struct {
+	backend string
+	url     string
+	client  *http.Client
+}
+
+// newKicker returns nil if no API is configured
+func newKicker(config KickConfig) (*kicker, error) {
+	if config.Backend == "" && config.URL == "" {
+		return nil, nil
+	}
+	if config.Backend != "nginx" && config.Backend != "srs" {
+		return nil, fmt.Errorf("kick: unknown backend %q, use nginx or srs", config.Backend)
+	}
+	if _, err := url.ParseRequestURI(config.URL); err != nil {
+		return nil, fmt.Errorf("kick: invalid url: %w", err)
+	}
+	timeout := config.Timeout
+	if timeout == 0 {
+		timeout = 5 * time.Second
+	}
+	return &kicker{
+		backend: config.Backend,
+		url:     strings.TrimSuffix(config.URL, "/"),
+		client:  &http.Client{Timeout: timeout},
+	}, nil
+}
+
+// kickPublisher drops the publishers of app/name and returns the number of closed connections
+func (k *kicker) kickPublisher(app, name string) (int, error) {
+	if k == nil {
+		return 0, errNoKick
+	}
+	switch k.backend {
+	case "srs":
+		return k.kickSRS(app, name)
+	default:
+		return k.kickNginx(app, name)
+	}
+}
+
+// kickStream drops the publishers of the stream, logs and records the outcome
+func kickStream(kick *kicker, id, app, name string, r *http.Request, auditLog *audit.Log) KickResult {
+	dropped, err := kick.kickPublisher(app, name)
+	if err != nil {
+		slog.Warn("stream", "action", "kick", "id", id, "app", app, "name", name, "error", err)
+		return KickResult{Error: err.Error()}
+	}
+	slog.Info("stream", "action", "kick", "id", id, "app", app, "name", name, "dropped", dropped)
+	auditLog.Record(audit.Entry{Action: "kick", Id: id, Application: app, Name: name, User: requestUser(r)})
+	return KickResult{Kicked: true, Dropped: dropped}
+}
+
+// kickNginx uses the drop command of the nginx-rtmp control module, which answers with the number of dropped clients
+func (k *kicker) kickNginx(app, name string) (int, error) {
+	query := url.Values{"app": {app}, "name": {name}}
+	res, err := k.client.Get(k.url + "/drop/publisher?" + query.Encode())
+	if err != nil {
+		return 0, fmt.Errorf("kick: %w", unwrapURLError(err))
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(res.Body, 1024))
+	if err != nil {
+		return 0, fmt.Errorf("kick: %w", err)
+	}
+	if res.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("kick: nginx answered %s", res.Status)
+	}
+	dropped, err := strconv.Atoi(strings.TrimSpace(string(body)))
+	if err != nil {
+		return 0, fmt.Errorf("kick: unexpected nginx response %q", body)
+	}
+	return dropped, nil
+}
+
+// srsClient is a connection listed by the SRS API, ids are numbers before SRS 4
+type srsClient struct {
+	Id      json.RawMessage `json:"id"`
+	URL     string          `json:"url"`
+	Publish bool            `json:"publish"`
+}
+
+// id returns the client id without the quotes of string ids
+func (c srsClient) id() string {
+	var id string
+	if err := json.Unmarshal(c.Id, &id); err == nil {
+		return id
+	}
+	return string(c.Id)
+}
+
+// kickSRS looks up the publishing clients of the stream and deletes them
+func (k *kicker) kickSRS(app, name string) (int, error) {
+	var list struct {
+		Code    int         `json:"code"`
+		Clients []srsClient `json:"clients"`
+	}
+	if err := k.srsRequest("GET", "/api/v1/clients/?start=0&count=1000", &list); err != nil {
+		return 0, err
+	}
+	if list.Code != 0 {
+		return 0, fmt.Errorf("kick: srs answered code %d", list.Code)
+	}
+
+	path := "/" + app + "/" + name
+	dropped := 0
+	for _, client := range list.Clients {
+		if !client.Publish || client.URL != path {
+			continue
+		}
+		var res struct {
+			Code int `json:"code"`
+		}
+		if err := k.srsRequest("DELETE", "/api/v1/clients/"+url.PathEscape(client.id()), &res); err != nil {
+			return dropped, err
+		}
+		if res.Code != 0 {
+			return dropped, fmt.Errorf("kick: srs answered code %d for client %s", res.Code, client.id())
+		}
+		dropped++
+	}
+	return dropped, nil
+}
+
+func (k *kicker) srsRequest(method, path string, res interface{}) error {
+	req, err := http.NewRequest(method, k.url+path, nil)
+	if err != nil {
+		return fmt.Errorf("kick: %w", err)
+	}
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("kick: %w", unwrapURLError(err))
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("kick: srs answered %s", resp.Status)
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 4<<20)).Decode(res); err != nil {
+		return fmt.Errorf("kick: invalid srs response: %w", err)
+	}
+	return nil
+}
+
+// unwrapURLError drops the request url from client errors, it may carry credentials
+func unwrapURLError(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return urlErr.Err
+	}
+	return err
+}
@@ -0,0 +1,82 @@
+package http
+
+import (
+	"sync"
+	"time"
+)
+
+// failureLimiter counts failed attempts per key within a sliding window
+type failureLimiter struct {
+	limit     int
+	window    time.Duration
+	mutex     sync.Mutex
+	failures  map[string][]time.Time
+	lastSweep time.Time
+}
+
+// newFailureLimiter returns a limiter or nil if limiting is disabled
+func newFailureLimiter(limit int, window time.Duration) *failureLimiter {
+	if limit <= 0 || window <= 0 {
+		return nil
+	}
+	return &failureLimiter{
+		limit:    limit,
+		window:   window,
+		failures: make(map[string][]time.Time),
+	}
+}
+
+// prune drops failures outside the window, expects the mutex to be held
+func (l *failureLimiter) prune(key string, now time.Time) []time.Time {
+	failures := l.failures[key]
+	start := 0
+	for start < len(failures) && now.Sub(failures[start]) >= l.window {
+		start++
+	}
+	failures = failures[start:]
+	if len(failures) == 0 {
+		delete(l.failures, key)
+	} else {
+		l.failures[key] = failures
+	}
+	return failures
+}
+
+// Limited returns true if key reached the failure limit within the window
+func (l *failureLimiter) Limited(key string) bool {
+	if l == nil {
+		return false
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return len(l.prune(key, time.Now())) >= l.limit
+}
+
+// Fail records a failed attempt for key
+func (l *failureLimiter) Fail(key string) {
+	if l == nil {
+		return
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	now := time.Now()
+	l.failures[key] = append(l.prune(key, now), now)
+
+	// Occasionally forget keys which stopped failing
+	if now.Sub(l.lastSweep) > l.window {
+		for k := range l.failures {
+			l.prune(k, now)
+		}
+		l.lastSweep = now
+	}
+}
+
+// Reset forgets all failures for key
+func (l *failureLimiter) Reset(key string) {
+	if l == nil {
+		return
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	delete(l.failures, key)
+}
@@ -0,0 +1,318 @@
+package http
+
+import (
+	"math"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/voc/rtmp-auth/storage"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 1000
+)
+
+// expiringSoon is how far ahead StreamSummary counts streams about to expire
+const expiringSoon = 24 * time.Hour
+
+// StreamSummary counts the streams by state
+type StreamSummary struct {
+	Total    int
+	Active   int
+	Blocked  int
+	Disabled int
+	Expired  int
+	// ExpiringSoon counts streams expiring within the next 24 hours
+	ExpiringSoon int
+}
+
+func summarize(streams []*storage.Stream, now time.Time) StreamSummary {
+	summary := StreamSummary{Total: len(streams)}
+	soon := now.Add(expiringSoon).Unix()
+	for _, stream := range streams {
+		if stream.Active {
+			summary.Active++
+		}
+		if stream.Blocked {
+			summary.Blocked++
+		}
+		if stream.Disabled {
+			summary.Disabled++
+		}
+		// -1 never expires
+		if stream.AuthExpire == -1 {
+			continue
+		}
+		if stream.AuthExpire < now.Unix() {
+			summary.Expired++
+		} else if stream.AuthExpire < soon {
+			summary.ExpiringSoon++
+		}
+	}
+	return summary
+}
+
+// StreamFilter selects the streams shown in the list
+type StreamFilter struct {
+	// Query is matched case-insensitively against name, application and notes
+	Query       string
+	Application string
+	// Tags must all be set on a stream
+	Tags []string
+}
+
+// parseTagFilter returns the ?tag= values of query
+func parseTagFilter(query url.Values) []string {
+	var tags []string
+	for _, tag := range query["tag"] {
+		if tag = strings.TrimSpace(tag); tag != "" && !slices.Contains(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+func (filter StreamFilter) Active() bool {
+	return filter.Query != "" || filter.Application != "" || len(filter.Tags) > 0
+}
+
+// query returns the filter as url parameters
+func (filter StreamFilter) query() url.Values {
+	query := url.Values{}
+	if filter.Query != "" {
+		query.Set("q", filter.Query)
+	}
+	if filter.Application != "" {
+		query.Set("app", filter.Application)
+	}
+	for _, tag := range filter.Tags {
+		query.Add("tag", tag)
+	}
+	return query
+}
+
+// TagURL returns the query string additionally filtering by tag
+func (filter StreamFilter) TagURL(tag string) string {
+	if !slices.Contains(filter.Tags, tag) {
+		filter.Tags = append(filter.Tags[:len(filter.Tags):len(filter.Tags)], tag)
+	}
+	return "?" + filter.query().Encode()
+}
+
+// WithoutTagURL returns the query string no longer filtering by tag
+func (filter StreamFilter) WithoutTagURL(tag string) string {
+	var tags []string
+	for _, t := range filter.Tags {
+		if t != tag {
+			tags = append(tags, t)
+		}
+	}
+	filter.Tags = tags
+	return "?" + filter.query().Encode()
+}
+
+func (filter StreamFilter) match(stream *storage.Stream) bool {
+	if filter.Application != "" && stream.Application != filter.Application {
+		return false
+	}
+	for _, tag := range filter.Tags {
+		if !slices.Contains(stream.Tags, tag) {
+			return false
+		}
+	}
+	if filter.Query == "" {
+		return true
+	}
+	query := strings.ToLower(filter.Query)
+	for _, field := range []string{stream.Name, stream.Application, stream.Notes} {
+		if strings.Contains(strings.ToLower(field), query) {
+			return true
+		}
+	}
+	return false
+}
+
+// filterStreams returns the matching streams, keeping their order
+func filterStreams(streams []*storage.Stream, filter StreamFilter) []*storage.Stream {
+	if !filter.Active() {
+		return streams
+	}
+	res := make([]*storage.Stream, 0, len(streams))
+	for _, stream := range streams {
+		if filter.match(stream) {
+			res = append(res, stream)
+		}
+	}
+	return res
+}
+
+// Pagination describes the shown page of the stream list
+type Pagination struct {
+	// Page is the current page starting at 1
+	Page  int
+	Size  int
+	Pages int
+	// Total is the number of streams matching the filter
+	Total int
+	// query holds the other list parameters to keep in page links
+	query url.Values
+}
+
+// paginate returns the streams of page, page and size are clamped to valid values
+func paginate(streams []*storage.Stream, page int, size int, query url.Values) ([]*storage.Stream, Pagination) {
+	if size > maxPageSize {
+		size = maxPageSize
+	}
+	pages := (len(streams) + size - 1) / size
+	if pages == 0 {
+		pages = 1
+	}
+	if page < 1 {
+		page = 1
+	} else if page > pages {
+		page = pages
+	}
+	start := (page - 1) * size
+	end := start + size
+	if end > len(streams) {
+		end = len(streams)
+	}
+	return streams[start:end], Pagination{
+		Page:  page,
+		Size:  size,
+		Pages: pages,
+		Total: len(streams),
+		query: query,
+	}
+}
+
+func (p Pagination) HasPrev() bool {
+	return p.Page > 1
+}
+
+func (p Pagination) HasNext() bool {
+	return p.Page < p.Pages
+}
+
+// URL returns the query string linking to page
+func (p Pagination) URL(page int) string {
+	query := url.Values{}
+	for key, values := range p.query {
+		query[key] = values
+	}
+	query.Set("page", strconv.Itoa(page))
+	query.Set("size", strconv.Itoa(p.Size))
+	return "?" + query.Encode()
+}
+
+// sortColumns are the columns the stream list can be sorted by
+var sortColumns = []string{"name", "application", "expiry", "active"}
+
+// StreamSort is the column and direction of the stream list
+type StreamSort struct {
+	Column string
+	Desc   bool
+	// query holds the other list parameters to keep in header links
+	query url.Values
+}
+
+// parseSort reads sort and order from query, defaulting to ascending by name
+func parseSort(query url.Values) StreamSort {
+	res := StreamSort{Column: "name", Desc: query.Get("order") == "desc"}
+	for _, column := range sortColumns {
+		if query.Get("sort") == column {
+			res.Column = column
+		}
+	}
+	base := url.Values{}
+	for key, values := range query {
+		base[key] = values
+	}
+	for _, key := range []string{"sort", "order", "page", "added"} {
+		base.Del(key)
+	}
+	res.query = base
+	return res
+}
+
+// URL returns the query string sorting by column, toggling the order of the current column
+func (s StreamSort) URL(column string) string {
+	query := url.Values{}
+	for key, values := range s.query {
+		query[key] = values
+	}
+	query.Set("sort", column)
+	if column == s.Column && !s.Desc {
+		query.Set("order", "desc")
+	}
+	return "?" + query.Encode()
+}
+
+// Indicator marks the header of the sorted column
+func (s StreamSort) Indicator(column string) string {
+	if column != s.Column {
+		return ""
+	}
+	if s.Desc {
+		return "▼"
+	}
+	return "▲"
+}
+
+// sortKey returns the sort value of the column, never expiring streams sort last
+// and live streams first in ascending order
+func sortKey(stream *storage.Stream, column string) int64 {
+	switch column {
+	case "expiry":
+		if stream.AuthExpire == -1 {
+			return math.MaxInt64
+		}
+		return stream.AuthExpire
+	case "active":
+		if stream.Active {
+			return 0
+		}
+		return 1
+	}
+	return 0
+}
+
+// compareStreams orders by column, ties are broken by name, application and id
+// so pages stay consistent when the backend order changes
+func compareStreams(a, b *storage.Stream, column string) int {
+	switch column {
+	case "application":
+		if c := strings.Compare(a.Application, b.Application); c != 0 {
+			return c
+		}
+	case "expiry", "active":
+		if ka, kb := sortKey(a, column), sortKey(b, column); ka != kb {
+			if ka < kb {
+				return -1
+			}
+			return 1
+		}
+	}
+	if c := strings.Compare(a.Name, b.Name); c != 0 {
+		return c
+	}
+	if c := strings.Compare(a.Application, b.Application); c != 0 {
+		return c
+	}
+	return strings.Compare(a.Id, b.Id)
+}
+
+func sortStreams(streams []*storage.Stream, s StreamSort) {
+	sort.SliceStable(streams, func(i, j int) bool {
+		c := compareStreams(streams[i], streams[j], s.Column)
+		if s.Desc {
+			return c > 0
+		}
+		return c < 0
+	})
+}
@@ -0,0 +1,115 @@
+package http
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/voc/rtmp-auth/storage"
+	"github.com/voc/rtmp-auth/store"
+)
+
+var (
+	authSuccess = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "rtmp_auth_success_total",
+		Help: "Number of successful auth requests",
+	}, []string{"application", "action"})
+	authFailure = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "rtmp_auth_failure_total",
+		Help: "Number of failed auth requests",
+	}, []string{"application", "action", "reason"})
+	unknownActions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "rtmp_auth_unknown_actions_total",
+		Help: "Number of auth requests with an action which isn't among the known ones",
+	}, []string{"application"})
+)
+
+func init() {
+	prometheus.MustRegister(authSuccess, authFailure, unknownActions)
+}
+
+// activeCollector reports the active streams per application. The values are derived from the
+// store state on every scrape, so they are right after restarts and drop to zero with the last unpublish.
+// Every configured application and every application with streams gets a series, active or not
+type activeCollector struct {
+	store        *store.Store
+	applications []string
+	desc         *prometheus.Desc
+}
+
+func (c *activeCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *activeCollector) Collect(ch chan<- prometheus.Metric) {
+	state, err := c.store.Get()
+	if err != nil {
+		return
+	}
+	counts := make(map[string]float64)
+	for _, app := range c.applications {
+		counts[app] = 0
+	}
+	for _, stream := range state.Streams {
+		if stream.Active {
+			counts[stream.Application]++
+		} else if _, ok := counts[stream.Application]; !ok {
+			counts[stream.Application] = 0
+		}
+	}
+	for app, n := range counts {
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, n, app)
+	}
+}
+
+// registerStoreMetrics adds gauges derived from the store state
+func registerStoreMetrics(store *store.Store, config ServerConfig) {
+	count := func(match func(*storage.Stream) bool) func() float64 {
+		return func() float64 {
+			state, err := store.Get()
+			if err != nil {
+				return 0
+			}
+			var n float64
+			for _, stream := range state.Streams {
+				if match(stream) {
+					n++
+				}
+			}
+			return n
+		}
+	}
+	prometheus.MustRegister(
+		&activeCollector{
+			store:        store,
+			applications: config.Applications,
+			desc: prometheus.NewDesc("rtmp_auth_active_streams",
+				"Number of currently active streams", []string{"application"}, nil),
+		},
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "rtmp_auth_blocked_streams",
+			Help: "Number of blocked streams",
+		}, count(func(stream *storage.Stream) bool { return stream.Blocked })),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "rtmp_auth_disabled_streams",
+			Help: "Number of disabled streams",
+		}, count(func(stream *storage.Stream) bool { return stream.Disabled })),
+	)
+}
+
+var knownActions = []string{
+	"publish", "on_publish",
+	"unpublish", "on_unpublish",
+	"play", "on_play",
+	"publish_done", "play_done",
+	"update_publish", "done",
+	"on_dvr", "on_hls",
+}
+
+// metricLabel limits label values taken from requests to a known set,
+// so unauthenticated callers can't create unbounded time series
+func metricLabel(value string, known []string) string {
+	for _, k := range known {
+		if value == k {
+			return value
+		}
+	}
+	return "other"
+}
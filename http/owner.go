@@ -0,0 +1,130 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+
+	"github.com/voc/rtmp-auth/storage"
+	"github.com/voc/rtmp-auth/store"
+)
+
+// notFoundResult is the auth check result of streams the user can't see
+var notFoundResult = store.AuthResult{Reason: store.ReasonNotFound}
+
+// isAdmin reports whether the user of r sees and manages all streams. Everyone does
+// as long as no admins are configured or admin auth is disabled
+func isAdmin(config ServerConfig, r *http.Request) bool {
+	user := requestUser(r)
+	return user == "" || len(config.Admins) == 0 || slices.Contains(config.Admins, user)
+}
+
+// owns reports whether the user of r may see and manage stream
+func owns(config ServerConfig, r *http.Request, stream *storage.Stream) bool {
+	return isAdmin(config, r) || stream.Owner == requestUser(r)
+}
+
+// ownedStreams returns the streams the user of r may see, in the same order
+func ownedStreams(config ServerConfig, r *http.Request, streams []*storage.Stream) []*storage.Stream {
+	if isAdmin(config, r) {
+		return streams
+	}
+	res := make([]*storage.Stream, 0, len(streams))
+	for _, stream := range streams {
+		if owns(config, r, stream) {
+			res = append(res, stream)
+		}
+	}
+	return res
+}
+
+// requestState returns the state with only the streams the user of r may see, for rendering the web-ui
+func requestState(r *http.Request, s *store.Store, config ServerConfig) (*storage.State, error) {
+	state, err := s.Get()
+	if err != nil {
+		return nil, err
+	}
+	state.Streams = ownedStreams(config, r, state.Streams)
+	return state, nil
+}
+
+// requestRemoved returns the removed streams the user of r may see
+func requestRemoved(r *http.Request, s *store.Store, config ServerConfig) ([]*storage.Stream, error) {
+	removed, err := s.Removed()
+	if err != nil {
+		return nil, err
+	}
+	return ownedStreams(config, r, removed), nil
+}
+
+// checkOwner fails with ErrNotFound unless the user of r may manage the stream with id, removed streams included.
+// Streams of other owners look like missing ones, so their ids can't be probed
+func checkOwner(r *http.Request, s *store.Store, config ServerConfig, id string) error {
+	if isAdmin(config, r) {
+		return nil
+	}
+	state, err := s.Get()
+	if err != nil {
+		return err
+	}
+	removed, err := s.Removed()
+	if err != nil {
+		return err
+	}
+	for _, stream := range append(state.Streams, removed...) {
+		if stream.Id == id && owns(config, r, stream) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %v", store.ErrNotFound, id)
+}
+
+// checkOwnerByName fails with ErrNotFound unless the user of r owns all streams defined for exactly app/name
+func checkOwnerByName(r *http.Request, s *store.Store, config ServerConfig, app string, name string) error {
+	if isAdmin(config, r) {
+		return nil
+	}
+	state, err := s.Get()
+	if err != nil {
+		return err
+	}
+	for _, stream := range state.Streams {
+		if stream.Application == app && stream.Name == name && !owns(config, r, stream) {
+			return fmt.Errorf("%w: %v/%v", store.ErrNotFound, app, name)
+		}
+	}
+	return nil
+}
+
+// streamOwner returns the owner of a stream added or updated by the user of r.
+// Admins may hand streams to any configured user, everyone else always owns what they add
+func streamOwner(config ServerConfig, r *http.Request, owner string) (string, error) {
+	if owner == "" || !isAdmin(config, r) {
+		return requestUser(r), nil
+	}
+	if _, ok := config.Users[owner]; !ok {
+		return "", fmt.Errorf("unknown owner: '%v'", owner)
+	}
+	return owner, nil
+}
+
+// adminOnly answers requests of users outside ServerConfig.Admins with 403
+func adminOnly(config ServerConfig, next handleFunc) handleFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isAdmin(config, r) {
+			http.Error(w, "403 Forbidden", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// checkAdmins verifies the admins are configured users
+func checkAdmins(config ServerConfig) error {
+	for _, admin := range config.Admins {
+		if _, ok := config.Users[admin]; !ok {
+			return fmt.Errorf("admins: %s is not in users", admin)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package http
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strings"
+)
+
+type forwardedPrefixKey struct{}
+
+// forwardedPrefix reads the X-Forwarded-Prefix of requests from trusted proxies,
+// which strip that prefix before passing the request on
+func forwardedPrefix(config ServerConfig) func(next http.Handler) http.Handler {
+	proxies := parseTrustedProxies(config.TrustedProxies)
+	return func(next http.Handler) http.Handler {
+		if !config.ForwardedPrefix {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			value := r.Header.Get("X-Forwarded-Prefix")
+			ip := parseIP(r.RemoteAddr)
+			if value == "" || ip == nil || !proxies.contains(ip) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			prefix, ok := cleanPrefix(value)
+			if !ok {
+				log.Printf("Ignoring invalid X-Forwarded-Prefix '%s'\n", value)
+				next.ServeHTTP(w, r)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), forwardedPrefixKey{}, prefix)))
+		})
+	}
+}
+
+// cleanPrefix checks a prefix is an absolute path which can't turn urls into another host
+// and removes trailing slashes
+func cleanPrefix(value string) (string, bool) {
+	prefix := strings.TrimRight(value, "/")
+	if prefix == "" {
+		return "", true
+	}
+	if !strings.HasPrefix(prefix, "/") || strings.HasPrefix(prefix, "//") || strings.ContainsAny(prefix, "?#\\\"'<> ") {
+		return "", false
+	}
+	return prefix, true
+}
+
+// urlPrefix returns the path all generated urls start with, the forwarded prefix if any followed by config.Prefix
+func urlPrefix(r *http.Request, prefix string) string {
+	if forwarded, ok := r.Context().Value(forwardedPrefixKey{}).(string); ok {
+		return forwarded + prefix
+	}
+	return prefix
+}
+
+// requestConfig returns config with Prefix as seen by the client, for templates and redirects.
+// With prefix "/admin" and "X-Forwarded-Prefix: /ingress" from a trusted proxy, adding a stream
+// through /admin/add redirects to /ingress/admin/?added=<id> and the form posts to /ingress/admin/add
+func requestConfig(r *http.Request, config ServerConfig) ServerConfig {
+	config.Prefix = urlPrefix(r, config.Prefix)
+	return config
+}
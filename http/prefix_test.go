@@ -0,0 +1,94 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestCleanPrefix(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+		ok   bool
+	}{
+		{"", "", true},
+		{"/", "", true},
+		{"/ingress", "/ingress", true},
+		{"/ingress/", "/ingress", true},
+		{"/a/b", "/a/b", true},
+		{"ingress", "", false},
+		{"//evil.example", "", false},
+		{"/a?b", "", false},
+		{"/a\"onclick", "", false},
+	}
+	for _, test := range tests {
+		got, ok := cleanPrefix(test.in)
+		if got != test.want || ok != test.ok {
+			t.Errorf("cleanPrefix(%q) = %q, %v, want %q, %v", test.in, got, ok, test.want, test.ok)
+		}
+	}
+}
+
+// prefixRequest returns a request from remote with X-Forwarded-Prefix forwarded set
+func prefixRequest(method string, target string, body string, remote string, forwarded string) *http.Request {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	if body != "" {
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	r.RemoteAddr = remote
+	if forwarded != "" {
+		r.Header.Set("X-Forwarded-Prefix", forwarded)
+	}
+	return r
+}
+
+// Redirects and form actions start with the forwarded prefix of trusted proxies followed by config.Prefix
+func TestPrefixedUrls(t *testing.T) {
+	config := ServerConfig{Prefix: "/admin", ForwardedPrefix: true, TrustedProxies: []string{"192.0.2.0/24"}}
+	tests := []struct {
+		name      string
+		remote    string
+		forwarded string
+		want      string
+	}{
+		{"no header", "192.0.2.1:1234", "", "/admin"},
+		{"trusted proxy", "192.0.2.1:1234", "/ingress/", "/ingress/admin"},
+		{"untrusted client", "198.51.100.1:1234", "/ingress", "/admin"},
+		{"invalid prefix", "192.0.2.1:1234", "//evil.example", "/admin"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := newTestStore(t)
+			form := url.Values{"application": {"live"}, "name": {"foo"}, "auth_key": {"secret123"}, "expire_mode": {"never"}}
+
+			w := httptest.NewRecorder()
+			add := forwardedPrefix(config)(http.HandlerFunc(AddHandler(s, config, nil)))
+			add.ServeHTTP(w, prefixRequest(http.MethodPost, "/admin/add", form.Encode(), test.remote, test.forwarded))
+			if w.Code != http.StatusSeeOther {
+				t.Fatalf("add status = %d, want %d: %s", w.Code, http.StatusSeeOther, w.Body.String())
+			}
+			if location := w.Header().Get("Location"); !strings.HasPrefix(location, test.want+"/?added=") {
+				t.Errorf("add redirects to %q, want %q", location, test.want+"/?added=<id>")
+			}
+
+			w = httptest.NewRecorder()
+			index := forwardedPrefix(config)(http.HandlerFunc(FormHandler(s, config, nil)))
+			index.ServeHTTP(w, prefixRequest(http.MethodGet, "/admin/", "", test.remote, test.forwarded))
+			if w.Code != http.StatusOK {
+				t.Fatalf("form status = %d, want %d", w.Code, http.StatusOK)
+			}
+			body := w.Body.String()
+			for _, action := range []string{"/add", "/remove", "/block", "/key/add"} {
+				if !strings.Contains(body, `action="`+test.want+action+`"`) {
+					t.Errorf("form has no action %q", test.want+action)
+				}
+			}
+			if !strings.Contains(body, `href="`+test.want+`/public/main.css"`) {
+				t.Errorf("form has no stylesheet %q", test.want+"/public/main.css")
+			}
+		})
+	}
+}
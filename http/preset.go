@@ -0,0 +1,97 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// StreamPreset pre-fills the add form for recurring setups, so only a name has to be entered.
+// Fields the form sets override those of the preset
+type StreamPreset struct {
+	Application      string   `toml:"application"`
+	AuthExpire       string   `toml:"auth-expire"`
+	Notes            string   `toml:"notes"`
+	AllowedIPs       []string `toml:"allowed-ips"`
+	DeniedIPs        []string `toml:"denied-ips"`
+	AllowedCountries []string `toml:"allowed-countries"`
+	MaxPublishers    int32    `toml:"max-publishers"`
+	MaxBitrateKbps   int32    `toml:"max-bitrate-kbps"`
+	MaxSession       string   `toml:"max-session"`
+	// BlockAfterSession can only be enabled by the preset, an unchecked box isn't sent by the form
+	BlockAfterSession bool     `toml:"block-after-session"`
+	Tags              []string `toml:"tags"`
+}
+
+// applyPreset fills the fields input leaves empty with those of preset
+func applyPreset(input StreamInput, preset StreamPreset) StreamInput {
+	if input.Application == "" {
+		input.Application = preset.Application
+	}
+	if input.AuthExpire == "" {
+		input.AuthExpire = preset.AuthExpire
+	}
+	if input.Notes == "" {
+		input.Notes = preset.Notes
+	}
+	if len(input.AllowedIPs) == 0 {
+		input.AllowedIPs = preset.AllowedIPs
+	}
+	if len(input.DeniedIPs) == 0 {
+		input.DeniedIPs = preset.DeniedIPs
+	}
+	if len(input.AllowedCountries) == 0 {
+		input.AllowedCountries = preset.AllowedCountries
+	}
+	if input.MaxPublishers == 0 {
+		input.MaxPublishers = preset.MaxPublishers
+	}
+	if input.MaxBitrateKbps == 0 {
+		input.MaxBitrateKbps = preset.MaxBitrateKbps
+	}
+	if input.MaxSession == "" {
+		input.MaxSession = preset.MaxSession
+	}
+	input.BlockAfterSession = input.BlockAfterSession || preset.BlockAfterSession
+	if len(input.Tags) == 0 {
+		input.Tags = preset.Tags
+	}
+	return input
+}
+
+// resolvePreset applies the preset of the given name, an empty name leaves input as is
+func resolvePreset(config ServerConfig, name string, input StreamInput) (StreamInput, error) {
+	if name == "" {
+		return input, nil
+	}
+	preset, ok := config.Presets[name]
+	if !ok {
+		return input, fmt.Errorf("unknown preset: '%v'", name)
+	}
+	return applyPreset(input, preset), nil
+}
+
+// checkPresets validates each preset like a stream added with it. Presets need an application,
+// the name is entered in the form and replaced by a wildcard, which passes any name-pattern
+func checkPresets(config ServerConfig) error {
+	for name, preset := range config.Presets {
+		if preset.Application == "" {
+			return fmt.Errorf("presets: %s: application must be set", name)
+		}
+		input := applyPreset(StreamInput{Name: "*"}, preset)
+		if _, errs := validateStream(input, config); len(errs) > 0 {
+			return fmt.Errorf("presets: %s: %w", name, errors.Join(errs...))
+		}
+	}
+	return nil
+}
+
+// Presets returns the names of the configured presets in order
+func (data TemplateData) Presets() []string {
+	names := make([]string, 0, len(data.Config.Presets))
+	for name := range data.Config.Presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
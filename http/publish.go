@@ -0,0 +1,62 @@
+package http
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/voc/rtmp-auth/storage"
+	"github.com/voc/rtmp-auth/store"
+)
+
+// PublishURL is the ready to copy url a streamer publishes to
+type PublishURL struct {
+	URL string
+	// Hashed is set if the url lacks the key, because the store only keeps its hash
+	Hashed bool
+	// Unavailable is set if publishing will fail, because the stream is blocked, disabled or expired
+	Unavailable bool
+}
+
+// newPublishURL fills template with the application and name of stream and adds its first key
+// as auth parameter. Returns nil if no publish url is configured
+func newPublishURL(template string, stream *storage.Stream) *PublishURL {
+	if template == "" {
+		return nil
+	}
+	replacer := strings.NewReplacer(
+		"{app}", url.PathEscape(stream.Application),
+		"{name}", url.PathEscape(stream.Name),
+	)
+	res := &PublishURL{
+		URL:         replacer.Replace(template),
+		Unavailable: stream.Blocked || stream.Disabled || expired(stream.AuthExpire),
+	}
+
+	keys := store.StreamKeys(stream)
+	if len(keys) == 0 {
+		return res
+	}
+	if store.IsHashedKey(keys[0]) {
+		res.Hashed = true
+		return res
+	}
+	separator := "?"
+	if strings.Contains(res.URL, "?") {
+		separator = "&"
+	}
+	// Spaces as + aren't decoded by every rtmp server
+	res.URL += separator + "auth=" + strings.ReplaceAll(url.QueryEscape(keys[0]), "+", "%20")
+	return res
+}
+
+// publishURLs returns the publish urls of streams by id
+func publishURLs(config ServerConfig, streams []*storage.Stream) map[string]*PublishURL {
+	if config.PublishURL == "" {
+		return nil
+	}
+	res := make(map[string]*PublishURL, len(streams))
+	for _, stream := range streams {
+		res[stream.Id] = newPublishURL(config.PublishURL, stream)
+	}
+	return res
+}
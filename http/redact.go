@@ -0,0 +1,73 @@
+package http
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+
+	"github.com/gorilla/handlers"
+)
+
+// secretParams are query parameters carrying keys or tokens, masked in the request log
+var secretParams = []string{"auth", "secret", "token", "password"}
+
+const redactedValue = "REDACTED"
+
+// redacted masks a key or token for logging unless config.LogKeys is set, empty values stay empty
+func redacted(config ServerConfig, secret string) string {
+	if config.LogKeys || secret == "" {
+		return secret
+	}
+	return redactedValue
+}
+
+// logAuthRequest logs a summary of a parsed auth request, raw bodies aren't logged as they carry the key
+func logAuthRequest(config ServerConfig, backend authBackend, app string, name string, auth string, action string, ip string) {
+	log.Printf("%s request: app=%s name=%s auth=%s action=%s ip=%s\n",
+		backend, app, name, redacted(config, auth), action, ip)
+}
+
+// redactURL returns u with the values of secretParams and the configured auth params masked
+func redactURL(config ServerConfig, u url.URL) url.URL {
+	if config.LogKeys || u.RawQuery == "" {
+		return u
+	}
+	query := u.Query()
+	changed := false
+	for _, param := range append(secretParams, config.AuthParams...) {
+		if values, ok := query[param]; ok {
+			for i := range values {
+				values[i] = redacted(config, values[i])
+			}
+			changed = true
+		}
+	}
+	if changed {
+		u.RawQuery = query.Encode()
+	}
+	return u
+}
+
+// requestLogger writes an access log in common log format with secretParams masked
+func requestLogger(config ServerConfig, out io.Writer) func(next http.Handler) http.Handler {
+	format := func(w io.Writer, params handlers.LogFormatterParams) {
+		host, _, err := net.SplitHostPort(params.Request.RemoteAddr)
+		if err != nil {
+			host = params.Request.RemoteAddr
+		}
+		user := "-"
+		if params.URL.User != nil && params.URL.User.Username() != "" {
+			user = params.URL.User.Username()
+		}
+		u := redactURL(config, params.URL)
+		fmt.Fprintf(w, "%s - %s [%s] \"%s %s %s\" %d %d\n", host, user,
+			params.TimeStamp.Format("02/Jan/2006:15:04:05 -0700"), params.Request.Method, u.RequestURI(),
+			params.Request.Proto, params.StatusCode, params.Size)
+	}
+	return func(next http.Handler) http.Handler {
+		return handlers.CustomLoggingHandler(out, next, format)
+	}
+}
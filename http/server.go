@@ -10,22 +10,157 @@ import (
 	"net/http"
 
 	"github.com/gorilla/csrf"
-	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rakyll/statik/fs"
+	"github.com/voc/rtmp-auth/audit"
+	"github.com/voc/rtmp-auth/mail"
 	_ "github.com/voc/rtmp-auth/statik"
 	"github.com/voc/rtmp-auth/store"
+	"github.com/voc/rtmp-auth/webhook"
 )
 
 type ServerConfig struct {
+	// Applications are the valid application names, any are accepted if empty
 	Applications []string `toml:"applications"`
-	Prefix       string   `toml:"prefix"`
-	Insecure     bool     `toml:"insecure"`
+	// OpenApplications publish and play without a key, publishes to unknown streams add them
+	OpenApplications []string `toml:"open-applications"`
+	// ApplicationQuotas are the maximum number of streams per application, unlimited if missing
+	ApplicationQuotas map[string]int `toml:"application-quotas"`
+	Prefix            string         `toml:"prefix"`
+	Insecure          bool           `toml:"insecure"`
+	// PageSize is the default number of streams per page in the web-ui
+	PageSize int `toml:"page-size"`
+	// FeedEvents is the number of recent publish and unpublish events in the Atom feed
+	FeedEvents int `toml:"feed-events"`
+	// LiveUpdates pushes active and blocked changes to the web-ui over a websocket
+	LiveUpdates bool `toml:"live-updates"`
+	// TemplateDir holds *.html files replacing the embedded templates of the same name, e.g. form.html
+	TemplateDir string `toml:"template-dir"`
+	// PublishURL is the url streamers publish to, {app} and {name} are replaced by the stream
+	PublishURL string `toml:"publish-url"`
+	// NamePattern and ApplicationPattern are the regular expressions new stream and application names must match
+	NamePattern        string `toml:"name-pattern"`
+	ApplicationPattern string `toml:"application-pattern"`
+	// ShutdownTimeout is how long in-flight requests may take to finish on shutdown
+	ShutdownTimeout time.Duration `toml:"shutdown-timeout"`
+	// AuthTimeout bounds the store access of auth requests, slower requests are answered with 503
+	AuthTimeout time.Duration `toml:"auth-timeout"`
+	// LogFormat selects text or json log output
+	LogFormat string `toml:"log-format"`
+	// DefaultExpiry maps application names to an ISO8601 duration
+	// applied when a stream is added without an explicit expiry
+	DefaultExpiry map[string]string `toml:"default-expiry"`
+	// AuthFailureLimit is the number of failed auth attempts per source IP
+	// within AuthFailureWindow after which requests are rejected, 0 disables
+	AuthFailureLimit  int           `toml:"auth-failure-limit"`
+	AuthFailureWindow time.Duration `toml:"auth-failure-window"`
+	// AutoBlockLimit is the number of wrong keys per stream within AutoBlockWindow after which
+	// the stream is blocked until unblocked by an admin, 0 disables
+	AutoBlockLimit  int           `toml:"auto-block-limit"`
+	AutoBlockWindow time.Duration `toml:"auto-block-window"`
+	// TrustedProxies are addresses or CIDRs whose X-Forwarded-For and X-Real-IP headers
+	// are honored when determining the client address of requests
+	TrustedProxies []string `toml:"trusted-proxies"`
+	// ForwardedPrefix prepends the X-Forwarded-Prefix header of trusted proxies to generated urls,
+	// for proxies which strip a path prefix before forwarding
+	ForwardedPrefix bool `toml:"forwarded-prefix"`
+	// LiveExpiryGrace keeps authorizing streams live under the published name for this long after their expiry
+	LiveExpiryGrace time.Duration `toml:"live-expiry-grace"`
+	// ExpiryWarning sends an expiring event to the webhooks once a stream expires within it, 0 disables
+	ExpiryWarning time.Duration `toml:"expiry-warning"`
+	// LogKeys disables masking auth keys and tokens in the logs, only meant for debugging
+	LogKeys bool `toml:"log-keys"`
+	// AuthParams are the request parameters the key is read from, the first non-empty one wins.
+	// Defaults to auth, and auth, secret and token for SRS
+	AuthParams []string `toml:"auth-params"`
+	// MaxBodySize limits auth request bodies in bytes, larger requests are rejected with 413
+	MaxBodySize int64 `toml:"max-body-size"`
+	// AuthBackend forces the parser of /auth to nginx, srs, mediamtx or nms. By default SRS is detected
+	// by its JSON content type and everything else is parsed as nginx-rtmp form
+	AuthBackend string `toml:"auth-backend"`
+	// DuplicatePublish decides about a publish to a stream which already has its maximum publishers,
+	// "deny" rejects the new publisher and "takeover" accepts it in place of the active one
+	DuplicatePublish string `toml:"duplicate-publish"`
+	// StreamKeyDelimiter enables reading the auth key from the stream name, like "name_KEY" with "_",
+	// for encoders which can't add a query parameter. Only used if the request carries no auth parameter
+	StreamKeyDelimiter string `toml:"stream-key-delimiter"`
+	// MetricsPath is where the API serves prometheus metrics
+	MetricsPath string `toml:"metrics-path"`
+	// HealthPath and ReadyPath are where the API serves liveness and readiness checks
+	HealthPath string `toml:"health-path"`
+	ReadyPath  string `toml:"ready-path"`
+	// Webhook receives stream publish/unpublish events
+	Webhook webhook.Config `toml:"webhook"`
+	// Mail sends selected events by email, enabled if it has recipients
+	Mail mail.Config `toml:"mail"`
+	// Audit records changes made through the web-ui and api
+	Audit audit.Config `toml:"audit"`
+	// Users maps admin user names to plaintext or bcrypt hashed passwords,
+	// the web-ui and api are open to anyone if empty
+	Users map[string]string `toml:"users" json:"-"`
+	// Admins are the users seeing and managing all streams, the others only see and manage the streams they own.
+	// All users are admins if empty
+	Admins []string `toml:"admins"`
+	// AuthMode is basic for HTTP basic auth or session for a login form
+	AuthMode string `toml:"auth-mode"`
+	// TokenSecret signs publish tokens, which are accepted in place of a stored key if set
+	TokenSecret string `toml:"token-secret" json:"-"`
+	// OneTimeTokens issues tokens with a nonce and rejects using one for a second publish
+	OneTimeTokens bool `toml:"one-time-tokens"`
+	// TLS serves the frontend and api over HTTPS
+	TLS TLSConfig `toml:"tls"`
+	// DenyResponses replace the 401 of denied auth requests by rtmp server (nginx, srs, mediamtx, nms or default)
+	// and auth failure reason, e.g. a 403 for blocked streams
+	DenyResponses map[string]map[string]DenyResponse `toml:"deny-responses"`
+	// SuccessResponses replace the 200 of authorized auth requests by rtmp server (nginx, srs, mediamtx or nms),
+	// e.g. a 204 for proxies in between. SRS answers with body "0" unless configured
+	SuccessResponses map[string]SuccessResponse `toml:"success-responses"`
+	// Locale is the web-ui language of browsers not accepting any of the available ones, English by default
+	Locale string `toml:"locale"`
+	// LocaleDir holds message catalogs like de.json, see loadLocaleDir
+	LocaleDir string `toml:"locale-dir"`
+	// Actions maps action names of rtmp servers to publish, unpublish or play, the built-in names keep working
+	Actions map[string]string `toml:"actions"`
+	// GeoIPDatabase is the path of a MaxMind country database, streams aren't restricted by country without it
+	GeoIPDatabase string `toml:"geoip-database"`
+	// Kick is the rtmp server api forced blocks drop publishers through
+	Kick KickConfig `toml:"kick"`
+	// Presets by name pre-fill streams added with them, see StreamPreset
+	Presets map[string]StreamPreset `toml:"presets"`
+	// KeyPolicy is the minimum strength of keys entered in the web-ui and api
+	KeyPolicy KeyPolicy `toml:"key-policy"`
+	// CORS lets browser apps on other origins use the JSON API of the frontend
+	CORS CORSConfig `toml:"cors"`
+	// StrictActions denies auth requests with unknown actions, which are otherwise authorized like a publish
+	StrictActions bool `toml:"strict-actions"`
+	// KeyPrefixes are the prefixes keys of an application must start with, e.g. "live_" for live
+	KeyPrefixes map[string]string `toml:"key-prefixes"`
+	// StaleTimeout sets publishers inactive without a publish or heartbeat for this long, 0 disables it
+	StaleTimeout time.Duration `toml:"stale-timeout"`
 }
 
 type Frontend struct {
-	server *http.Server
-	done   sync.WaitGroup
+	server          *http.Server
+	certs           *certReloader
+	auditLog        *audit.Log
+	live            *broadcaster
+	shutdownTimeout time.Duration
+	done            sync.WaitGroup
+}
+
+func shutdownTimeout(config ServerConfig) time.Duration {
+	if config.ShutdownTimeout > 0 {
+		return config.ShutdownTimeout
+	}
+	return 5 * time.Second
+}
+
+func authTimeout(config ServerConfig) time.Duration {
+	if config.AuthTimeout > 0 {
+		return config.AuthTimeout
+	}
+	return 2 * time.Second
 }
 
 func NewFrontend(address string, config ServerConfig, store *store.Store) *Frontend {
@@ -38,20 +173,131 @@ func NewFrontend(address string, config ServerConfig, store *store.Store) *Front
 	if err != nil {
 		log.Fatal(err)
 	}
+	auditLog, err := audit.NewLog(config.Audit)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := checkPatterns(config); err != nil {
+		log.Fatal(err)
+	}
+	if err := checkAdmins(config); err != nil {
+		log.Fatal(err)
+	}
+	if config.TemplateDir != "" {
+		if err := loadTemplateDir(config.TemplateDir); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if config.LocaleDir != "" {
+		if err := loadLocaleDir(config.LocaleDir); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := checkLocale(config); err != nil {
+		log.Fatal(err)
+	}
+	if err := checkKeyPolicy(config); err != nil {
+		log.Fatal(err)
+	}
+	if err := checkCORS(config); err != nil {
+		log.Fatal(err)
+	}
+	if err := checkKeyPrefixes(config); err != nil {
+		log.Fatal(err)
+	}
+	if err := checkPresets(config); err != nil {
+		log.Fatal(err)
+	}
+	kick, err := newKicker(config.Kick)
+	if err != nil {
+		log.Fatal(err)
+	}
+	admin := newAdminAuth(config, state.Secret)
+	history := newEventHistory(config.FeedEvents)
+	store.Subscribe(history.record)
+	var hooks *webhook.Notifier
+	if len(config.Webhook.URLs) > 0 {
+		hooks = webhook.NewNotifier(config.Webhook)
+		store.Subscribe(hooks.Notify)
+	}
+	var live *broadcaster
+	if config.LiveUpdates {
+		live = newBroadcaster(store)
+		store.Subscribe(live.notify)
+	}
 	router := mux.NewRouter()
-	router.Use(func(next http.Handler) http.Handler { return handlers.LoggingHandler(os.Stdout, next) })
+	router.Use(requestLogger(config, os.Stdout))
+	router.Use(forwardedPrefix(config))
+
+	// JSON API, registered first so it is matched before the form routes
+	api := router.PathPrefix(config.Prefix + "/api").Subrouter()
+	api.Use(admin.Middleware)
+	api.Path("/streams").Methods("GET").HandlerFunc(ListStreamsHandler(store, config))
+	api.Path("/active").Methods("GET").HandlerFunc(ActiveStreamsHandler(store, config))
+	api.Path("/streams").Methods("POST").HandlerFunc(CreateStreamHandler(store, config, auditLog))
+	api.Path("/check").Methods("GET").HandlerFunc(CheckHandler(store, config))
+	api.Path("/streams/{id}").Methods("GET").HandlerFunc(GetStreamHandler(store, config))
+	api.Path("/streams/{id}/extend").Methods("POST").HandlerFunc(ExtendHandler(store, config, auditLog))
+	api.Path("/streams/{id}/history").Methods("GET").HandlerFunc(StreamHistoryHandler(store, config))
+	api.Path("/block").Methods("POST").HandlerFunc(BlockByNameHandler(store, config, kick, auditLog))
+	// Settings affecting streams of all owners are left to admins
+	api.Path("/applications/{app}/block").Methods("POST").HandlerFunc(adminOnly(config, BlockApplicationHandler(store, auditLog)))
+	api.Path("/maintenance").Methods("GET").HandlerFunc(MaintenanceStatusHandler(store))
+	api.Path("/maintenance").Methods("POST").HandlerFunc(adminOnly(config, SetMaintenanceHandler(store, auditLog)))
+	api.Path("/import").Methods("POST").HandlerFunc(ImportHandler(store, config, auditLog))
+	if config.TokenSecret != "" {
+		// Tokens aren't bound to a stream, so they could be issued for names of other owners
+		api.Path("/tokens").Methods("POST").HandlerFunc(adminOnly(config, TokenHandler(config)))
+	}
+	api.Path("/audit").Methods("GET").HandlerFunc(adminOnly(config, AuditHandler(auditLog)))
+	if hooks != nil {
+		api.Path("/webhooks/test").Methods("POST").HandlerFunc(adminOnly(config, APIWebhookTestHandler(hooks, auditLog)))
+		api.Path("/webhooks/deliveries").Methods("GET").HandlerFunc(adminOnly(config, WebhookDeliveriesHandler(hooks)))
+	}
 
 	sub := router.PathPrefix(config.Prefix).Subrouter()
-	sub.Path("/").Methods("GET").HandlerFunc(FormHandler(store, config))
-	sub.Path("/add").Methods("POST").HandlerFunc(AddHandler(store, config))
-	sub.Path("/remove").Methods("POST").HandlerFunc(RemoveHandler(store, config))
-	sub.Path("/block").Methods("POST").HandlerFunc(BlockHandler(store, config))
+	sub.Use(CSRF)
+	sub.Use(admin.Middleware)
+	if admin != nil && admin.session {
+		sub.Path("/login").Methods("GET").HandlerFunc(LoginFormHandler(config))
+		sub.Path("/login").Methods("POST").HandlerFunc(LoginHandler(admin, config))
+		sub.Path("/logout").Methods("POST").HandlerFunc(LogoutHandler(admin, config))
+	}
+	sub.Path("/").Methods("GET").HandlerFunc(FormHandler(store, config, hooks))
+	sub.Path("/add").Methods("POST").HandlerFunc(AddHandler(store, config, auditLog))
+	sub.Path("/export.csv").Methods("GET").HandlerFunc(ExportHandler(store, config))
+	sub.Path("/feed.atom").Methods("GET").HandlerFunc(adminOnly(config, FeedHandler(history, config)))
+	if live != nil {
+		sub.Path("/ws").Methods("GET").HandlerFunc(LiveHandler(live, config))
+	}
+	sub.Path("/edit").Methods("GET").HandlerFunc(EditHandler(store, config))
+	sub.Path("/history").Methods("GET").HandlerFunc(HistoryHandler(store, config))
+	sub.Path("/update").Methods("POST").HandlerFunc(UpdateHandler(store, config, auditLog))
+	sub.Path("/remove").Methods("POST").HandlerFunc(RemoveHandler(store, config, auditLog))
+	sub.Path("/restore").Methods("POST").HandlerFunc(RestoreHandler(store, config, auditLog))
+	sub.Path("/block").Methods("POST").HandlerFunc(BlockHandler(store, config, kick, auditLog))
+	sub.Path("/disable").Methods("POST").HandlerFunc(DisableHandler(store, config, auditLog))
+	sub.Path("/maintenance").Methods("POST").HandlerFunc(adminOnly(config, MaintenanceHandler(store, config, auditLog)))
+	if hooks != nil {
+		sub.Path("/webhooks/test").Methods("POST").HandlerFunc(adminOnly(config, WebhookTestHandler(hooks, config, auditLog)))
+	}
+	sub.Path("/key/add").Methods("POST").HandlerFunc(AddKeyHandler(store, config, auditLog))
+	sub.Path("/key/regenerate").Methods("POST").HandlerFunc(RegenerateKeyHandler(store, config, auditLog))
+	sub.Path("/key/remove").Methods("POST").HandlerFunc(RemoveKeyHandler(store, config, auditLog))
 	sub.PathPrefix("/public/").Handler(
 		http.StripPrefix(config.Prefix+"/public/", http.FileServer(statikFS)))
 
+	certs, err := newCertReloader(config.TLS)
+	if err != nil {
+		log.Fatal(err)
+	}
 	frontend := &Frontend{
+		certs:           certs,
+		auditLog:        auditLog,
+		live:            live,
+		shutdownTimeout: shutdownTimeout(config),
 		server: &http.Server{
-			Handler:      CSRF(router),
+			Handler:      apiCORS(config)(router),
 			Addr:         address,
 			WriteTimeout: 15 * time.Second,
 			ReadTimeout:  15 * time.Second,
@@ -62,33 +308,88 @@ func NewFrontend(address string, config ServerConfig, store *store.Store) *Front
 	go func() {
 		defer frontend.done.Done()
 		log.Println("Frontend Listening on", frontend.server.Addr)
-		if err := frontend.server.ListenAndServe(); err != http.ErrServerClosed {
+		if err := listen(frontend.server, frontend.certs); err != http.ErrServerClosed {
 			log.Println(err)
 		}
 	}()
 	return frontend
 }
 
+// Stop waits for in-flight requests until the shutdown timeout
 func (frontend *Frontend) Stop() {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*500)
+	ctx, cancel := context.WithTimeout(context.Background(), frontend.shutdownTimeout)
 	defer cancel()
 	if err := frontend.server.Shutdown(ctx); err != nil {
 		log.Println("frontend shutdown:", err)
 	}
 	frontend.done.Wait()
+	// websockets are hijacked and not closed by Shutdown
+	frontend.live.Close()
+	frontend.certs.Stop()
+	if err := frontend.auditLog.Close(); err != nil {
+		log.Println("audit close:", err)
+	}
 }
 
 type API struct {
-	server *http.Server
-	done   sync.WaitGroup
+	server          *http.Server
+	certs           *certReloader
+	shutdownTimeout time.Duration
+	done            sync.WaitGroup
 }
 
 func NewAPI(address string, config ServerConfig, store *store.Store) *API {
+	if err := checkDenyResponses(config); err != nil {
+		log.Fatal(err)
+	}
+	if err := checkSuccessResponses(config); err != nil {
+		log.Fatal(err)
+	}
+	if _, err := publishPolicy(config); err != nil {
+		log.Fatal(err)
+	}
+	if err := checkAuthParams(config); err != nil {
+		log.Fatal(err)
+	}
+	if _, err := parseAuthBackend(config); err != nil {
+		log.Fatal(err)
+	}
+	if err := checkActions(config); err != nil {
+		log.Fatal(err)
+	}
 	router := mux.NewRouter()
-	router.Use(func(next http.Handler) http.Handler { return handlers.LoggingHandler(os.Stdout, next) })
-	router.Path("/auth").Methods("POST").HandlerFunc(AuthHandler(store))
+	router.Use(requestLogger(config, os.Stdout))
+	router.Path("/auth").Methods("POST").HandlerFunc(AuthHandler(store, config))
+	router.Path("/auth/nginx").Methods("POST").HandlerFunc(NginxAuthHandler(store, config))
+	router.Path("/auth/srs").Methods("POST").HandlerFunc(SRSAuthHandler(store, config))
+	router.Path("/auth/mediamtx").Methods("POST").HandlerFunc(MediaMTXAuthHandler(store, config))
+	router.Path("/auth/nms").Methods("POST").HandlerFunc(NMSAuthHandler(store, config))
 
+	metricsPath := config.MetricsPath
+	if metricsPath == "" {
+		metricsPath = "/metrics"
+	}
+	registerStoreMetrics(store, config)
+	router.Path(metricsPath).Methods("GET").Handler(promhttp.Handler())
+
+	healthPath := config.HealthPath
+	if healthPath == "" {
+		healthPath = "/healthz"
+	}
+	readyPath := config.ReadyPath
+	if readyPath == "" {
+		readyPath = "/readyz"
+	}
+	router.Path(healthPath).Methods("GET").HandlerFunc(HealthHandler(store))
+	router.Path(readyPath).Methods("GET").HandlerFunc(ReadyHandler(store))
+
+	certs, err := newCertReloader(config.TLS)
+	if err != nil {
+		log.Fatal(err)
+	}
 	api := &API{
+		certs:           certs,
+		shutdownTimeout: shutdownTimeout(config),
 		server: &http.Server{
 			Handler:      router,
 			Addr:         address,
@@ -101,7 +402,7 @@ func NewAPI(address string, config ServerConfig, store *store.Store) *API {
 	go func() {
 		defer api.done.Done()
 		log.Println("API Listening on", api.server.Addr)
-		if err := api.server.ListenAndServe(); err != http.ErrServerClosed {
+		if err := listen(api.server, api.certs); err != http.ErrServerClosed {
 			log.Println(err)
 		}
 	}()
@@ -109,11 +410,13 @@ func NewAPI(address string, config ServerConfig, store *store.Store) *API {
 	return api
 }
 
+// Stop waits for in-flight auth requests until the shutdown timeout
 func (api *API) Stop() {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*500)
+	ctx, cancel := context.WithTimeout(context.Background(), api.shutdownTimeout)
 	defer cancel()
 	if err := api.server.Shutdown(ctx); err != nil {
 		log.Println("api shutdown:", err)
 	}
 	api.done.Wait()
+	api.certs.Stop()
 }
@@ -0,0 +1,58 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// SuccessResponse is the answer to an authorized auth request
+type SuccessResponse struct {
+	// Status must be a 2xx code, 200 if unset
+	Status int `toml:"status"`
+	// Body is sent instead of the backend's default, "0" for SRS and none for the others
+	Body string `toml:"body"`
+}
+
+// checkSuccessResponses verifies the configured success responses are for known rtmp servers and can't be mistaken for a denial
+func checkSuccessResponses(config ServerConfig) error {
+	for backend, response := range config.SuccessResponses {
+		if _, ok := authBackends[backend]; !ok {
+			return fmt.Errorf("success-responses: unknown backend %q, use nginx, srs, mediamtx or nms", backend)
+		}
+		if response.Status != 0 && (response.Status < 200 || response.Status > 299) {
+			return fmt.Errorf("success-responses.%s: status %d is not a 2xx code", backend, response.Status)
+		}
+		if response.Body != "" && (response.Status == http.StatusNoContent || response.Status == http.StatusResetContent) {
+			return fmt.Errorf("success-responses.%s: status %d can't have a body", backend, response.Status)
+		}
+	}
+	return nil
+}
+
+// successResponse returns the configured response for backend, defaulting to a 200 with the body the backend expects
+func successResponse(config ServerConfig, backend authBackend) SuccessResponse {
+	res := SuccessResponse{Status: http.StatusOK}
+	if backend == backendSRS {
+		res.Body = "0"
+	}
+	configured := config.SuccessResponses[string(backend)]
+	if configured.Status != 0 {
+		res.Status = configured.Status
+	}
+	if configured.Body != "" {
+		res.Body = configured.Body
+	}
+	return res
+}
+
+// writeSuccess answers an authorized auth request with the response configured for backend
+func writeSuccess(w http.ResponseWriter, config ServerConfig, backend authBackend) {
+	res := successResponse(config, backend)
+	if res.Body == "" || res.Status == http.StatusNoContent || res.Status == http.StatusResetContent {
+		w.WriteHeader(res.Status)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(res.Status)
+	w.Write([]byte(res.Body))
+}
@@ -0,0 +1,69 @@
+package http
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// Each rtmp server gets the response configured for it, through its own handler and the detecting one
+func TestSuccessResponses(t *testing.T) {
+	config := ServerConfig{SuccessResponses: map[string]SuccessResponse{
+		"nginx":    {Status: http.StatusNoContent},
+		"srs":      {Body: `{"code":0}`},
+		"mediamtx": {Status: http.StatusAccepted, Body: "accepted"},
+		"nms":      {Status: http.StatusCreated},
+	}}
+	want := map[authBackend]SuccessResponse{
+		backendNginx:    {http.StatusNoContent, ""},
+		backendSRS:      {http.StatusOK, `{"code":0}`},
+		backendMediaMTX: {http.StatusAccepted, "accepted"},
+		backendNMS:      {http.StatusCreated, ""},
+	}
+	if err := checkSuccessResponses(config); err != nil {
+		t.Fatal(err)
+	}
+	for _, req := range authRequests {
+		s := newTestStore(t)
+		addTestStream(t, s, "live", "foo", "secret123")
+		handler := authHandler(s, config, req.backend)
+
+		w := postAuth(handler, "/auth/"+string(req.backend), req.contentType, req.body)
+		if w.Code != want[req.backend].Status || w.Body.String() != want[req.backend].Body {
+			t.Errorf("%s: success answered %d %q, want %d %q",
+				req.backend, w.Code, w.Body.String(), want[req.backend].Status, want[req.backend].Body)
+		}
+		// Denials keep their error status whatever the success response
+		w = postAuth(handler, "/auth/"+string(req.backend), req.contentType, strings.Replace(req.body, "secret123", "wrong", 1))
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("%s: denial answered %d, want 401", req.backend, w.Code)
+		}
+	}
+
+	s := newTestStore(t)
+	addTestStream(t, s, "live", "foo", "secret123")
+	handler := AuthHandler(s, config)
+	w := postAuth(handler, "/auth", authRequests[1].contentType, authRequests[1].body)
+	if w.Code != http.StatusOK || w.Body.String() != `{"code":0}` {
+		t.Errorf("detected srs: success answered %d %q, want 200 %q", w.Code, w.Body.String(), `{"code":0}`)
+	}
+}
+
+func TestCheckSuccessResponses(t *testing.T) {
+	tests := []struct {
+		responses map[string]SuccessResponse
+		ok        bool
+	}{
+		{nil, true},
+		{map[string]SuccessResponse{"srs": {Status: 299, Body: "0"}}, true},
+		{map[string]SuccessResponse{"wowza": {}}, false},
+		{map[string]SuccessResponse{"nginx": {Status: 302}}, false},
+		{map[string]SuccessResponse{"nginx": {Status: 199}}, false},
+		{map[string]SuccessResponse{"nginx": {Status: http.StatusNoContent, Body: "ok"}}, false},
+	}
+	for _, test := range tests {
+		if err := checkSuccessResponses(ServerConfig{SuccessResponses: test.responses}); (err == nil) != test.ok {
+			t.Errorf("checkSuccessResponses(%v) = %v, want ok %v", test.responses, err, test.ok)
+		}
+	}
+}
@@ -1,9 +1,19 @@
 package http
 
 import (
+	"fmt"
+	"hash/fnv"
 	"html/template"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/voc/rtmp-auth/storage"
+	"github.com/voc/rtmp-auth/store"
+	"github.com/voc/rtmp-auth/webhook"
 )
 
 type TemplateData struct {
@@ -11,14 +21,201 @@ type TemplateData struct {
 	Config       ServerConfig
 	CsrfTemplate template.HTML
 	Errors       []error
+	Messages     []string
+	// Edit is the stream shown in the edit form, nil to show the add form
+	Edit *storage.Stream
+	// Filter is the search applied to the stream list
+	Filter StreamFilter
+	// Pagination of the stream list, nil if all streams are shown
+	Pagination *Pagination
+	// Sort of the stream list, nil if the headers aren't sortable
+	Sort *StreamSort
+	// PublishURLs of the listed streams by id, nil if no publish url is configured
+	PublishURLs map[string]*PublishURL
+	// Summary counts all streams, nil if not shown
+	Summary *StreamSummary
+	// Undo is the stream removed by the previous request, offered for restoring
+	Undo *storage.Stream
+	// ShowRemoved lists the Removed streams
+	ShowRemoved bool
+	Removed     []*storage.Stream
+	// GeneratedKey is a newly generated auth key shown once after adding a stream or regenerating its key
+	GeneratedKey string
+	// Admin shows owners and the settings affecting all streams, see ServerConfig.Admins
+	Admin bool
+	// Text is the message catalog of the request's locale
+	Text Text
+	// ShowWebhooks lists the recent webhook Deliveries, newest first
+	ShowWebhooks bool
+	Deliveries   []webhook.Delivery
+	// History is the stream whose HistoryEvents are listed, newest first
+	History       *storage.Stream
+	HistoryEvents []*storage.ConnectionEvent
 }
 
-var templates = template.Must(template.New("form.html").Parse(
+// T returns the text of key in the locale of the request
+func (data TemplateData) T(key string) string {
+	return data.Text.Get(key)
+}
+
+var templateFuncs = template.FuncMap{
+	"hashedKey":        store.IsHashedKey,
+	"contains":         slices.Contains[[]string],
+	"streamKeys":       store.StreamKeys,
+	"activePublishers": store.ActivePublishers,
+	"maxPublishers":    store.MaxPublishers,
+	// anyBitrate tells if the bitrate column is shown
+	"anyBitrate": func(streams []*storage.Stream) bool {
+		return slices.ContainsFunc(streams, func(stream *storage.Stream) bool { return stream.MaxBitrateKbps > 0 })
+	},
+	"expiryValue": func(expiry int64) string {
+		if expiry == -1 {
+			return ""
+		}
+		return time.Unix(expiry, 0).Format(time.RFC3339)
+	},
+	// expiryLocal is the value of datetime-local inputs in server time, main.js converts it to the browser's
+	"expiryLocal": func(expiry int64) string {
+		if expiry == -1 {
+			return ""
+		}
+		return time.Unix(expiry, 0).Format(dateTimeLocalLayout)
+	},
+	"expiryTime": func(expiry int64) string {
+		return time.Unix(expiry, 0).Format("2006-01-02 15:04 MST")
+	},
+	"eventTime": func(timestamp int64) string {
+		return time.Unix(timestamp, 0).Format("2006-01-02 15:04:05 MST")
+	},
+	"expiresIn": expiresIn,
+	"expired":   expired,
+	"lastLive":  lastLive,
+	"sessionElapsed": func(started int64) string {
+		return humanDuration(time.Since(time.Unix(started, 0)))
+	},
+	"sessionCap": func(seconds int64) string {
+		return humanDuration(time.Duration(seconds) * time.Second)
+	},
+	"sessionValue": func(seconds int64) string {
+		if seconds == 0 {
+			return ""
+		}
+		return (time.Duration(seconds) * time.Second).String()
+	},
+	"join": func(values []string) string {
+		return strings.Join(values, ", ")
+	},
+	"tagClass":    tagClass,
+	"windowState": windowState,
+	"windowRange": windowRange,
+	"windowValue": func(bound int64) string {
+		if bound == 0 {
+			return ""
+		}
+		return time.Unix(bound, 0).Format(time.RFC3339)
+	},
+	"add": func(a, b int) int { return a + b },
+	"sub": func(a, b int) int { return a - b },
+}
+
+// windowState describes the activation window of a stream relative to now, empty without window
+func windowState(stream *storage.Stream) string {
+	if stream.ActiveFrom == 0 && stream.ActiveUntil == 0 {
+		return ""
+	}
+	now := time.Now().Unix()
+	switch {
+	case stream.ActiveFrom != 0 && now < stream.ActiveFrom:
+		return "scheduled"
+	case stream.ActiveUntil != 0 && now >= stream.ActiveUntil:
+		return "window passed"
+	}
+	return "live window"
+}
+
+// windowRange formats the bounds of the activation window
+func windowRange(stream *storage.Stream) string {
+	var parts []string
+	if stream.ActiveFrom != 0 {
+		parts = append(parts, "from "+time.Unix(stream.ActiveFrom, 0).Format(time.RFC1123))
+	}
+	if stream.ActiveUntil != 0 {
+		parts = append(parts, "until "+time.Unix(stream.ActiveUntil, 0).Format(time.RFC1123))
+	}
+	return strings.Join(parts, " ")
+}
+
+// tagColors is the number of tag chip colors in main.css
+const tagColors = 6
+
+// tagClass returns the color class of a tag, the same tag always gets the same color
+func tagClass(tag string) string {
+	hash := fnv.New32a()
+	hash.Write([]byte(tag))
+	return fmt.Sprintf("tagColor%d", hash.Sum32()%tagColors)
+}
+
+// expired returns true if an expiry timestamp lies in the past
+func expired(expiry int64) bool {
+	return expiry != -1 && expiry < time.Now().Unix()
+}
+
+// expiresIn formats the time until or since expiry, e.g. "in 3d 4h"
+func expiresIn(expiry int64) string {
+	if expiry == -1 {
+		return "never"
+	}
+	d := time.Until(time.Unix(expiry, 0))
+	if d < 0 {
+		return "expired " + humanDuration(-d) + " ago"
+	}
+	return "in " + humanDuration(d)
+}
+
+// lastLive formats the time since the last publish, e.g. "2d 3h ago"
+func lastLive(timestamp int64) string {
+	if timestamp == 0 {
+		return "never"
+	}
+	d := time.Since(time.Unix(timestamp, 0))
+	if d < time.Minute {
+		return "just now"
+	}
+	return humanDuration(d) + " ago"
+}
+
+// humanDuration formats a duration with its two largest units
+func humanDuration(d time.Duration) string {
+	units := []struct {
+		suffix string
+		length time.Duration
+	}{
+		{"d", 24 * time.Hour},
+		{"h", time.Hour},
+		{"m", time.Minute},
+		{"s", time.Second},
+	}
+	var parts []string
+	for _, unit := range units {
+		if n := d / unit.length; n > 0 || (len(parts) == 0 && unit.suffix == "s") {
+			parts = append(parts, fmt.Sprintf("%d%s", n, unit.suffix))
+			d -= n * unit.length
+		} else if len(parts) > 0 {
+			break
+		}
+		if len(parts) == 2 {
+			break
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+var templates = template.Must(template.New("form.html").Funcs(templateFuncs).Parse(
 	`<!DOCTYPE html>
-<html lang="en">
+<html lang="{{.Text.Locale}}">
 <head>
   <meta charset="UTF-8">
-  <title>RTMP Admin</title>
+  <title>{{$.T "title"}}</title>
   <meta name="viewport" content="width=device-width, initial-scale=1">
   <link rel="stylesheet" type="text/css" href="{{.Config.Prefix}}/public/mini-dark.css">
   <link rel="stylesheet" type="text/css" href="{{.Config.Prefix}}/public/main.css">
@@ -26,61 +223,347 @@ var templates = template.Must(template.New("form.html").Parse(
 <body>
   <div class="container">
     <h1><a href="{{$.Config.Prefix}}">rtmp-auth</a></h1>
-    <h2>Streams</h2>
+    {{if and .Config.Users (eq .Config.AuthMode "session")}}
+      <form class="inline logout" action="{{$.Config.Prefix}}/logout" method="POST">
+        {{ .CsrfTemplate }}
+        <button class="secondary">{{$.T "logout"}}</button>
+      </form>
+    {{end}}
+    <h2>{{$.T "streams"}}</h2>
 
     <div class="row">
+      {{if and .State .State.Maintenance}}
+        <div class="card warning">
+          <div class="section">
+            <h3>{{$.T "maintenance_mode"}}</h3>
+            <p>{{$.T "maintenance_info"}}</p>
+            {{if $.Admin}}
+              <form class="inline" action="{{$.Config.Prefix}}/maintenance" method="POST">
+                {{ $.CsrfTemplate }}
+                <input type="hidden" name="maintenance" value="true">
+                <button class="secondary">{{$.T "end_maintenance"}}</button>
+              </form>
+            {{end}}
+          </div>
+        </div>
+      {{end}}
       {{range .Errors}}
         <div class="card error">
           <div class="section">
-            <h3>Error</h3>
+            <h3>{{$.T "error"}}</h3>
             <p>{{.Error}}</p>
           </div>
         </div>
       {{end}}
+      {{range .Messages}}
+        <div class="card">
+          <div class="section">
+            <h3>{{$.T "info"}}</h3>
+            <p>{{.}}</p>
+          </div>
+        </div>
+      {{end}}
+      {{with .Undo}}
+        <div class="card">
+          <div class="section">
+            <h3>{{$.T "info"}}</h3>
+            <p>{{$.T "removed_stream"}} {{.Application}}/{{.Name}}</p>
+            <form class="inline" action="{{$.Config.Prefix}}/restore" method="POST">
+              {{ $.CsrfTemplate }}
+              <input type="hidden" name="id" value="{{.Id}}">
+              <button class="secondary">{{$.T "undo"}}</button>
+            </form>
+          </div>
+        </div>
+      {{end}}
+      {{if .GeneratedKey}}
+        <div class="card">
+          <div class="section">
+            <h3>{{$.T "generated_key"}}</h3>
+            <p>{{$.T "generated_key_info"}}</p>
+            <input class="authKey" value="{{.GeneratedKey}}" readonly/><button class="secondary copyToClipboard inputAddon">{{$.T "copy"}}</button>
+          </div>
+        </div>
+      {{end}}
     </div>
 
-    <table>
+    {{with .Summary}}
+      <p class="summary">
+        <span>{{.Total}} {{$.T "summary_streams"}}</span>
+        <span>{{.Active}} {{$.T "summary_live"}}</span>
+        <span>{{.Blocked}} {{$.T "summary_blocked"}}</span>
+        <span>{{.Disabled}} {{$.T "summary_disabled"}}</span>
+        <span>{{.Expired}} {{$.T "summary_expired"}}</span>
+        <span>{{.ExpiringSoon}} {{$.T "summary_expiring"}}</span>
+      </p>
+    {{end}}
+    {{if and .Admin .State (not .State.Maintenance)}}
+      <form class="inline" action="{{$.Config.Prefix}}/maintenance" method="POST">
+        {{ $.CsrfTemplate }}
+        <input type="hidden" name="maintenance" value="false">
+        <button class="secondary" title="{{$.T "maintenance_info"}}">{{$.T "start_maintenance"}}</button>
+      </form>
+    {{end}}
+
+    <form class="search" action="{{$.Config.Prefix}}/" method="GET">
+      <input type="search" name="q" placeholder="{{$.T "search_placeholder"}}" value="{{.Filter.Query}}">
+      <select name="app">
+        <option value="">{{$.T "all_applications"}}</option>
+        {{range $.Config.Applications}}
+          <option value="{{.}}"{{if eq $.Filter.Application .}} selected{{end}}>{{.}}</option>
+        {{end}}
+      </select>
+      {{range .Filter.Tags}}
+        <input type="hidden" name="tag" value="{{.}}">
+      {{end}}
+      <button class="secondary">{{$.T "search"}}</button>
+      {{range .Filter.Tags}}
+        <a href="{{$.Config.Prefix}}/{{$.Filter.WithoutTagURL .}}" title="{{$.T "remove_tag_filter"}}"><mark class="tag {{tagClass .}}">{{.}} &times;</mark></a>
+      {{end}}
+      {{if .Filter.Active}}
+        <a class="button secondary" href="{{$.Config.Prefix}}/">{{$.T "clear"}}</a>
+      {{end}}
+      {{if .ShowRemoved}}
+        <a class="button secondary" href="{{$.Config.Prefix}}/">{{$.T "hide_removed"}}</a>
+      {{else}}
+        <a class="button secondary" href="{{$.Config.Prefix}}/?show_removed=true">{{$.T "show_removed"}}</a>
+      {{end}}
+      <a class="button secondary" href="{{$.Config.Prefix}}/export.csv">{{$.T "export_csv"}}</a>
+      {{if and .Admin .Config.Webhook.URLs}}
+        {{if .ShowWebhooks}}
+          <a class="button secondary" href="{{$.Config.Prefix}}/">{{$.T "hide_webhooks"}}</a>
+        {{else}}
+          <a class="button secondary" href="{{$.Config.Prefix}}/?show_webhooks=true">{{$.T "show_webhooks"}}</a>
+        {{end}}
+      {{end}}
+    </form>
+
+    {{if .ShowWebhooks}}
+      <h3>{{$.T "webhook_deliveries"}}</h3>
+      <form class="inline" action="{{$.Config.Prefix}}/webhooks/test" method="POST">
+        {{ $.CsrfTemplate }}
+        <button class="secondary">{{$.T "test_webhooks"}}</button>
+      </form>
+      <table>
+        <thead>
+          <th>{{$.T "time"}}</th>
+          <th>{{$.T "url"}}</th>
+          <th>{{$.T "event"}}</th>
+          <th>{{$.T "status"}}</th>
+          <th>{{$.T "latency"}}</th>
+          <th>{{$.T "error"}}</th>
+        </thead>
+        <tbody>
+        {{range .Deliveries}}
+          <tr>
+            <td data-label="{{$.T "time"}}">{{lastLive .Time.Unix}}{{if gt .Attempt 1}} (#{{.Attempt}}){{end}}</td>
+            <td data-label="{{$.T "url"}}">{{.URL}}</td>
+            <td data-label="{{$.T "event"}}">{{.Event}}</td>
+            <td data-label="{{$.T "status"}}">{{if .Status}}{{.Status}}{{else}}-{{end}}</td>
+            <td data-label="{{$.T "latency"}}">{{.Latency.Round 1000000}}</td>
+            <td data-label="{{$.T "error"}}">{{.Error}}</td>
+          </tr>
+        {{else}}
+          <tr><td colspan="6">{{$.T "no_deliveries"}}</td></tr>
+        {{end}}
+        </tbody>
+      </table>
+    {{end}}
+
+    {{if .ShowRemoved}}
+      <h3>{{$.T "removed_streams"}}</h3>
+      <table>
+        <thead>
+          <th>{{$.T "name"}}</th>
+          <th data-label="Removed">{{$.T "removed"}}</th>
+          <th data-label="Notes">{{$.T "notes"}}</th>
+          <th></th>
+        </thead>
+        <tbody>
+        {{range .Removed}}
+          <tr>
+            <td data-label="Name">{{.Application}}/{{.Name}}</td>
+            <td data-label="Removed">{{lastLive .Removed}}</td>
+            <td data-label="Notes">{{.Notes}}</td>
+            <td style="text-align:right;">
+              <form class="inline" action="{{$.Config.Prefix}}/restore" method="POST">
+                {{ $.CsrfTemplate }}
+                <input type="hidden" name="id" value="{{.Id}}">
+                <button class="secondary">{{$.T "undo"}}</button>
+              </form>
+              <form class="inline" action="{{$.Config.Prefix}}/remove" method="POST">
+                {{ $.CsrfTemplate }}
+                <input type="hidden" name="id" value="{{.Id}}">
+                <input type="hidden" name="force" value="true">
+                <button class="secondary">{{$.T "delete_permanently"}}</button>
+              </form>
+            </td>
+          </tr>
+        {{else}}
+          <tr><td colspan="4">{{$.T "no_removed_streams"}}</td></tr>
+        {{end}}
+        </tbody>
+      </table>
+      <h3>{{$.T "streams"}}</h3>
+    {{end}}
+
+    <table{{if .Config.LiveUpdates}} data-live="{{.Config.Prefix}}/ws"{{end}}>
       <thead>
-        <th>Name</th>
-        <th data-label="Auth">Auth</th>
-        <th data-label="Blocked">Blocked</th>
-        <th>Expires</th>
-        <th data-label="Notes">Notes</th>
+        {{with .Sort}}
+          <th>
+            <a href="{{$.Config.Prefix}}/{{.URL "application"}}">{{$.T "application"}}{{.Indicator "application"}}</a>/<a href="{{$.Config.Prefix}}/{{.URL "name"}}">{{$.T "name"}}{{.Indicator "name"}}</a>
+            <a href="{{$.Config.Prefix}}/{{.URL "active"}}">{{$.T "live"}}{{.Indicator "active"}}</a>
+          </th>
+        {{else}}
+          <th>{{$.T "name"}}</th>
+        {{end}}
+        <th data-label="Auth">{{$.T "auth"}}</th>
+        <th data-label="Blocked">{{$.T "blocked"}}</th>
+        <th data-label="Disabled">{{$.T "disabled"}}</th>
+        {{with .Sort}}
+          <th><a href="{{$.Config.Prefix}}/{{.URL "expiry"}}">{{$.T "expires"}}{{.Indicator "expiry"}}</a></th>
+        {{else}}
+          <th>{{$.T "expires"}}</th>
+        {{end}}
+        <th data-label="Last live">{{$.T "last_live"}}</th>
+        {{if anyBitrate .State.Streams}}
+          <th data-label="Max bitrate">{{$.T "max_bitrate"}}</th>
+        {{end}}
+        {{if and .Admin .Config.Admins}}
+          <th data-label="Owner">{{$.T "owner"}}</th>
+        {{end}}
+        <th data-label="Notes">{{$.T "notes"}}</th>
         <th></th>
       </thead>
       <tbody>
       {{range .State.Streams}}
-        <tr>
+        <tr data-stream="{{.Id}}">
           <td data-label="Name">
             {{.Application}}/{{.Name}}
-            {{if .Active}}
-              <mark class="tag">live</mark>
+            <mark class="tag liveTag" title="active / allowed publishers" data-text="{{$.T "live_tag"}}"{{if not .Active}} hidden{{end}}>{{$.T "live_tag"}} {{activePublishers .}}/{{maxPublishers .}}</mark>
+            <mark class="tag disabledTag" title="disabled administratively, publish and play are rejected"{{if not .Disabled}} hidden{{end}}>{{$.T "disabled_tag"}}</mark>
+            {{range .Tags}}
+              <a href="{{$.Config.Prefix}}/{{$.Filter.TagURL .}}" title="{{$.T "filter_by_tag"}}"><mark class="tag {{tagClass .}}">{{.}}</mark></a>
+            {{end}}
+            {{$stream := .}}
+            {{with windowState .}}
+              <mark class="tag{{if ne . "live window"}} secondary{{end}}" title="{{windowRange $stream}}">{{.}}</mark>
+            {{end}}
+            {{if .MaxSessionDuration}}
+              <mark class="tag secondary" title="session length / maximum{{if .BlockAfterSession}}, blocked afterwards{{end}}">
+                session {{if .SessionStarted}}{{sessionElapsed .SessionStarted}}{{else}}-{{end}}/{{sessionCap .MaxSessionDuration}}
+              </mark>
+            {{end}}
+            {{range .ActiveNames}}
+              <mark class="tag tertiary">{{.}}</mark>
+            {{end}}
+            {{if contains $.Config.OpenApplications .Application}}
+              <mark class="tag inverse" title="keys aren't checked in open applications">{{$.T "open_tag"}}</mark>
+            {{end}}
+            {{if .AllowedCountries}}
+              <mark class="tag secondary" title="{{$.T "allowed_countries"}}: {{join .AllowedCountries}}">{{$.T "country_tag"}}</mark>
+            {{end}}
+            {{if or .AllowedIps .DeniedIps}}
+              <mark class="tag secondary" title="allowed: {{join .AllowedIps}} denied: {{join .DeniedIps}}">{{$.T "ip_restricted_tag"}}</mark>
+            {{end}}
+            {{with index $.PublishURLs .Id}}
+              <div class="authKeyRow publishURL{{if .Unavailable}} unavailable{{end}}">
+                <input class="authKey" size="20" value="{{.URL}}" readonly/><button class="secondary copyToClipboard inputAddon">{{$.T "copy"}}</button>
+              </div>
+              {{if .Unavailable}}
+                <mark class="tag secondary">{{$.T "publish_unavailable"}}</mark>
+              {{else if .Hashed}}
+                <mark class="tag inverse">{{$.T "key_hashed_append"}}</mark>
+              {{end}}
             {{end}}
           </td>
           <td data-label="Auth">
-            <input class="authKey" size="5" value="{{.AuthKey}}" readonly/><button class="secondary copyToClipboard inputAddon">Copy</button>
+            {{$stream := .}}
+            <small>{{$.T "publish_keys"}}</small>
+            {{range $index, $key := streamKeys .}}
+              <div class="authKeyRow">
+                {{if hashedKey $key}}
+                  <mark class="tag secondary">{{$.T "hashed"}}</mark>
+                {{else}}
+                  <input class="authKey" size="5" value="{{$key}}" readonly/><button class="secondary copyToClipboard inputAddon">{{$.T "copy"}}</button>
+                {{end}}
+                <form class="inline" action="{{$.Config.Prefix}}/key/remove" method="POST">
+                  {{ $.CsrfTemplate }}
+                  <input type="hidden" name="id" value="{{$stream.Id}}">
+                  <input type="hidden" name="index" value="{{$index}}">
+                  <button class="secondary">{{$.T "remove"}}</button>
+                </form>
+              </div>
+            {{end}}
+            <form class="inline" action="{{$.Config.Prefix}}/key/add" method="POST">
+              {{ $.CsrfTemplate }}
+              <input type="hidden" name="id" value="{{.Id}}">
+              <input type="text" size="5" name="auth_key" placeholder="{{$.T "new_key"}}"><button class="secondary inputAddon">{{$.T "add_key"}}</button>
+            </form>
+            <form class="inline" action="{{$.Config.Prefix}}/key/regenerate" method="POST">
+              {{ $.CsrfTemplate }}
+              <input type="hidden" name="id" value="{{.Id}}">
+              <button class="secondary">{{$.T "regenerate"}}</button>
+            </form>
+            <small>{{$.T "play_key_short"}}</small>
+            {{if not .PlayKey}}
+              <mark class="tag secondary" title="play checks the publish keys, or is open with open-play">{{$.T "none"}}</mark>
+            {{else if hashedKey .PlayKey}}
+              <mark class="tag secondary">{{$.T "hashed"}}</mark>
+            {{else}}
+              <div class="authKeyRow">
+                <input class="authKey" size="5" value="{{.PlayKey}}" readonly/><button class="secondary copyToClipboard inputAddon">{{$.T "copy"}}</button>
+              </div>
+            {{end}}
           </td>
           <td data-label="Blocked">
-            <form class="inline" action="{{$.Config.Prefix}}/block" method="POST" novalidate>
+            <form class="inline blockForm" action="{{$.Config.Prefix}}/block" method="POST" novalidate>
               {{ $.CsrfTemplate }}
               <input type="hidden" name="id" value="{{.Id}}">
               <input type="hidden" name="blocked" value="{{.Blocked}}">
               <input type="checkbox" oninput="this.form.submit();"{{if eq .Blocked true}} checked{{end}}>
             </form>
           </td>
-          <td data-label="Expire" data-expire="{{.AuthExpire}}">
+          <td data-label="Disabled">
+            <form class="inline disableForm" action="{{$.Config.Prefix}}/disable" method="POST" novalidate>
+              {{ $.CsrfTemplate }}
+              <input type="hidden" name="id" value="{{.Id}}">
+              <input type="hidden" name="disabled" value="{{.Disabled}}">
+              <input type="checkbox" oninput="this.form.submit();"{{if .Disabled}} checked{{end}}>
+            </form>
+          </td>
+          <td data-label="Expire" data-expire="{{.AuthExpire}}"{{if expired .AuthExpire}} class="expired"{{end}}>
             {{if eq .AuthExpire -1}}
-              never
+              {{$.T "never"}}
             {{else}}
-              {{.AuthExpire}}
+              <time datetime="{{expiryValue .AuthExpire}}">{{expiryTime .AuthExpire}}</time>
+              <small class="expiryHint">{{expiresIn .AuthExpire}}</small>
             {{end}}
           </td>
+          <td data-label="Last live" title="{{.PublishCount}} publishes">{{lastLive .LastActive}}</td>
+          {{if anyBitrate $.State.Streams}}
+            <td data-label="Max bitrate">{{if .MaxBitrateKbps}}{{.MaxBitrateKbps}} kbps{{else}}-{{end}}</td>
+          {{end}}
+          {{if and $.Admin $.Config.Admins}}
+            <td data-label="Owner">{{.Owner}}</td>
+          {{end}}
           <td data-label="Notes">{{.Notes}}</td>
           <td style="text-align:right;">
+            {{if and $.Config.Kick.URL .Active (not .Blocked)}}
+            <form class="inline" action="{{$.Config.Prefix}}/block" method="POST">
+              {{ $.CsrfTemplate }}
+              <input type="hidden" name="id" value="{{.Id}}">
+              <input type="hidden" name="blocked" value="false">
+              <input type="hidden" name="force" value="true">
+              <button class="secondary" title="{{$.T "kick_help"}}">{{$.T "block_kick"}}</button>
+            </form>
+            {{end}}
+            <a class="button secondary" href="{{$.Config.Prefix}}/history?id={{.Id}}">{{$.T "history"}}</a>
+            <a class="button secondary" href="{{$.Config.Prefix}}/edit?id={{.Id}}">{{$.T "edit"}}</a>
             <form class="inline" action="{{$.Config.Prefix}}/remove" method="POST">
               {{ $.CsrfTemplate }}
               <input type="hidden" name="id" value="{{.Id}}">
-              <button class="secondary">Remove</button>
+              <button class="secondary">{{$.T "remove"}}</button>
             </form>
           </td>
         </tr>
@@ -88,47 +571,241 @@ var templates = template.Must(template.New("form.html").Parse(
       </tbody>
     </table>
 
-    <h2>Add Stream</h2>
+    {{with .Pagination}}
+      {{if gt .Pages 1}}
+        <div class="pagination">
+          {{if .HasPrev}}
+            <a class="button secondary" href="{{$.Config.Prefix}}/{{.URL (sub .Page 1)}}">{{$.T "previous"}}</a>
+          {{end}}
+          <span>{{printf ($.T "page_of") .Page .Pages .Total}}</span>
+          {{if .HasNext}}
+            <a class="button secondary" href="{{$.Config.Prefix}}/{{.URL (add .Page 1)}}">{{$.T "next"}}</a>
+          {{end}}
+        </div>
+      {{end}}
+    {{end}}
+
+    {{with .History}}
+      <h3>{{$.T "history_of"}} {{.Application}}/{{.Name}}</h3>
+      <table>
+        <thead>
+          <th>{{$.T "time"}}</th>
+          <th>{{$.T "event"}}</th>
+          <th>{{$.T "name"}}</th>
+          <th>{{$.T "ip"}}</th>
+        </thead>
+        <tbody>
+        {{range $.HistoryEvents}}
+          <tr>
+            <td data-label="{{$.T "time"}}" title="{{lastLive .Time}}">{{eventTime .Time}}</td>
+            <td data-label="{{$.T "event"}}">{{.Action}}</td>
+            <td data-label="{{$.T "name"}}">{{.Name}}</td>
+            <td data-label="{{$.T "ip"}}">{{if .Ip}}{{.Ip}}{{else}}-{{end}}</td>
+          </tr>
+        {{else}}
+          <tr><td colspan="4">{{$.T "no_history"}}</td></tr>
+        {{end}}
+        </tbody>
+      </table>
+      <a class="button secondary" href="{{$.Config.Prefix}}/">{{$.T "close_history"}}</a>
+    {{end}}
+
+    {{if .Edit}}
+    <h2>{{$.T "edit_stream"}}</h2>
+    <form class="addForm" action="{{$.Config.Prefix}}/update" method="POST" novalidate>
+      <input type="hidden" name="id" value="{{.Edit.Id}}">
+    {{else}}
+    <h2>{{$.T "add_stream"}}</h2>
     <form class="addForm" action="{{$.Config.Prefix}}/add" method="POST" novalidate>
+    {{end}}
       <div class="row">
         <div class="col-sm-12 col-md-6">
-          <label for="application">Application</label>
-          <select type="text" id="application" name="application">
-            {{range $.Config.Applications}}
+          <label for="application">{{$.T "application"}}</label>
+          {{if $.Config.Applications}}
+            <select type="text" id="application" name="application">
+              {{if and $.Config.Presets (not $.Edit)}}
+                <option value="">{{$.T "preset_application"}}</option>
+              {{end}}
+              {{range $.Config.Applications}}
+                <option value="{{.}}"{{if and $.Edit (eq $.Edit.Application .)}} selected{{end}}>{{.}}{{if contains $.Config.OpenApplications .}} ({{$.T "open_tag"}}){{end}}</option>
+              {{end}}
+            </select>
+          {{else}}
+            <input type="text" id="application" name="application" placeholder="{{$.T "application"}}" required{{with $.Edit}} value="{{.Application}}"{{end}}>
+          {{end}}
+        </div>
+
+        {{if and $.Config.Presets (not .Edit)}}
+        <div class="col-sm-12">
+          <label for="preset">{{$.T "preset"}}
+            <span class="tooltip" aria-label="{{$.T "preset_help"}}">
+              <span class="icon-help"></span>
+            </span>
+          </label>
+          <select id="preset" name="preset">
+            <option value="">{{$.T "none"}}</option>
+            {{range $.Presets}}
               <option value="{{.}}">{{.}}</option>
             {{end}}
           </select>
         </div>
+        {{end}}
+
+        <div class="col-sm-12 col-md-6">
+          <label for="stream">{{$.T "stream"}}</label>
+          <input type="text" size="5" id="stream" name="name" placeholder="{{$.T "enter_name"}}" value="{{with .Edit}}{{.Name}}{{end}}">
+        </div>
+
+        <div class="col-sm-12 col-md-6">
+          <label for="authKey">{{$.T "publish_key"}}</label>
+          <input type="text" size="3" id="authKey" name="auth_key" placeholder="{{if .Edit}}{{$.T "keep_current_keys"}}{{else}}{{$.T "random_key"}}{{end}}"><button class="secondary generateKey inputAddon">{{$.T "generate_key"}}</button>
+          {{with $.Config.KeyPrefixes}}
+            <datalist id="keyPrefixes">
+              {{range $app, $prefix := .}}<option value="{{$app}}" data-prefix="{{$prefix}}">{{end}}
+            </datalist>
+          {{end}}
+        </div>
+
+        <div class="col-sm-12 col-md-6">
+          <label for="playKey">{{$.T "play_key"}}
+            <span class="tooltip" aria-label="{{$.T "play_key_help"}}">
+              <span class="icon-help"></span>
+            </span>
+          </label>
+          <input type="text" size="3" id="playKey" name="play_key" placeholder="{{if and .Edit .Edit.PlayKey}}{{$.T "keep_current_key"}}{{else}}{{$.T "publish_keys_default"}}{{end}}">
+          {{if and .Edit .Edit.PlayKey}}
+            <input type="checkbox" id="removePlayKey" name="remove_play_key" value="true">
+            <label for="removePlayKey">{{$.T "remove_play_key"}}</label>
+          {{end}}
+        </div>
+
+        <div class="col-sm-12 col-md-6">
+          <label for="authExpire">{{$.T "auth_expire"}}
+            <span class="tooltip" aria-label="{{$.T "auth_expire_help"}}">
+              <span class="icon-help"></span>
+            </span>
+          </label>
+          {{$absolute := and .Edit (ne .Edit.AuthExpire -1)}}
+          <div class="expireMode">
+            <input type="radio" id="expireRelative" name="expire_mode" value="relative"{{if not .Edit}} checked{{end}}>
+            <label for="expireRelative">{{$.T "expire_relative"}}</label>
+            <input type="radio" id="expireAbsolute" name="expire_mode" value="absolute"{{if $absolute}} checked{{end}}>
+            <label for="expireAbsolute">{{$.T "expire_absolute"}}</label>
+            <input type="radio" id="expireNever" name="expire_mode" value="never"{{if and .Edit (not $absolute)}} checked{{end}}>
+            <label for="expireNever">{{$.T "never"}}</label>
+          </div>
+          <input type="text" size="5" id="authExpire" name="auth_expire" placeholder="{{$.T "expire_default"}}">
+          <input type="datetime-local" id="authExpireAt" name="auth_expire_at"{{with .Edit}}{{if ne .AuthExpire -1}} value="{{expiryLocal .AuthExpire}}" data-expire="{{.AuthExpire}}"{{end}}{{end}}>
+          <input type="hidden" id="tzOffset" name="tz_offset">
+        </div>
+
+        <div class="col-sm-12 col-md-6">
+          <label for="allowedIPs">{{$.T "allowed_ips"}}
+            <span class="tooltip" aria-label="{{$.T "allowed_ips_help"}}">
+              <span class="icon-help"></span>
+            </span>
+          </label>
+          <input type="text" size="5" id="allowedIPs" name="allowed_ips" placeholder="{{$.T "any"}}" value="{{with .Edit}}{{join .AllowedIps}}{{end}}">
+        </div>
 
         <div class="col-sm-12 col-md-6">
-          <label for="stream">Stream</label>
-          <input type="text" size="5" id="stream" name="name" placeholder="enter name">
+          <label for="deniedIPs">{{$.T "denied_ips"}}
+            <span class="tooltip" aria-label="{{$.T "denied_ips_help"}}">
+              <span class="icon-help"></span>
+            </span>
+          </label>
+          <input type="text" size="5" id="deniedIPs" name="denied_ips" placeholder="{{$.T "none"}}" value="{{with .Edit}}{{join .DeniedIps}}{{end}}">
         </div>
 
         <div class="col-sm-12 col-md-6">
-          <label for="authKey">Auth Key</label>
-          <input type="text" size="3" id="authKey" name="auth_key" placeholder="no auth"><button class="secondary generateKey inputAddon">Generate key</button>
+          <label for="allowedCountries">{{$.T "allowed_countries"}}
+            <span class="tooltip" aria-label="{{$.T "countries_help"}}">
+              <span class="icon-help"></span>
+            </span>
+          </label>
+          <input type="text" size="5" id="allowedCountries" name="allowed_countries" placeholder="{{$.T "any"}}" value="{{with .Edit}}{{join .AllowedCountries}}{{end}}">
         </div>
 
         <div class="col-sm-12 col-md-6">
-          <label for="authExpire">Auth Expire
-            <span class="tooltip" aria-label="ISO8601 Duration (e.g. P2DT10H) or empty for no expiry">
+          <label for="maxPublishers">{{$.T "max_publishers"}}
+            <span class="tooltip" aria-label="{{$.T "max_publishers_help"}}">
               <span class="icon-help"></span>
             </span>
           </label>
-          <input type="text" size="5" id="authExpire" name="auth_expire" placeholder="never">
+          <input type="number" min="1" size="5" id="maxPublishers" name="max_publishers" placeholder="1" value="{{with .Edit}}{{if .MaxPublishers}}{{.MaxPublishers}}{{end}}{{end}}">
+        </div>
+
+        <div class="col-sm-12 col-md-6">
+          <label for="maxBitrate">{{$.T "max_bitrate_kbps"}}
+            <span class="tooltip" aria-label="{{$.T "max_bitrate_help"}}">
+              <span class="icon-help"></span>
+            </span>
+          </label>
+          <input type="number" min="1" size="5" id="maxBitrate" name="max_bitrate_kbps" placeholder="{{$.T "unlimited"}}" value="{{with .Edit}}{{if .MaxBitrateKbps}}{{.MaxBitrateKbps}}{{end}}{{end}}">
+        </div>
+
+        <div class="col-sm-12 col-md-6">
+          <label for="maxSession">{{$.T "max_session"}}
+            <span class="tooltip" aria-label="{{$.T "max_session_help"}}">
+              <span class="icon-help"></span>
+            </span>
+          </label>
+          <input type="text" size="5" id="maxSession" name="max_session" placeholder="{{$.T "no_limit"}}" value="{{with .Edit}}{{sessionValue .MaxSessionDuration}}{{end}}">
+        </div>
+
+        <div class="col-sm-12 col-md-6">
+          <input type="checkbox" id="blockAfterSession" name="block_after_session" value="true"{{with .Edit}}{{if .BlockAfterSession}} checked{{end}}{{end}}>
+          <label for="blockAfterSession">{{$.T "block_after_session"}}</label>
+        </div>
+
+        <div class="col-sm-12 col-md-6">
+          <label for="activeFrom">{{$.T "active_from"}}
+            <span class="tooltip" aria-label="{{$.T "active_from_help"}}">
+              <span class="icon-help"></span>
+            </span>
+          </label>
+          <input type="text" size="5" id="activeFrom" name="active_from" placeholder="{{$.T "no_start"}}" value="{{with .Edit}}{{windowValue .ActiveFrom}}{{end}}">
+        </div>
+
+        <div class="col-sm-12 col-md-6">
+          <label for="activeUntil">{{$.T "active_until"}}</label>
+          <input type="text" size="5" id="activeUntil" name="active_until" placeholder="{{$.T "no_end"}}" value="{{with .Edit}}{{windowValue .ActiveUntil}}{{end}}">
+        </div>
+
+        <div class="col-sm-12">
+          <label for="tags">{{$.T "tags"}}</label>
+          <input type="text" size="5" id="tags" name="tags" placeholder="{{$.T "tags_placeholder"}}" value="{{with .Edit}}{{join .Tags}}{{end}}">
+        </div>
+
+        <div class="col-sm-12">
+          <label for="notes">{{$.T "notes"}}</label>
+          <input type="text" size="5" id="notes" name="notes" placeholder="{{$.T "notes_placeholder"}}" value="{{with .Edit}}{{.Notes}}{{end}}">
+        </div>
+
+        {{if and .Admin .Config.Admins}}
+        <div class="col-sm-12">
+          <label for="owner">{{$.T "owner"}}</label>
+          <select id="owner" name="owner">
+            <option value="">{{$.T "yourself"}}</option>
+            {{range $user, $_ := $.Config.Users}}
+              <option value="{{$user}}"{{with $.Edit}}{{if eq .Owner $user}} selected{{end}}{{end}}>{{$user}}</option>
+            {{end}}
+          </select>
         </div>
+        {{end}}
 
+        {{if not .Edit}}
         <div class="col-sm-12">
-          <label for="notes">Notes</label>
-          <input type="text" size="5" id="notes" name="notes" placeholder="optional notes">
+          <input type="checkbox" id="overwrite" name="overwrite" value="true">
+          <label for="overwrite">{{$.T "overwrite"}}</label>
         </div>
+        {{end}}
       </div>
 
       <div class="row">
         {{ .CsrfTemplate }}
         <div class="col-sm-12 col-md-12">
-          <button class="primary">Submit</button>
+          <button class="primary">{{$.T "submit"}}</button>
         </div>
       </div>
     </form>
@@ -136,3 +813,80 @@ var templates = template.Must(template.New("form.html").Parse(
 <script src="{{.Config.Prefix}}/public/main.js"></script>
 </body>
 </html>`))
+
+var _ = template.Must(templates.New("login.html").Parse(
+	`<!DOCTYPE html>
+<html lang="{{.Text.Locale}}">
+<head>
+  <meta charset="UTF-8">
+  <title>{{$.T "login_title"}}</title>
+  <meta name="viewport" content="width=device-width, initial-scale=1">
+  <link rel="stylesheet" type="text/css" href="{{.Config.Prefix}}/public/mini-dark.css">
+  <link rel="stylesheet" type="text/css" href="{{.Config.Prefix}}/public/main.css">
+</head>
+<body>
+  <div class="container">
+    <h1>rtmp-auth</h1>
+
+    <div class="row">
+      {{range .Errors}}
+        <div class="card error">
+          <div class="section">
+            <h3>{{$.T "error"}}</h3>
+            <p>{{.Error}}</p>
+          </div>
+        </div>
+      {{end}}
+    </div>
+
+    <h2>{{$.T "login"}}</h2>
+    <form action="{{.Config.Prefix}}/login" method="POST">
+      <div class="row">
+        <div class="col-sm-12 col-md-6">
+          <label for="user">{{$.T "user"}}</label>
+          <input type="text" id="user" name="user" autocomplete="username" autofocus>
+        </div>
+        <div class="col-sm-12 col-md-6">
+          <label for="password">{{$.T "password"}}</label>
+          <input type="password" id="password" name="password" autocomplete="current-password">
+        </div>
+      </div>
+      <div class="row">
+        {{ .CsrfTemplate }}
+        <div class="col-sm-12 col-md-12">
+          <button class="primary">{{$.T "login"}}</button>
+        </div>
+      </div>
+    </form>
+  </div>
+</body>
+</html>`))
+
+// loadTemplateDir replaces the embedded templates with the *.html files of dir, e.g. a form.html
+// with another layout. Files are parsed under their file name, templates they don't override stay
+// embedded and defines in them replace those of the same name, so partials can be overridden as well
+func loadTemplateDir(dir string) error {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.html"))
+	if err != nil {
+		return err
+	}
+	if len(paths) == 0 {
+		return fmt.Errorf("template-dir %s: no *.html files", dir)
+	}
+	overridden, err := templates.Clone()
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		text, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("template-dir: %w", err)
+		}
+		if _, err := overridden.New(filepath.Base(path)).Parse(string(text)); err != nil {
+			return fmt.Errorf("template-dir: %w", err)
+		}
+		log.Println("Using template", path)
+	}
+	templates = overridden
+	return nil
+}
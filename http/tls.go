@@ -0,0 +1,137 @@
+package http
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type TLSConfig struct {
+	// CertFile and KeyFile enable HTTPS, plain HTTP is served if empty
+	CertFile string `toml:"cert-file"`
+	KeyFile  string `toml:"key-file"`
+	// ReloadInterval is how often the files are checked for changes
+	ReloadInterval time.Duration `toml:"reload-interval"`
+}
+
+// certReloader serves the current certificate and replaces it when the files change on disk,
+// so renewals don't need a restart
+type certReloader struct {
+	certFile string
+	keyFile  string
+	cert     atomic.Pointer[tls.Certificate]
+	modTime  time.Time
+	stop     chan struct{}
+	done     sync.WaitGroup
+}
+
+// newCertReloader loads the certificate, returns nil if TLS is not configured
+func newCertReloader(config TLSConfig) (*certReloader, error) {
+	if config.CertFile == "" && config.KeyFile == "" {
+		return nil, nil
+	}
+	if config.CertFile == "" || config.KeyFile == "" {
+		return nil, fmt.Errorf("tls needs both cert-file and key-file")
+	}
+	reloader := &certReloader{
+		certFile: config.CertFile,
+		keyFile:  config.KeyFile,
+		stop:     make(chan struct{}),
+	}
+	if err := reloader.reload(); err != nil {
+		return nil, err
+	}
+
+	interval := config.ReloadInterval
+	if interval == 0 {
+		interval = time.Minute
+	}
+	reloader.done.Add(1)
+	go reloader.watch(interval)
+	return reloader, nil
+}
+
+// lastModified returns the latest modification time of the cert and key file
+func (reloader *certReloader) lastModified() (time.Time, error) {
+	var latest time.Time
+	for _, path := range []string{reloader.certFile, reloader.keyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return latest, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+func (reloader *certReloader) reload() error {
+	modTime, err := reloader.lastModified()
+	if err != nil {
+		return fmt.Errorf("tls: %w", err)
+	}
+	cert, err := tls.LoadX509KeyPair(reloader.certFile, reloader.keyFile)
+	if err != nil {
+		return fmt.Errorf("tls: %w", err)
+	}
+	reloader.cert.Store(&cert)
+	reloader.modTime = modTime
+	return nil
+}
+
+// watch polls the files and reloads changed certificates, the old one is kept on errors
+func (reloader *certReloader) watch(interval time.Duration) {
+	defer reloader.done.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-reloader.stop:
+			return
+		case <-ticker.C:
+			modTime, err := reloader.lastModified()
+			if err != nil {
+				log.Println("tls:", err)
+				continue
+			}
+			if modTime.Equal(reloader.modTime) {
+				continue
+			}
+			if err := reloader.reload(); err != nil {
+				log.Println(err)
+				continue
+			}
+			log.Println("tls: reloaded certificate", reloader.certFile)
+		}
+	}
+}
+
+func (reloader *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return reloader.cert.Load(), nil
+}
+
+func (reloader *certReloader) Stop() {
+	if reloader == nil {
+		return
+	}
+	close(reloader.stop)
+	reloader.done.Wait()
+}
+
+// listen serves HTTPS if a certificate is configured and plain HTTP otherwise
+func listen(server *http.Server, certs *certReloader) error {
+	if certs == nil {
+		return server.ListenAndServe()
+	}
+	server.TLSConfig = &tls.Config{
+		GetCertificate: certs.GetCertificate,
+		MinVersion:     tls.VersionTLS12,
+	}
+	return server.ListenAndServeTLS("", "")
+}
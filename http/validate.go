@@ -0,0 +1,202 @@
+package http
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/voc/rtmp-auth/storage"
+	"github.com/voc/rtmp-auth/store"
+)
+
+// defaultNamePattern is the default rule for stream and application names,
+// characters rtmp servers and players reliably pass through urls
+const defaultNamePattern = `^[A-Za-z0-9_-]+$`
+
+func namePattern(config ServerConfig) string {
+	if config.NamePattern != "" {
+		return config.NamePattern
+	}
+	return defaultNamePattern
+}
+
+func applicationPattern(config ServerConfig) string {
+	if config.ApplicationPattern != "" {
+		return config.ApplicationPattern
+	}
+	return defaultNamePattern
+}
+
+// checkPatterns verifies the configured name patterns compile
+func checkPatterns(config ServerConfig) error {
+	if _, err := regexp.Compile(namePattern(config)); err != nil {
+		return fmt.Errorf("invalid name-pattern: %w", err)
+	}
+	if _, err := regexp.Compile(applicationPattern(config)); err != nil {
+		return fmt.Errorf("invalid application-pattern: %w", err)
+	}
+	return nil
+}
+
+// validateName matches value against pattern, the error lists the offending characters if possible
+func validateName(field string, value string, pattern string) error {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return fmt.Errorf("invalid %s pattern: %w", field, err)
+	}
+	if re.MatchString(value) {
+		return nil
+	}
+
+	var offending []string
+	seen := make(map[rune]bool)
+	for _, r := range value {
+		if !seen[r] && !re.MatchString(string(r)) {
+			seen[r] = true
+			offending = append(offending, fmt.Sprintf("%q", r))
+		}
+	}
+	if len(offending) == 0 {
+		return fmt.Errorf("%s '%v' doesn't match %v", field, value, pattern)
+	}
+	return fmt.Errorf("%s '%v' contains invalid characters: %v", field, value, strings.Join(offending, ", "))
+}
+
+// validateKey checks a publish or play key can be passed in an rtmp url query, the key itself isn't part of the error
+func validateKey(field string, key string) error {
+	for _, r := range key {
+		if unicode.IsSpace(r) || unicode.IsControl(r) {
+			return fmt.Errorf("%s must not contain whitespace or control characters", field)
+		}
+	}
+	return nil
+}
+
+// KeyPolicy is the minimum strength of the publish and play keys operators enter, random keys always pass
+type KeyPolicy struct {
+	// MinLength is the minimum number of characters, 8 by default. 1 accepts any key
+	MinLength int `toml:"min-length"`
+	// MinClasses is the number of character classes a key has to mix, out of lowercase and uppercase letters,
+	// digits and other characters
+	MinClasses int `toml:"min-classes"`
+}
+
+// defaultKeyLength is the minimum key length if none is configured
+const defaultKeyLength = 8
+
+// checkKeyStrength rejects keys violating the policy, the key itself isn't part of the error
+func checkKeyStrength(policy KeyPolicy, field string, key string) error {
+	minLength := policy.MinLength
+	if minLength == 0 {
+		minLength = defaultKeyLength
+	}
+	if n := len([]rune(key)); n < minLength {
+		return fmt.Errorf("%s is too weak, it has %d characters instead of at least %d", field, n, minLength)
+	}
+	var lower, upper, digit, other int
+	for _, r := range key {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			other = 1
+		}
+	}
+	if classes := lower + upper + digit + other; classes < policy.MinClasses {
+		return fmt.Errorf("%s is too weak, it mixes %d instead of %d of lowercase, uppercase, digits and other characters",
+			field, classes, policy.MinClasses)
+	}
+	return nil
+}
+
+// checkKeyPolicy verifies the key policy can be met
+func checkKeyPolicy(config ServerConfig) error {
+	if config.KeyPolicy.MinLength < 0 {
+		return fmt.Errorf("key-policy: min-length must not be negative")
+	}
+	if config.KeyPolicy.MinClasses < 0 || config.KeyPolicy.MinClasses > 4 {
+		return fmt.Errorf("key-policy: min-classes must be between 0 and 4")
+	}
+	return nil
+}
+
+// checkKeyPrefix rejects keys of app without its configured prefix, other applications accept any key
+func checkKeyPrefix(config ServerConfig, app string, field string, key string) error {
+	if prefix := config.KeyPrefixes[app]; prefix != "" && !strings.HasPrefix(key, prefix) {
+		return fmt.Errorf("%s must start with %q in application %v", field, prefix, app)
+	}
+	return nil
+}
+
+// checkKeyPrefixes verifies the key prefixes are usable in keys and belong to known applications
+func checkKeyPrefixes(config ServerConfig) error {
+	for app, prefix := range config.KeyPrefixes {
+		if len(config.Applications) > 0 && !slices.Contains(config.Applications, app) {
+			return fmt.Errorf("key-prefixes: unknown application %v", app)
+		}
+		if err := validateKey("key-prefixes: prefix of "+app, prefix); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateStreamName checks a stream name, wildcard characters of patterns are allowed in addition
+func validateStreamName(name string, pattern string) error {
+	if !store.IsPattern(name) {
+		return validateName("stream name", name, pattern)
+	}
+	literal := strings.Map(func(r rune) rune {
+		if strings.ContainsRune("*?[]!^", r) {
+			return -1
+		}
+		return r
+	}, name)
+	if literal == "" {
+		return nil
+	}
+	if err := validateName("stream name", literal, pattern); err != nil {
+		return fmt.Errorf("%w in pattern '%v'", err, name)
+	}
+	return nil
+}
+
+// nameCollision returns a stream other than id whose application and name only differ by case,
+// they can't be told apart by operators and some rtmp servers
+func nameCollision(streams []*storage.Stream, stream *storage.Stream, id string) *storage.Stream {
+	for _, other := range streams {
+		if other.Id == id {
+			continue
+		}
+		if other.Application == stream.Application && other.Name == stream.Name {
+			continue
+		}
+		if strings.EqualFold(other.Application, stream.Application) && strings.EqualFold(other.Name, stream.Name) {
+			return other
+		}
+	}
+	return nil
+}
+
+// checkCollision returns an error if stream collides with an existing one, id is excluded as the stream itself
+func checkCollision(store *store.Store, stream *storage.Stream, id string) error {
+	state, err := store.Get()
+	if err != nil {
+		return err
+	}
+	if other := nameCollision(state.Streams, stream, id); other != nil {
+		return collisionError(stream, other)
+	}
+	return nil
+}
+
+func collisionError(stream *storage.Stream, other *storage.Stream) error {
+	return fmt.Errorf("stream %v/%v collides with existing stream %v/%v",
+		stream.Application, stream.Name, other.Application, other.Name)
+}
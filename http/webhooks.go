@@ -0,0 +1,42 @@
+package http
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/voc/rtmp-auth/audit"
+	"github.com/voc/rtmp-auth/webhook"
+)
+
+// WebhookTestHandler sends a test event to the webhooks and shows the delivery log with the results on top
+func WebhookTestHandler(hooks *webhook.Notifier, config ServerConfig, auditLog *audit.Log) handleFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		config := requestConfig(r, config)
+		for _, delivery := range hooks.Test() {
+			slog.Info("webhook test", "url", delivery.URL, "status", delivery.Status,
+				"latency", delivery.Latency, "error", delivery.Error)
+		}
+		auditLog.Record(audit.Entry{Action: "test webhooks", User: requestUser(r)})
+		http.Redirect(w, r, config.Prefix+"/?show_webhooks=true", http.StatusSeeOther)
+	}
+}
+
+// APIWebhookTestHandler sends a test event to the webhooks and returns the attempts
+func APIWebhookTestHandler(hooks *webhook.Notifier, auditLog *audit.Log) handleFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		deliveries := hooks.Test()
+		auditLog.Record(audit.Entry{Action: "test webhooks", User: requestUser(r)})
+		writeJSON(w, http.StatusOK, deliveries)
+	}
+}
+
+// WebhookDeliveriesHandler returns the recent delivery attempts, newest first
+func WebhookDeliveriesHandler(hooks *webhook.Notifier) handleFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		deliveries := hooks.Deliveries()
+		if deliveries == nil {
+			deliveries = []webhook.Delivery{}
+		}
+		writeJSON(w, http.StatusOK, deliveries)
+	}
+}
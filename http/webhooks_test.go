@@ -0,0 +1,58 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/voc/rtmp-auth/webhook"
+)
+
+func TestWebhookTestHandlers(t *testing.T) {
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer hook.Close()
+	hooks := webhook.NewNotifier(webhook.Config{URLs: []string{hook.URL + "/?token=secret"}})
+
+	w := httptest.NewRecorder()
+	WebhookTestHandler(hooks, ServerConfig{Prefix: "/admin"}, nil)(w, httptest.NewRequest(http.MethodPost, "/admin/webhooks/test", nil))
+	if location := w.Header().Get("Location"); w.Code != http.StatusSeeOther || location != "/admin/?show_webhooks=true" {
+		t.Errorf("test button answered %d to %q, want 303 to the delivery log", w.Code, location)
+	}
+
+	w = httptest.NewRecorder()
+	APIWebhookTestHandler(hooks, nil)(w, httptest.NewRequest(http.MethodPost, "/api/webhooks/test", nil))
+	var deliveries []map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &deliveries); err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 1 || deliveries[0]["status"] != float64(http.StatusOK) || deliveries[0]["url"] != hook.URL+"/?token=REDACTED" {
+		t.Errorf("api test returned %s, want one redacted successful delivery", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	WebhookDeliveriesHandler(hooks)(w, httptest.NewRequest(http.MethodGet, "/api/webhooks/deliveries", nil))
+	if err := json.Unmarshal(w.Body.Bytes(), &deliveries); err != nil || len(deliveries) != 2 {
+		t.Errorf("delivery log returned %s, want both tests", w.Body.String())
+	}
+
+	// The form lists the log with the test button
+	config := ServerConfig{Webhook: webhook.Config{URLs: []string{hook.URL + "/?token=secret"}}}
+	w = httptest.NewRecorder()
+	FormHandler(newTestStore(t), config, hooks)(w, httptest.NewRequest(http.MethodGet, "/?show_webhooks=true", nil))
+	body := w.Body.String()
+	if !strings.Contains(body, `action="/webhooks/test"`) || !strings.Contains(body, hook.URL+"/?token=REDACTED") {
+		t.Error("form doesn't show the delivery log")
+	}
+	if strings.Contains(body, "token=secret") {
+		t.Error("form shows the webhook secret")
+	}
+
+	// Without webhooks the log is an empty list
+	w = httptest.NewRecorder()
+	WebhookDeliveriesHandler(nil)(w, httptest.NewRequest(http.MethodGet, "/api/webhooks/deliveries", nil))
+	if body := w.Body.String(); body != "[]\n" {
+		t.Errorf("delivery log without webhooks returned %q, want []", body)
+	}
+}
@@ -0,0 +1,345 @@
+package http
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/voc/rtmp-auth/store"
+)
+
+const (
+	wsGUID         = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	wsPingInterval = 30 * time.Second
+	wsWriteTimeout = 10 * time.Second
+
+	wsOpText  = 0x1
+	wsOpClose = 0x8
+	wsOpPing  = 0x9
+)
+
+// liveUpdate is sent to the web-ui when the active, blocked or disabled state of a stream changes
+type liveUpdate struct {
+	Id            string `json:"id"`
+	Action        string `json:"action"`
+	Active        bool   `json:"active"`
+	Blocked       bool   `json:"blocked"`
+	Disabled      bool   `json:"disabled"`
+	Publishers    int32  `json:"publishers"`
+	MaxPublishers int32  `json:"max_publishers"`
+}
+
+// liveMessage is an encoded liveUpdate with the owner of its stream, clients only receive updates of streams they see
+type liveMessage struct {
+	owner string
+	data  []byte
+}
+
+// liveActions are the events the web-ui is updated on
+var liveActions = map[string]bool{
+	store.EventPublish:   true,
+	store.EventUnpublish: true,
+	store.EventBlock:     true,
+	store.EventUnblock:   true,
+	store.EventDisable:   true,
+	store.EventEnable:    true,
+}
+
+// broadcaster fans out stream events to the connected web-ui clients.
+// Events are queued by notify and resolved against the store in a separate goroutine,
+// as subscribers are called while the store is locked
+type broadcaster struct {
+	store   *store.Store
+	events  chan store.Event
+	mutex   sync.Mutex
+	clients map[chan liveMessage]bool
+	stop    chan struct{}
+	done    chan struct{}
+	closed  bool
+}
+
+func newBroadcaster(s *store.Store) *broadcaster {
+	b := &broadcaster{
+		store:   s,
+		events:  make(chan store.Event, 64),
+		clients: make(map[chan liveMessage]bool),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go b.run()
+	return b
+}
+
+// notify queues an event without blocking, events are dropped if the queue is full
+func (b *broadcaster) notify(event store.Event) {
+	if !liveActions[event.Action] {
+		return
+	}
+	select {
+	case b.events <- event:
+	default:
+		log.Println("live updates: dropping event for", event.Id)
+	}
+}
+
+func (b *broadcaster) run() {
+	defer close(b.done)
+	for {
+		select {
+		case <-b.stop:
+			return
+		case event := <-b.events:
+			msg, ok := b.update(event)
+			if ok {
+				b.send(msg)
+			}
+		}
+	}
+}
+
+// update resolves the current state of the stream an event is about
+func (b *broadcaster) update(event store.Event) (liveMessage, bool) {
+	if event.Id == "" {
+		return liveMessage{}, false
+	}
+	state, err := b.store.Get()
+	if err != nil {
+		log.Println("live updates:", err)
+		return liveMessage{}, false
+	}
+	for _, stream := range state.Streams {
+		if stream.Id != event.Id {
+			continue
+		}
+		msg, err := json.Marshal(liveUpdate{
+			Id:            stream.Id,
+			Action:        event.Action,
+			Active:        stream.Active,
+			Blocked:       stream.Blocked,
+			Disabled:      stream.Disabled,
+			Publishers:    store.ActivePublishers(stream),
+			MaxPublishers: store.MaxPublishers(stream),
+		})
+		if err != nil {
+			log.Println("live updates:", err)
+			return liveMessage{}, false
+		}
+		return liveMessage{owner: stream.Owner, data: msg}, true
+	}
+	return liveMessage{}, false
+}
+
+// send passes msg to all clients, clients which can't keep up are disconnected
+func (b *broadcaster) send(msg liveMessage) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for client := range b.clients {
+		select {
+		case client <- msg:
+		default:
+			delete(b.clients, client)
+			close(client)
+		}
+	}
+}
+
+// add registers a client, the channel is closed when the client is dropped or the broadcaster is closed
+func (b *broadcaster) add() (chan liveMessage, bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.closed {
+		return nil, false
+	}
+	client := make(chan liveMessage, 16)
+	b.clients[client] = true
+	return client, true
+}
+
+func (b *broadcaster) remove(client chan liveMessage) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.clients[client] {
+		delete(b.clients, client)
+		close(client)
+	}
+}
+
+// Close stops the broadcaster and disconnects all clients
+func (b *broadcaster) Close() {
+	if b == nil {
+		return
+	}
+	b.mutex.Lock()
+	if b.closed {
+		b.mutex.Unlock()
+		return
+	}
+	b.closed = true
+	for client := range b.clients {
+		delete(b.clients, client)
+		close(client)
+	}
+	b.mutex.Unlock()
+	close(b.stop)
+	<-b.done
+}
+
+// LiveHandler upgrades the request to a websocket and sends a liveUpdate per change of a stream the user may see
+func LiveHandler(live *broadcaster, config ServerConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		conn, rw, err := wsUpgrade(w, r)
+		if err != nil {
+			log.Println("live updates:", err)
+			return
+		}
+		defer conn.Close()
+
+		client, ok := live.add()
+		if !ok {
+			wsWriteFrame(conn, wsOpClose, nil)
+			return
+		}
+		defer live.remove(client)
+		admin, user := isAdmin(config, r), requestUser(r)
+
+		gone := make(chan struct{})
+		go wsReadLoop(conn, rw.Reader, gone)
+
+		ticker := time.NewTicker(wsPingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case msg, ok := <-client:
+				if !ok {
+					wsWriteFrame(conn, wsOpClose, nil)
+					return
+				}
+				if !admin && msg.owner != user {
+					continue
+				}
+				if err := wsWriteFrame(conn, wsOpText, msg.data); err != nil {
+					return
+				}
+			case <-ticker.C:
+				if err := wsWriteFrame(conn, wsOpPing, nil); err != nil {
+					return
+				}
+			case <-gone:
+				wsWriteFrame(conn, wsOpClose, nil)
+				return
+			}
+		}
+	}
+}
+
+// wsUpgrade performs the websocket handshake (RFC 6455) and hijacks the connection.
+// Cross origin requests are rejected, as browsers send the session cookie along
+func wsUpgrade(w http.ResponseWriter, r *http.Request) (net.Conn, *bufio.ReadWriter, error) {
+	if !headerContains(r.Header, "Connection", "upgrade") || !headerContains(r.Header, "Upgrade", "websocket") {
+		http.Error(w, "Expected websocket upgrade", http.StatusBadRequest)
+		return nil, nil, errors.New("not a websocket upgrade")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "Unsupported websocket version", http.StatusBadRequest)
+		return nil, nil, errors.New("unsupported websocket version")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		http.Error(w, "Missing Sec-WebSocket-Key", http.StatusBadRequest)
+		return nil, nil, errors.New("missing websocket key")
+	}
+	if origin := r.Header.Get("Origin"); origin != "" {
+		u, err := url.Parse(origin)
+		if err != nil || !strings.EqualFold(u.Host, r.Host) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return nil, nil, fmt.Errorf("origin %s not allowed", origin)
+		}
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "Websockets not supported", http.StatusInternalServerError)
+		return nil, nil, errors.New("connection can't be hijacked")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	// the server read and write timeouts still apply to the hijacked connection
+	conn.SetDeadline(time.Time{})
+
+	sum := sha1.Sum([]byte(key + wsGUID))
+	accept := base64.StdEncoding.EncodeToString(sum[:])
+	conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	_, err = fmt.Fprintf(conn, "HTTP/1.1 101 Switching Protocols\r\n"+
+		"Upgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n", accept)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	return conn, rw, nil
+}
+
+func headerContains(header http.Header, name string, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// wsWriteFrame writes a single unmasked server frame
+func wsWriteFrame(conn net.Conn, opcode byte, payload []byte) error {
+	header := []byte{0x80 | opcode, 0}
+	switch n := len(payload); {
+	case n < 126:
+		header[1] = byte(n)
+	case n <= 0xFFFF:
+		header[1] = 126
+		header = binary.BigEndian.AppendUint16(header, uint16(n))
+	default:
+		header[1] = 127
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+	conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	_, err := conn.Write(append(header, payload...))
+	return err
+}
+
+// wsReadLoop discards client frames until the client closes the connection or sends anything unexpected,
+// then closes gone. Clients only send pongs and close frames, so longer frames end the connection.
+// Pings from the client aren't answered, browsers don't send them
+func wsReadLoop(conn net.Conn, reader *bufio.Reader, gone chan struct{}) {
+	defer close(gone)
+	header := make([]byte, 2)
+	for {
+		if _, err := io.ReadFull(reader, header); err != nil {
+			return
+		}
+		opcode := header[0] & 0x0F
+		masked := header[1]&0x80 != 0
+		length := int(header[1] & 0x7F)
+		if !masked || length > 125 || opcode == wsOpClose {
+			return
+		}
+		// mask and payload
+		if _, err := reader.Discard(4 + length); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package mail
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/voc/rtmp-auth/store"
+)
+
+type Config struct {
+	Host string `toml:"host"`
+	// Port defaults to 587, STARTTLS is used if the server offers it
+	Port     int      `toml:"port"`
+	From     string   `toml:"from"`
+	To       []string `toml:"to"`
+	Username string   `toml:"username"`
+	Password string   `toml:"password" json:"-"`
+	// Events are the event actions mailed, like expiring, auto_block, publish or unpublish. Expiring and auto_block by default
+	Events  []string      `toml:"events"`
+	Timeout time.Duration `toml:"timeout"`
+}
+
+// Notifier mails stream events to the configured recipients
+type Notifier struct {
+	config Config
+	addr   string
+	events map[string]bool
+	queue  chan store.Event
+}
+
+// queueSize is the number of events waiting for delivery, further events are dropped
+const queueSize = 100
+
+func NewNotifier(config Config) (*Notifier, error) {
+	if config.Host == "" || config.From == "" || len(config.To) == 0 {
+		return nil, fmt.Errorf("mail: host, from and to are required")
+	}
+	if config.Port == 0 {
+		config.Port = 587
+	}
+	if config.Timeout == 0 {
+		config.Timeout = 10 * time.Second
+	}
+	events := config.Events
+	if len(events) == 0 {
+		events = []string{store.EventExpiring, store.EventAutoBlock}
+	}
+	n := &Notifier{
+		config: config,
+		addr:   net.JoinHostPort(config.Host, strconv.Itoa(config.Port)),
+		events: make(map[string]bool, len(events)),
+		queue:  make(chan store.Event, queueSize),
+	}
+	for _, event := range events {
+		n.events[event] = true
+	}
+	go n.run()
+	return n, nil
+}
+
+// Notify queues the event for mailing if its action is selected, it never blocks
+func (n *Notifier) Notify(event store.Event) {
+	if !n.events[event.Action] {
+		return
+	}
+	select {
+	case n.queue <- event:
+	default:
+		log.Printf("mail: queue full, dropping %s event of %s/%s\n", event.Action, event.Application, event.Name)
+	}
+}
+
+// run sends the queued events one at a time
+func (n *Notifier) run() {
+	for event := range n.queue {
+		msg, err := n.message(event)
+		if err != nil {
+			log.Println("mail: build message", err)
+			continue
+		}
+		if err := n.send(msg); err != nil {
+			log.Printf("mail: delivery of %s event of %s/%s failed: %s\n", event.Action, event.Application, event.Name, err)
+		}
+	}
+}
+
+// message formats the mail for event, the body ends with the same JSON the webhooks receive
+func (n *Notifier) message(event store.Event) ([]byte, error) {
+	payload, err := json.MarshalIndent(event, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	timestamp := time.Unix(event.Timestamp, 0).UTC().Format(time.RFC1123Z)
+	var summary string
+	switch event.Action {
+	case store.EventPublish:
+		summary = fmt.Sprintf("%s/%s went live at %s.", event.Application, event.Name, timestamp)
+	case store.EventUnpublish:
+		summary = fmt.Sprintf("%s/%s stopped publishing at %s.", event.Application, event.Name, timestamp)
+	case store.EventAutoBlock:
+		summary = fmt.Sprintf("%s/%s was blocked at %s after %d wrong keys, its key may have leaked. Unblock it once the key is changed.",
+			event.Application, event.Name, timestamp, event.Failures)
+	case store.EventExpiring:
+		summary = fmt.Sprintf("The key of %s/%s expires at %s.", event.Application, event.Name,
+			time.Unix(event.AuthExpire, 0).UTC().Format(time.RFC1123Z))
+	default:
+		summary = fmt.Sprintf("%s/%s: %s at %s.", event.Application, event.Name, event.Action, timestamp)
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", n.config.From)
+	fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(n.config.To, ", "))
+	fmt.Fprintf(&buf, "Subject: [rtmp-auth] %s/%s %s\r\n", event.Application, event.Name, event.Action)
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n")
+	buf.WriteString(summary + "\r\n\r\n")
+	buf.WriteString(strings.ReplaceAll(string(payload), "\n", "\r\n") + "\r\n")
+	return buf.Bytes(), nil
+}
+
+// send delivers msg to all recipients, the whole conversation is bounded by the timeout
+func (n *Notifier) send(msg []byte) error {
+	conn, err := net.DialTimeout("tcp", n.addr, n.config.Timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(n.config.Timeout))
+
+	client, err := smtp.NewClient(conn, n.config.Host)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: n.config.Host}); err != nil {
+			return err
+		}
+	}
+	if n.config.Username != "" {
+		// PlainAuth refuses to send the password without TLS unless the server is local
+		auth := smtp.PlainAuth("", n.config.Username, n.config.Password, n.config.Host)
+		if err := client.Auth(auth); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(n.config.From); err != nil {
+		return err
+	}
+	for _, to := range n.config.To {
+		if err := client.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
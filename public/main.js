@@ -20,8 +20,11 @@
     event.preventDefault();
 
     const values = encode64(crypto.getRandomValues(new Uint8Array(12)));
-    const field = document.querySelector("input[name='auth_key']");
-    field.value = values;
+    // Applications with a key prefix only accept keys starting with it
+    const app = document.querySelector("#application").value;
+    const prefix = document.querySelector(`#keyPrefixes option[value="${CSS.escape(app)}"]`);
+    const field = document.querySelector("#authKey");
+    field.value = (prefix ? prefix.dataset.prefix : "") + values;
   });
 
   document.querySelectorAll(".copyToClipboard").forEach(
@@ -33,26 +36,22 @@
     document.execCommand("copy");
   }))
 
-  // Formats a future date to a human readable duration
-  const toHumanDuration = (date) => {
-    const units = ["seconds", "minutes", "hours", "days", "weeks"];
-    const steps = [60, 60, 24, 7];
-    let index = 0;
-    let val = date - Date.now()/1000;
-
-    if (val <= 0)
-      return "now";
-
-    while(val > 2*steps[index]) {
-      val /= steps[index++];
+  // Formats a duration in seconds with its two largest units, e.g. "3d 4h"
+  const toHumanDuration = (seconds) => {
+    const units = [["d", 86400], ["h", 3600], ["m", 60], ["s", 1]];
+    const parts = [];
+    for (const [suffix, length] of units) {
+      const n = Math.floor(seconds / length);
+      if (n > 0 || (parts.length == 0 && suffix == "s")) {
+        parts.push(`${n}${suffix}`);
+        seconds -= n * length;
+      } else if (parts.length > 0) {
+        break;
+      }
+      if (parts.length == 2)
+        break;
     }
-
-    if (val < 5)
-      val = Math.round(val*5)/5
-    else
-      val = Math.round(val)
-
-    return `in ${val} ${units[index]}`
+    return parts.join(" ");
   }
 
   // Augment expire timestamps
@@ -63,10 +62,83 @@
         return;
 
       const expires = parseInt(expiry);
-      if (!isNaN(expires))
-        field.textContent = toHumanDuration(expires);
+      if (isNaN(expires))
+        return;
+
+      const time = field.querySelector("time");
+      if (time)
+        time.textContent = new Date(expires*1000).toLocaleString(document.documentElement.lang,
+          {dateStyle: "medium", timeStyle: "short"});
+
+      const hint = field.querySelector(".expiryHint");
+      if (!hint)
+        return;
+      const remaining = Math.floor(expires - Date.now()/1000);
+      if (remaining < 0) {
+        hint.textContent = `expired ${toHumanDuration(-remaining)} ago`;
+        field.classList.add("expired");
+      } else {
+        hint.textContent = `in ${toHumanDuration(remaining)}`;
+      }
     });
   }
   setInterval(updateTimestamps, 5000)
   updateTimestamps();
+
+  // Show the expiry input of the chosen mode, absolute times are sent with the zone of the browser
+  const expireMode = document.querySelector(".expireMode");
+  if (expireMode) {
+    const relative = document.querySelector("#authExpire");
+    const absolute = document.querySelector("#authExpireAt");
+    document.querySelector("#tzOffset").value = new Date().getTimezoneOffset();
+    if (absolute.dataset.expire) {
+      const local = new Date(parseInt(absolute.dataset.expire) * 1000);
+      local.setMinutes(local.getMinutes() - local.getTimezoneOffset());
+      absolute.value = local.toISOString().slice(0, 16);
+    }
+    const showMode = () => {
+      const mode = expireMode.querySelector("input:checked");
+      relative.hidden = !mode || mode.value != "relative";
+      absolute.hidden = !mode || mode.value != "absolute";
+    }
+    expireMode.addEventListener("change", showMode);
+    showMode();
+  }
+
+  // Live updates of active and blocked state, the table only has data-live if enabled
+  const liveTable = document.querySelector("table[data-live]");
+  const applyUpdate = (update) => {
+    const row = document.querySelector(`tr[data-stream="${CSS.escape(update.id)}"]`);
+    if (!row)
+      return;
+
+    const tag = row.querySelector(".liveTag");
+    if (tag) {
+      tag.hidden = !update.active;
+      tag.textContent = `${tag.dataset.text} ${update.publishers}/${update.max_publishers}`;
+    }
+    const form = row.querySelector(".blockForm");
+    if (form) {
+      form.querySelector("input[name=blocked]").value = update.blocked;
+      form.querySelector("input[type=checkbox]").checked = update.blocked;
+    }
+    const disabledTag = row.querySelector(".disabledTag");
+    if (disabledTag)
+      disabledTag.hidden = !update.disabled;
+    const disableForm = row.querySelector(".disableForm");
+    if (disableForm) {
+      disableForm.querySelector("input[name=disabled]").value = update.disabled;
+      disableForm.querySelector("input[type=checkbox]").checked = update.disabled;
+    }
+  }
+  const connectLive = () => {
+    const url = new URL(liveTable.getAttribute("data-live"), window.location.href);
+    url.protocol = url.protocol == "https:" ? "wss:" : "ws:";
+    const socket = new WebSocket(url);
+    socket.addEventListener("message", (event) => applyUpdate(JSON.parse(event.data)));
+    socket.addEventListener("close", () => setTimeout(connectLive, 5000));
+  }
+  if (liveTable && window.WebSocket) {
+    connectLive();
+  }
 }());
\ No newline at end of file
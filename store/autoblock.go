@@ -0,0 +1,94 @@
+package store
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/voc/rtmp-auth/storage"
+)
+
+// autoBlocker counts failed publish keys per stream id within a sliding window
+type autoBlocker struct {
+	limit    int
+	window   time.Duration
+	mutex    sync.Mutex
+	failures map[string][]time.Time
+}
+
+// fail records a failed attempt for id and returns the failures within the window
+func (b *autoBlocker) fail(id string, now time.Time) int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	failures := b.failures[id]
+	start := 0
+	for start < len(failures) && now.Sub(failures[start]) >= b.window {
+		start++
+	}
+	failures = append(failures[start:], now)
+	b.failures[id] = failures
+
+	// Forget streams which stopped failing, so old ids don't pile up
+	for other, times := range b.failures {
+		if now.Sub(times[len(times)-1]) >= b.window {
+			delete(b.failures, other)
+		}
+	}
+	return len(failures)
+}
+
+// reset forgets the failures of id
+func (b *autoBlocker) reset(id string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	delete(b.failures, id)
+}
+
+// SetAutoBlock blocks streams which get limit wrong publish keys within window, as their key likely leaked
+// and is being guessed from many addresses. Blocked streams stay blocked until they are unblocked,
+// a matching key resets the count. A limit of 0 disables it
+func (store *Store) SetAutoBlock(limit int, window time.Duration) {
+	if limit <= 0 || window <= 0 {
+		store.autoBlock = nil
+		return
+	}
+	store.autoBlock = &autoBlocker{
+		limit:    limit,
+		window:   window,
+		failures: make(map[string][]time.Time),
+	}
+}
+
+// recordBadKey counts a wrong key against streams and blocks those reaching the limit
+func (store *Store) recordBadKey(streams []*storage.Stream) {
+	if store.autoBlock == nil {
+		return
+	}
+	now := time.Now()
+	for _, stream := range streams {
+		failures := store.autoBlock.fail(stream.Id, now)
+		if stream.Blocked || failures < store.autoBlock.limit {
+			continue
+		}
+		log.Printf("Auto-blocking %s/%s after %d wrong keys within %s\n",
+			stream.Application, stream.Name, failures, store.autoBlock.window)
+		if err := store.SetBlocked(stream.Id, true); err != nil {
+			log.Println("auto-block:", err)
+			continue
+		}
+		store.emitEvent(Event{
+			Id:          stream.Id,
+			Application: stream.Application,
+			Name:        stream.Name,
+			Action:      EventAutoBlock,
+			Failures:    failures,
+		})
+	}
+}
+
+// resetBadKeys clears the count of id after a matching key or an unblock
+func (store *Store) resetBadKeys(id string) {
+	if store.autoBlock != nil {
+		store.autoBlock.reset(id)
+	}
+}
@@ -1,10 +1,33 @@
 package store
 
 import (
+	"context"
+
 	"github.com/voc/rtmp-auth/storage"
 )
 
+// Backend persists the state. Implementations must be safe for concurrent use, Read returns
+// a copy the caller may change and Write must not keep a reference to the state it was passed
 type Backend interface {
 	Read() (*storage.State, error)
 	Write(state *storage.State) error
 }
+
+// ContextBackend is implemented by backends whose reads and writes can be cancelled.
+// The auth path uses it, so a slow backend can't stall the rtmp server's callbacks
+type ContextBackend interface {
+	ReadContext(ctx context.Context) (*storage.State, error)
+	WriteContext(ctx context.Context, state *storage.State) error
+}
+
+// Checker is implemented by backends able to verify that their storage is usable
+type Checker interface {
+	Check() error
+}
+
+// Updater is implemented by backends shared between instances. UpdateContext reads the state, passes it to
+// update and writes the changes in one transaction, so changes of other instances in between aren't lost.
+// Nothing is written if update fails
+type Updater interface {
+	UpdateContext(ctx context.Context, update func(state *storage.State) error) error
+}
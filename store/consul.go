@@ -89,11 +89,15 @@ func (cb *ConsulBackend) handleWatch(b watch.BlockingParamVal, update interface{
 	if pair == nil {
 		return
 	}
+	// Parse into a new state, so a bad value doesn't clobber the cache
+	var state storage.State
+	if err := proto.Unmarshal(pair.Value, &state); err != nil {
+		log.Println("watch: failed to parse state:", err)
+		return
+	}
 	cb.mutex.Lock()
 	defer cb.mutex.Unlock()
-	if err := proto.Unmarshal(pair.Value, cb.cache); err != nil {
-		log.Println("watch: failed to parse state: %w", err)
-	}
+	cb.cache = &state
 	cb.lastIndex = pair.ModifyIndex
 }
 
@@ -110,9 +114,11 @@ func (cb *ConsulBackend) read() (*storage.State, error) {
 	}
 
 	if pair != nil {
-		if err := proto.Unmarshal(pair.Value, cb.cache); err != nil {
+		var state storage.State
+		if err := proto.Unmarshal(pair.Value, &state); err != nil {
 			return cb.getCache(), fmt.Errorf("failed to parse state: %w", err)
 		}
+		cb.cache = &state
 		cb.lastIndex = pair.ModifyIndex
 	}
 
@@ -133,7 +139,10 @@ func (cb *ConsulBackend) cachedRead() (*storage.State, error) {
 
 // Read from backend
 func (cb *ConsulBackend) Read() (*storage.State, error) {
-	if cb.lastIndex != 0 {
+	cb.mutex.RLock()
+	cached := cb.lastIndex != 0
+	cb.mutex.RUnlock()
+	if cached {
 		return cb.cachedRead()
 	}
 	return cb.read()
@@ -166,8 +175,21 @@ func (cb *ConsulBackend) Write(state *storage.State) error {
 	if !success {
 		return errors.New("state changed during request, please try again")
 	}
-	// update directly so cached reads can return a correct response
-	cb.cache = state
+	// update directly so cached reads can return a correct response,
+	// the caller may still hold and change state
+	cb.cache = proto.Clone(state).(*storage.State)
 
 	return nil
 }
+
+// Check verifies that the consul cluster has a leader
+func (cb *ConsulBackend) Check() error {
+	leader, err := cb.client.Status().Leader()
+	if err != nil {
+		return err
+	}
+	if leader == "" {
+		return errors.New("no consul leader")
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package store
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/voc/rtmp-auth/storage"
+)
+
+// newFakeConsul returns a consul backend talking to a server which keeps the stream_auth key in memory
+func newFakeConsul(t *testing.T) *ConsulBackend {
+	t.Helper()
+	var mutex sync.Mutex
+	var value []byte
+	var index uint64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if r.URL.Path != "/v1/kv/stream_auth" {
+			http.NotFound(w, r)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			if value == nil {
+				http.NotFound(w, r)
+				return
+			}
+			json.NewEncoder(w).Encode([]api.KVPair{{Key: "stream_auth", Value: value, ModifyIndex: index}})
+		case http.MethodPut:
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			value = body
+			index++
+			w.Write([]byte("true"))
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	client, err := api.NewClient(&api.Config{Address: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A known index serves reads from the cache, which is what the watch keeps up to date
+	return &ConsulBackend{client: client, kv: client.KV(), cache: &storage.State{}, lastIndex: 1}
+}
+
+// Changing a state read from or written to a backend doesn't change the state it returns next
+func TestBackendStateIsCopied(t *testing.T) {
+	file, err := NewFileBackend(FileBackendConfig{Path: filepath.Join(t.TempDir(), "store.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	backends := map[string]Backend{
+		"file":   file,
+		"consul": newFakeConsul(t),
+	}
+	for name, backend := range backends {
+		t.Run(name, func(t *testing.T) {
+			written := &storage.State{Streams: []*storage.Stream{{Id: "1", Application: "live", Name: "foo"}}}
+			if err := backend.Write(written); err != nil {
+				t.Fatal(err)
+			}
+			written.Streams[0].Name = "written"
+			written.Streams = append(written.Streams, &storage.Stream{Id: "2"})
+
+			read, err := backend.Read()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(read.Streams) != 1 || read.Streams[0].Name != "foo" {
+				t.Fatalf("read after changing the written state = %v, want stream foo only", read.Streams)
+			}
+			read.Streams[0].Name = "read"
+			read.Streams[0].Blocked = true
+
+			again, err := backend.Read()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if again.Streams[0].Name != "foo" || again.Streams[0].Blocked {
+				t.Fatalf("read after changing a read state = %v, want unchanged stream foo", again.Streams[0])
+			}
+		})
+	}
+}
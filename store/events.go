@@ -0,0 +1,74 @@
+package store
+
+import (
+	"sync"
+	"time"
+)
+
+// Event describes a state change of a stream
+type Event struct {
+	Id          string `json:"id"`
+	Application string `json:"application"`
+	Name        string `json:"name"`
+	Action      string `json:"action"`
+	Timestamp   int64  `json:"timestamp"`
+	// AuthExpire is the expiry of the stream for EventExpiring
+	AuthExpire int64 `json:"auth_expire,omitempty"`
+	// Failures is the number of wrong keys which triggered EventAutoBlock
+	Failures int `json:"failures,omitempty"`
+}
+
+const (
+	EventPublish   = "publish"
+	EventUnpublish = "unpublish"
+	// EventExpiring is sent once when a stream is about to expire, see SetExpiryWarning
+	EventExpiring = "expiring"
+	// EventBlock and EventUnblock are sent when the blocked state of a stream changes
+	EventBlock   = "block"
+	EventUnblock = "unblock"
+	// EventDisable and EventEnable are sent when a stream is disabled or enabled again
+	EventDisable = "disable"
+	EventEnable  = "enable"
+	// EventAutoBlock is sent after EventBlock when a stream got too many wrong keys, see SetAutoBlock
+	EventAutoBlock = "auto_block"
+)
+
+func blockEvent(blocked bool) string {
+	if blocked {
+		return EventBlock
+	}
+	return EventUnblock
+}
+
+// subscribers holds the functions called on stream events
+type subscribers struct {
+	mutex sync.RWMutex
+	funcs []func(Event)
+}
+
+// Subscribe registers fn to be called for every stream event.
+// fn is called synchronously and must not block
+func (store *Store) Subscribe(fn func(Event)) {
+	store.subscribers.mutex.Lock()
+	defer store.subscribers.mutex.Unlock()
+	store.subscribers.funcs = append(store.subscribers.funcs, fn)
+}
+
+func (store *Store) emit(id string, app string, name string, action string) {
+	store.emitEvent(Event{
+		Id:          id,
+		Application: app,
+		Name:        name,
+		Action:      action,
+	})
+}
+
+// emitEvent sets the timestamp and passes event to all subscribers
+func (store *Store) emitEvent(event Event) {
+	event.Timestamp = time.Now().Unix()
+	store.subscribers.mutex.RLock()
+	defer store.subscribers.mutex.RUnlock()
+	for _, fn := range store.subscribers.funcs {
+		fn(event)
+	}
+}
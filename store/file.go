@@ -7,6 +7,7 @@ import (
 	"log"
 	"math/rand"
 	"os"
+	"path/filepath"
 	"sync"
 	"time"
 
@@ -16,24 +17,66 @@ import (
 
 type FileBackendConfig struct {
 	Path string
+	// Backups is the number of timestamped copies of the state kept on each save, none by default
+	Backups int `toml:"backups"`
+	// BackupDir defaults to the directory of the state file
+	BackupDir string `toml:"backup-dir"`
+	// Journal appends the changes of each write to path.journal instead of rewriting the state,
+	// which is compacted into a new snapshot every CompactRecords records, 1000 by default
+	Journal        bool `toml:"journal"`
+	CompactRecords int  `toml:"compact-records"`
 }
 
 // Applications: apps, Prefix: prefix
 type FileBackend struct {
-	path  string
-	cache *storage.State
-	mutex sync.RWMutex
+	path      string
+	backups   int
+	backupDir string
+	cache     *storage.State
+	mutex     sync.RWMutex
+	// journal is nil unless enabled, then backups are only made by compaction
+	journal        *journal
+	compactRecords int
 }
 
+// backupTimeFormat sorts backups by their name
+const backupTimeFormat = "20060102-150405.000000000"
+
 func NewFileBackend(config FileBackendConfig) (Backend, error) {
-	fb := &FileBackend{path: config.Path, cache: &storage.State{}}
+	fb := &FileBackend{
+		path:      config.Path,
+		backups:   config.Backups,
+		backupDir: backupDir(config),
+		cache:     &storage.State{},
+
+		compactRecords: config.CompactRecords,
+	}
+	if fb.compactRecords <= 0 {
+		fb.compactRecords = defaultCompactRecords
+	}
+	if fb.backups > 0 {
+		if err := os.MkdirAll(fb.backupDir, 0o700); err != nil {
+			return nil, fmt.Errorf("failed to create backup dir: %w", err)
+		}
+	}
 	state, err := fb.read()
 	if err != nil {
 		return nil, err
 	}
-	// persist state
-	fb.save(state)
 	fb.cache = state
+	if config.Journal {
+		// The replayed journal is folded into a new snapshot
+		if err := fb.compact(); err != nil {
+			return nil, err
+		}
+		return fb, nil
+	}
+	// persist state, a journal left from running with it enabled is part of it now
+	if err := fb.save(state); err == nil {
+		if err := os.Remove(journalPath(fb.path)); err != nil && !os.IsNotExist(err) {
+			log.Println("failed to remove journal:", err)
+		}
+	}
 	return fb, nil
 }
 
@@ -51,10 +94,21 @@ func (fb *FileBackend) read() (*storage.State, error) {
 			return nil, fmt.Errorf("failed to parse stream state: %w", err)
 		}
 	}
+	// Replayed even with the journal disabled, so its changes aren't lost when turning it off
+	records, err := replayJournal(journalPath(fb.path), &state)
+	if err != nil {
+		return nil, err
+	}
+	if records > 0 {
+		log.Printf("Replayed %d journal records\n", records)
+	}
 
 	// Clear active information for old streams
 	for _, stream := range state.Streams {
 		stream.Active = false
+		stream.ActiveNames = nil
+		stream.Publishers = nil
+		stream.SessionStarted = 0
 	}
 
 	// Generate secret
@@ -75,13 +129,39 @@ func (fb *FileBackend) save(state *storage.State) error {
 		return fmt.Errorf("failed to encode state: %w", err)
 	}
 	tmp := fmt.Sprintf(fb.path+".%v", time.Now())
-	if err := ioutil.WriteFile(tmp, out, 0o600); err != nil {
+	if err := writeFileSync(tmp, out); err != nil {
 		return fmt.Errorf("failed to write state: %w", err)
 	}
 	err = os.Rename(tmp, fb.path)
 	if err != nil {
 		return fmt.Errorf("failed to move state: %w", err)
 	}
+	if fb.backups > 0 {
+		// The state itself is saved, a failed backup must not fail the write
+		if err := fb.backup(out); err != nil {
+			log.Println("state backup failed:", err)
+		}
+	}
+	return nil
+}
+
+// backup writes the saved state to a new timestamped file and prunes the oldest ones beyond the configured count
+func (fb *FileBackend) backup(out []byte) error {
+	prefix := filepath.Join(fb.backupDir, filepath.Base(fb.path)+".backup.")
+	if err := writeFileSync(prefix+time.Now().UTC().Format(backupTimeFormat), out); err != nil {
+		return err
+	}
+	backups, err := filepath.Glob(prefix + "*")
+	if err != nil {
+		return err
+	}
+	// Glob returns the names sorted, the oldest first
+	for len(backups) > fb.backups {
+		if err := os.Remove(backups[0]); err != nil {
+			return err
+		}
+		backups = backups[1:]
+	}
 	return nil
 }
 
@@ -98,6 +178,93 @@ func (fb *FileBackend) Write(state *storage.State) error {
 	}
 	fb.mutex.Lock()
 	defer fb.mutex.Unlock()
-	fb.cache = state
+	if fb.journal != nil {
+		return fb.writeJournal(state)
+	}
+	// The caller may still hold and change state
+	fb.cache = proto.Clone(state).(*storage.State)
 	return fb.save(state)
 }
+
+// Close releases the journal, the state is written on each Write
+func (fb *FileBackend) Close() error {
+	fb.mutex.Lock()
+	defer fb.mutex.Unlock()
+	err := fb.journal.Close()
+	fb.journal = nil
+	return err
+}
+
+// Check verifies that the state directory is writable
+func (fb *FileBackend) Check() error {
+	tmp, err := os.CreateTemp(filepath.Dir(fb.path), filepath.Base(fb.path)+".check")
+	if err != nil {
+		return fmt.Errorf("state not writable: %w", err)
+	}
+	tmp.Close()
+	return os.Remove(tmp.Name())
+}
+
+// backupDir returns the directory backups of the state file are kept in
+func backupDir(config FileBackendConfig) string {
+	if config.BackupDir != "" {
+		return config.BackupDir
+	}
+	return filepath.Dir(config.Path)
+}
+
+// RestoreFileBackup replaces the state file with the backup at path. The backup has to parse,
+// the replaced state is kept as another backup if backups are enabled.
+// The server must not be running, it would overwrite the restored state
+func RestoreFileBackup(config FileBackendConfig, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read backup: %w", err)
+	}
+	var state storage.State
+	if err := proto.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("failed to parse backup: %w", err)
+	}
+	fb := &FileBackend{path: config.Path, backups: config.Backups, backupDir: backupDir(config)}
+	if current, err := os.ReadFile(config.Path); err == nil && fb.backups > 0 {
+		if err := os.MkdirAll(fb.backupDir, 0o700); err != nil {
+			return fmt.Errorf("failed to create backup dir: %w", err)
+		}
+		if err := fb.backup(current); err != nil {
+			return fmt.Errorf("failed to back up current state: %w", err)
+		}
+	}
+	tmp := config.Path + ".restore"
+	if err := writeFileSync(tmp, data); err != nil {
+		return fmt.Errorf("failed to write state: %w", err)
+	}
+	if err := os.Rename(tmp, config.Path); err != nil {
+		return fmt.Errorf("failed to move state: %w", err)
+	}
+	// The journal holds changes of the replaced state
+	if err := os.Remove(journalPath(config.Path)); err == nil {
+		log.Println("Discarded journal", journalPath(config.Path))
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove journal: %w", err)
+	}
+	log.Printf("State restored from backup %s to %s\n", path, config.Path)
+	return nil
+}
+
+// writeFileSync writes data to path and syncs it to disk,
+// so a crash after the following rename can't leave a partial state
+func writeFileSync(path string, data []byte) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
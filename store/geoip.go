@@ -0,0 +1,56 @@
+package store
+
+import (
+	"net"
+	"slices"
+	"sync"
+
+	"github.com/voc/rtmp-auth/storage"
+)
+
+// GeoIP resolves the ISO 3166-1 alpha-2 country code of an address like "DE", e.g. a geoip.DB
+type GeoIP interface {
+	Country(ip net.IP) (string, error)
+}
+
+// geoCacheSize bounds the cached lookups, the cache starts over once it's full
+const geoCacheSize = 10000
+
+// geoCache keeps the countries resolved per address, failed lookups as ""
+type geoCache struct {
+	geo       GeoIP
+	mutex     sync.Mutex
+	countries map[string]string
+}
+
+func (c *geoCache) country(addr string) string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if country, ok := c.countries[addr]; ok {
+		return country
+	}
+	var country string
+	if ip := net.ParseIP(addr); ip != nil {
+		country, _ = c.geo.Country(ip)
+	}
+	if len(c.countries) >= geoCacheSize {
+		c.countries = make(map[string]string)
+	}
+	c.countries[addr] = country
+	return country
+}
+
+// SetGeoIP enables the country restrictions of streams, they aren't checked without a lookup
+func (store *Store) SetGeoIP(geo GeoIP) {
+	store.geo = &geoCache{geo: geo, countries: make(map[string]string)}
+}
+
+// countryAllowed checks the publisher address against the stream's allowed countries and returns the
+// resolved country. Streams without countries aren't restricted, addresses without a known country are rejected
+func (store *Store) countryAllowed(stream *storage.Stream, addr string) (string, bool) {
+	if store.geo == nil || len(stream.AllowedCountries) == 0 {
+		return "", true
+	}
+	country := store.geo.country(addr)
+	return country, country != "" && slices.Contains(stream.AllowedCountries, country)
+}
@@ -0,0 +1,79 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/voc/rtmp-auth/storage"
+)
+
+// defaultHistoryLength is the number of connection events kept per stream if history-length isn't set
+const defaultHistoryLength = 50
+
+// Connection event actions besides EventPublish and EventUnpublish
+const (
+	// HistoryReconnect is a publish within the inactive grace period, it kept the slot of the dropped publisher
+	HistoryReconnect = "reconnect"
+	// HistoryTakeover is a publish which replaced an active publisher, see PolicyTakeover
+	HistoryTakeover = "takeover"
+)
+
+// newConnectionEvent returns an event of action by ip to name happening now
+func newConnectionEvent(action string, name string, ip string) *storage.ConnectionEvent {
+	return &storage.ConnectionEvent{Time: time.Now().Unix(), Action: action, Ip: ip, Name: name}
+}
+
+// recordEvent appends event to the history of stream and drops the oldest events beyond the history length
+func (store *Store) recordEvent(stream *storage.Stream, event *storage.ConnectionEvent) {
+	if store.historyLength <= 0 || event == nil {
+		return
+	}
+	stream.History = append(stream.History, event)
+	if excess := len(stream.History) - store.historyLength; excess > 0 {
+		stream.History = append([]*storage.ConnectionEvent(nil), stream.History[excess:]...)
+	}
+}
+
+// recordUnpublish adds an unpublish to the streams app/name is active for without changing their publishers,
+// for unpublishes which don't give up a slot. Expects the mutex to be held
+func (store *Store) recordUnpublish(ctx context.Context, app string, name string, event *storage.ConnectionEvent) {
+	if store.historyLength <= 0 {
+		return
+	}
+	err := store.updateContext(ctx, func(state *storage.State) error {
+		changed := false
+		for _, stream := range state.Streams {
+			if stream.Application == app && activeFor(stream, name) {
+				store.recordEvent(stream, event)
+				changed = true
+			}
+		}
+		if !changed {
+			return errUnchanged
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Recording unpublish of %s/%s failed: %v\n", app, name, err)
+	}
+}
+
+// History returns the connection events of the stream with id, removed streams included, newest first
+func (store *Store) History(id string) ([]*storage.ConnectionEvent, error) {
+	state, err := store.backend.Read()
+	if err != nil {
+		return nil, err
+	}
+	for _, stream := range state.Streams {
+		if stream.Id == id {
+			events := make([]*storage.ConnectionEvent, len(stream.History))
+			for i, event := range stream.History {
+				events[len(events)-1-i] = event
+			}
+			return events, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %v", ErrNotFound, id)
+}
@@ -0,0 +1,118 @@
+package store
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/voc/rtmp-auth/storage"
+)
+
+// Id schemes of new streams, existing streams keep their ids whatever the scheme
+const (
+	// IdSchemeUUID generates random UUIDs (version 4), the default
+	IdSchemeUUID = "uuid"
+	// IdSchemeSlug derives readable ids like "live-foo" from application and name
+	IdSchemeSlug = "slug"
+	// IdSchemeSequence numbers streams 1, 2, 3, ... from a counter kept in the state, ids aren't reused
+	IdSchemeSequence = "sequence"
+)
+
+// idGenerator returns the id of a new stream which isn't among the ids in use.
+// Generators may keep counters in state, which is written with the new stream
+type idGenerator func(state *storage.State, stream *storage.Stream, ids map[string]bool) (string, error)
+
+// newIdGenerator returns the generator of scheme
+func newIdGenerator(scheme string) (idGenerator, error) {
+	switch scheme {
+	case "", IdSchemeUUID:
+		return uuidId, nil
+	case IdSchemeSlug:
+		return slugId, nil
+	case IdSchemeSequence:
+		return sequenceId, nil
+	}
+	return nil, fmt.Errorf("unknown id-scheme %q, use uuid, slug or sequence", scheme)
+}
+
+func uuidId(state *storage.State, stream *storage.Stream, ids map[string]bool) (string, error) {
+	for {
+		id, err := uuid.NewRandom()
+		if err != nil {
+			return "", err
+		}
+		if !ids[id.String()] {
+			return id.String(), nil
+		}
+	}
+}
+
+// slugId lowercases application and name and replaces other characters than letters and digits by dashes,
+// e.g. "live-my-talk" for live/My Talk. Ids in use get a counter appended, "live-my-talk-2"
+func slugId(state *storage.State, stream *storage.Stream, ids map[string]bool) (string, error) {
+	slug := slugify(stream.Application + "-" + stream.Name)
+	if slug == "" {
+		slug = "stream"
+	}
+	id := slug
+	for i := 2; ids[id]; i++ {
+		id = slug + "-" + strconv.Itoa(i)
+	}
+	return id, nil
+}
+
+func slugify(value string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(value) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+		} else {
+			dash = true
+		}
+	}
+	return b.String()
+}
+
+// sequenceId returns the next number of the counter in state and advances it, so the ids of purged
+// streams aren't given out again. States without a counter continue after the highest numeric id in use
+func sequenceId(state *storage.State, stream *storage.Stream, ids map[string]bool) (string, error) {
+	next := state.NextId
+	if next == 0 {
+		next = 1
+		for id := range ids {
+			if n, err := strconv.ParseUint(id, 10, 64); err == nil && n >= next {
+				next = n + 1
+			}
+		}
+	}
+	// Imported streams may already use numbers ahead of the counter
+	for ids[strconv.FormatUint(next, 10)] {
+		next++
+	}
+	state.NextId = next + 1
+	return strconv.FormatUint(next, 10), nil
+}
+
+// assignIds gives each stream a new id unique among the streams of state, removed ones included,
+// and the other streams being added. Expects the mutex to be held and state to be written afterwards
+func (store *Store) assignIds(state *storage.State, streams ...*storage.Stream) error {
+	ids := make(map[string]bool, len(state.Streams)+len(streams))
+	for _, stream := range state.Streams {
+		ids[stream.Id] = true
+	}
+	for _, stream := range streams {
+		id, err := store.newId(state, stream, ids)
+		if err != nil {
+			return err
+		}
+		stream.Id = id
+		ids[id] = true
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package store
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/voc/rtmp-auth/storage"
+)
+
+func TestSequenceId(t *testing.T) {
+	tests := []struct {
+		next     uint64
+		ids      []string
+		want     string
+		wantNext uint64
+	}{
+		{0, nil, "1", 2},
+		// States written before the counter continue after the highest number
+		{0, []string{"3", "live-foo", "1"}, "4", 5},
+		{7, []string{"3"}, "7", 8},
+		// Ids of purged streams stay used up, imported ones ahead of the counter are skipped
+		{7, []string{"7", "8"}, "9", 10},
+	}
+	for _, test := range tests {
+		state := &storage.State{NextId: test.next}
+		ids := make(map[string]bool)
+		for _, id := range test.ids {
+			ids[id] = true
+		}
+		id, err := sequenceId(state, &storage.Stream{}, ids)
+		if err != nil || id != test.want || state.NextId != test.wantNext {
+			t.Errorf("sequenceId with counter %d and ids %v = %q, %v, counter %d, want %q, counter %d",
+				test.next, test.ids, id, err, state.NextId, test.want, test.wantNext)
+		}
+	}
+}
+
+// Ids of purged streams aren't given out again, also after a restart
+func TestSequenceIdsNotReused(t *testing.T) {
+	config := StoreConfig{
+		Backend:  "file",
+		File:     FileBackendConfig{Path: filepath.Join(t.TempDir(), "store.db"), Journal: true},
+		IdScheme: IdSchemeSequence,
+	}
+	store, err := NewStore(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	add := func(store *Store, name string) string {
+		t.Helper()
+		stream := &storage.Stream{Application: "live", Name: name, AuthKeys: []string{"abcdefgh1" + name}, AuthExpire: -1}
+		if err := store.AddStream(stream); err != nil {
+			t.Fatal(err)
+		}
+		return stream.Id
+	}
+	add(store, "a")
+	last := add(store, "b")
+	if err := store.RemoveStream(last); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.PurgeStream(last); err != nil {
+		t.Fatal(err)
+	}
+	if id := add(store, "c"); id != "3" {
+		t.Errorf("id after purging %s = %s, want 3", last, id)
+	}
+	store.Close()
+
+	store, err = NewStore(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if id := add(store, "d"); id != "4" {
+		t.Errorf("id after restarting = %s, want 4", id)
+	}
+}
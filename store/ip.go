@@ -0,0 +1,59 @@
+package store
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/voc/rtmp-auth/storage"
+)
+
+// ParseNetwork parses a CIDR or a single address and returns it in CIDR notation
+func ParseNetwork(value string) (string, bool) {
+	if !strings.Contains(value, "/") {
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return "", false
+		}
+		bits := 128
+		if ip.To4() != nil {
+			bits = 32
+		}
+		value = fmt.Sprintf("%s/%d", ip, bits)
+	}
+	_, network, err := net.ParseCIDR(value)
+	if err != nil {
+		return "", false
+	}
+	return network.String(), true
+}
+
+// inNetworks reports whether ip is contained in any of the networks
+func inNetworks(networks []string, ip net.IP) bool {
+	for _, value := range networks {
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			continue
+		}
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ipAllowed checks the client address against the stream's allow- and denylist.
+// Empty lists don't restrict, an unknown address only passes if there are no restrictions
+func ipAllowed(stream *storage.Stream, addr string) bool {
+	if len(stream.AllowedIps) == 0 && len(stream.DeniedIps) == 0 {
+		return true
+	}
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	if inNetworks(stream.DeniedIps, ip) {
+		return false
+	}
+	return len(stream.AllowedIps) == 0 || inNetworks(stream.AllowedIps, ip)
+}
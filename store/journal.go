@@ -0,0 +1,262 @@
+package store
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+
+	"github.com/voc/rtmp-auth/storage"
+	"google.golang.org/protobuf/proto"
+)
+
+// The journal of the file backend starts with a line naming its format version and the generation
+// of the snapshot it applies to, followed by records framed by their length and CRC-32:
+//
+//	rtmp-auth journal v1 <generation>\n
+//	[uint32 length][uint32 crc32][JournalRecord] ...
+const (
+	journalMagic   = "rtmp-auth journal"
+	journalVersion = 1
+	// defaultCompactRecords is the number of journal records written before the state is compacted
+	defaultCompactRecords = 1000
+	// maxJournalRecord bounds the length read from a damaged record header
+	maxJournalRecord = 64 << 20
+)
+
+// journalPath returns the path of the journal kept next to the state file
+func journalPath(path string) string {
+	return path + ".journal"
+}
+
+// diffState returns the changes from old to state, nil if there are none
+func diffState(old *storage.State, state *storage.State) *storage.JournalRecord {
+	rec := &storage.JournalRecord{}
+	oldStreams := make(map[string]*storage.Stream, len(old.Streams))
+	for _, stream := range old.Streams {
+		oldStreams[stream.Id] = stream
+	}
+	ids := make(map[string]bool, len(state.Streams))
+	for _, stream := range state.Streams {
+		ids[stream.Id] = true
+		if prev, ok := oldStreams[stream.Id]; !ok || !proto.Equal(prev, stream) {
+			rec.Put = append(rec.Put, stream)
+		}
+	}
+	for _, stream := range old.Streams {
+		if !ids[stream.Id] {
+			rec.Delete = append(rec.Delete, stream.Id)
+		}
+	}
+	if header := stateHeader(state); !proto.Equal(stateHeader(old), header) {
+		rec.Header = header
+	}
+	if len(rec.Put) == 0 && len(rec.Delete) == 0 && rec.Header == nil {
+		return nil
+	}
+	return rec
+}
+
+// stateHeader returns the fields of state journal records carry besides the streams
+func stateHeader(state *storage.State) *storage.State {
+	return &storage.State{Secret: state.Secret, Revision: state.Revision, Maintenance: state.Maintenance,
+		NextId: state.NextId, UsedNonces: state.UsedNonces}
+}
+
+// applyRecord changes state by a journal record, new streams are appended
+func applyRecord(state *storage.State, rec *storage.JournalRecord) {
+	if rec.Header != nil {
+		state.Secret = rec.Header.Secret
+		state.Revision = rec.Header.Revision
+		state.Maintenance = rec.Header.Maintenance
+		state.NextId = rec.Header.NextId
+		state.UsedNonces = rec.Header.UsedNonces
+	}
+	if len(rec.Delete) > 0 {
+		deleted := make(map[string]bool, len(rec.Delete))
+		for _, id := range rec.Delete {
+			deleted[id] = true
+		}
+		streams := state.Streams[:0]
+		for _, stream := range state.Streams {
+			if !deleted[stream.Id] {
+				streams = append(streams, stream)
+			}
+		}
+		state.Streams = streams
+	}
+	for _, put := range rec.Put {
+		replaced := false
+		for i, stream := range state.Streams {
+			if stream.Id == put.Id {
+				state.Streams[i] = put
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			state.Streams = append(state.Streams, put)
+		}
+	}
+}
+
+// replayJournal applies the records of the journal at path to state and returns their number.
+// A journal of another generation was left by a compaction interrupted after the snapshot
+// was written and is ignored. Replay stops at a damaged or incomplete record, e.g. of a crash while appending
+func replayJournal(path string, state *storage.State) (int, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, fmt.Errorf("failed to open journal: %w", err)
+	}
+	defer file.Close()
+	reader := bufio.NewReader(file)
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		// Crashed while the journal was created
+		if errors.Is(err, io.EOF) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("failed to read journal: %w", err)
+	}
+	var version int
+	var generation uint64
+	if _, err := fmt.Sscanf(line, journalMagic+" v%d %d\n", &version, &generation); err != nil {
+		return 0, fmt.Errorf("journal %s: invalid header %q", path, line)
+	}
+	if version != journalVersion {
+		return 0, fmt.Errorf("journal %s: unsupported version %d", path, version)
+	}
+	if generation != state.Generation {
+		log.Printf("Ignoring journal %s of generation %d, the state is of generation %d\n", path, generation, state.Generation)
+		return 0, nil
+	}
+
+	records := 0
+	var head [8]byte
+	for {
+		if _, err := io.ReadFull(reader, head[:]); errors.Is(err, io.EOF) {
+			return records, nil
+		} else if err != nil {
+			log.Printf("Journal %s ends with an incomplete record after %d records\n", path, records)
+			return records, nil
+		}
+		length := binary.BigEndian.Uint32(head[:4])
+		if length > maxJournalRecord {
+			log.Printf("Journal %s has a damaged record after %d records\n", path, records)
+			return records, nil
+		}
+		data := make([]byte, length)
+		if _, err := io.ReadFull(reader, data); err != nil {
+			log.Printf("Journal %s ends with an incomplete record after %d records\n", path, records)
+			return records, nil
+		}
+		var rec storage.JournalRecord
+		if crc32.ChecksumIEEE(data) != binary.BigEndian.Uint32(head[4:]) || proto.Unmarshal(data, &rec) != nil {
+			log.Printf("Journal %s has a damaged record after %d records\n", path, records)
+			return records, nil
+		}
+		applyRecord(state, &rec)
+		records++
+	}
+}
+
+// journal appends the changes of each write to the file backend, see FileBackendConfig.Journal
+type journal struct {
+	file    *os.File
+	records int
+}
+
+// createJournal replaces the journal at path by an empty one for the snapshot of generation
+func createJournal(path string, generation uint64) (*journal, error) {
+	tmp := path + ".new"
+	header := fmt.Sprintf(journalMagic+" v%d %d\n", journalVersion, generation)
+	if err := writeFileSync(tmp, []byte(header)); err != nil {
+		return nil, fmt.Errorf("failed to write journal: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return nil, fmt.Errorf("failed to move journal: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open journal: %w", err)
+	}
+	return &journal{file: file}, nil
+}
+
+// append writes a record and syncs it to disk
+func (j *journal) append(rec *storage.JournalRecord) error {
+	data, err := proto.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("failed to encode journal record: %w", err)
+	}
+	buf := make([]byte, 8, 8+len(data))
+	binary.BigEndian.PutUint32(buf[:4], uint32(len(data)))
+	binary.BigEndian.PutUint32(buf[4:], crc32.ChecksumIEEE(data))
+	if _, err := j.file.Write(append(buf, data...)); err != nil {
+		return fmt.Errorf("failed to append to journal: %w", err)
+	}
+	if err := j.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync journal: %w", err)
+	}
+	j.records++
+	return nil
+}
+
+func (j *journal) Close() error {
+	if j == nil {
+		return nil
+	}
+	return j.file.Close()
+}
+
+// compact writes the cached state as a snapshot of the next generation and starts an empty journal for it.
+// A crash in between leaves the old journal, which doesn't match the new snapshot and is ignored
+func (fb *FileBackend) compact() error {
+	fb.cache.Generation++
+	if err := fb.save(fb.cache); err != nil {
+		fb.cache.Generation--
+		return err
+	}
+	old := fb.journal
+	j, err := createJournal(journalPath(fb.path), fb.cache.Generation)
+	if err := old.Close(); err != nil {
+		log.Println("journal close:", err)
+	}
+	// Records appended to the old journal would be ignored, so every write saves a snapshot instead
+	fb.journal = j
+	if err != nil {
+		log.Println("journal disabled until restart:", err)
+	}
+	return err
+}
+
+// writeJournal appends the changes of state to the journal, compacting after compactRecords records.
+// Changes a record can't express, like reordered streams, are written as a snapshot right away
+func (fb *FileBackend) writeJournal(state *storage.State) error {
+	state = proto.Clone(state).(*storage.State)
+	state.Generation = fb.cache.Generation
+	rec := diffState(fb.cache, state)
+	if rec == nil {
+		return nil
+	}
+	applied := proto.Clone(fb.cache).(*storage.State)
+	applyRecord(applied, rec)
+	if !proto.Equal(applied, state) || fb.journal.records >= fb.compactRecords {
+		fb.cache = state
+		return fb.compact()
+	}
+	if err := fb.journal.append(rec); err != nil {
+		// A partial record ends the replay, the snapshot supersedes it
+		log.Println(err)
+		fb.cache = state
+		return fb.compact()
+	}
+	fb.cache = state
+	return nil
+}
@@ -0,0 +1,78 @@
+package store
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+
+	"github.com/voc/rtmp-auth/storage"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// hashKey returns the bcrypt hash of an auth key,
+// empty keys stay empty so streams without auth keep working
+func hashKey(key string) (string, error) {
+	if key == "" || IsHashedKey(key) {
+		return key, nil
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(key), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// GenerateKey returns a random key of 32 bytes, base64url encoded without padding
+// so it can be used in an rtmp query string as is
+func GenerateKey() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// IsHashedKey returns true if the stored key is a bcrypt hash
+func IsHashedKey(key string) bool {
+	_, err := bcrypt.Cost([]byte(key))
+	return err == nil
+}
+
+// matchKey compares a stored key, hashed or plaintext, to a presented one
+func matchKey(stored string, presented string) bool {
+	if IsHashedKey(stored) {
+		return bcrypt.CompareHashAndPassword([]byte(stored), []byte(presented)) == nil
+	}
+	return subtle.ConstantTimeCompare([]byte(stored), []byte(presented)) == 1
+}
+
+// matchAnyKey returns whether auth matches one of keys and the index of the match.
+// All keys are compared so timing doesn't reveal which one matched
+func matchAnyKey(keys []string, auth string) (bool, int) {
+	// Streams without keys don't require auth
+	if len(keys) == 0 {
+		return auth == "", -1
+	}
+	index := -1
+	for i, key := range keys {
+		if matchKey(key, auth) && index == -1 {
+			index = i
+		}
+	}
+	return index != -1, index
+}
+
+// StreamKeys returns all valid auth keys of a stream,
+// including the legacy single AuthKey
+func StreamKeys(stream *storage.Stream) []string {
+	if stream.AuthKey == "" {
+		return stream.AuthKeys
+	}
+	return append([]string{stream.AuthKey}, stream.AuthKeys...)
+}
+
+// migrateKeys moves the legacy AuthKey into AuthKeys, keeping the key order
+func migrateKeys(stream *storage.Stream) {
+	stream.AuthKeys = StreamKeys(stream)
+	stream.AuthKey = ""
+}
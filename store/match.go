@@ -0,0 +1,129 @@
+package store
+
+import (
+	"path"
+	"strings"
+
+	"github.com/voc/rtmp-auth/storage"
+)
+
+// IsPattern reports whether a stream name is a glob pattern like "event-*"
+func IsPattern(name string) bool {
+	return strings.ContainsAny(name, "*?[")
+}
+
+// ValidPattern reports whether a stream name pattern is well-formed
+func ValidPattern(name string) bool {
+	_, err := path.Match(name, "")
+	return err == nil
+}
+
+// matchingStreams returns the streams responsible for app/name.
+// Streams with the exact name take precedence, wildcard streams are only
+// considered if no stream with the exact name exists in the application.
+// Removed streams never match. This allows a single name covered by a pattern to be configured separately
+func matchingStreams(state *storage.State, app string, name string) []*storage.Stream {
+	var exact, wildcard []*storage.Stream
+	for _, stream := range state.Streams {
+		if stream.Application != app || stream.Removed != 0 {
+			continue
+		}
+		if stream.Name == name {
+			exact = append(exact, stream)
+		} else if IsPattern(stream.Name) {
+			if matched, _ := path.Match(stream.Name, name); matched {
+				wildcard = append(wildcard, stream)
+			}
+		}
+	}
+	if len(exact) > 0 {
+		return exact
+	}
+	return wildcard
+}
+
+// activeFor reports whether the stream is active under the concrete name
+func activeFor(stream *storage.Stream, name string) bool {
+	if stream.Name == name {
+		return stream.Active
+	}
+	for _, active := range stream.ActiveNames {
+		if active == name {
+			return true
+		}
+	}
+	return false
+}
+
+// activeNames returns the concrete names the stream is published under
+func activeNames(stream *storage.Stream) []string {
+	if !IsPattern(stream.Name) {
+		if stream.Active {
+			return []string{stream.Name}
+		}
+		return nil
+	}
+	return append([]string(nil), stream.ActiveNames...)
+}
+
+// setActiveFor sets the active state of the stream under the concrete name.
+// Wildcard streams track each concrete name and are active while any of them is
+func setActiveFor(stream *storage.Stream, name string, active bool) {
+	if stream.Name == name {
+		stream.Active = active
+		return
+	}
+	names := stream.ActiveNames[:0]
+	for _, n := range stream.ActiveNames {
+		if n != name {
+			names = append(names, n)
+		}
+	}
+	if active {
+		names = append(names, name)
+	}
+	stream.ActiveNames = names
+	stream.Active = len(names) > 0
+}
+
+// MaxPublishers returns the number of concurrent publishers allowed per published name
+func MaxPublishers(stream *storage.Stream) int32 {
+	if stream.MaxPublishers <= 0 {
+		return 1
+	}
+	return stream.MaxPublishers
+}
+
+// ActivePublishers returns the number of active publishers of the stream across all names
+func ActivePublishers(stream *storage.Stream) int32 {
+	var count int32
+	for _, n := range stream.Publishers {
+		count += n
+	}
+	return count
+}
+
+// publishersFor returns the number of active publishers of the stream under the concrete name
+func publishersFor(stream *storage.Stream, name string) int32 {
+	if count, ok := stream.Publishers[name]; ok {
+		return count
+	}
+	// state written before publishers were counted
+	if activeFor(stream, name) {
+		return 1
+	}
+	return 0
+}
+
+// setPublishersFor sets the number of active publishers under the concrete name and the active state with it
+func setPublishersFor(stream *storage.Stream, name string, count int32) {
+	if count > 0 {
+		if stream.Publishers == nil {
+			stream.Publishers = make(map[string]int32)
+		}
+		stream.Publishers[name] = count
+	} else {
+		delete(stream.Publishers, name)
+	}
+	setActiveFor(stream, name, count > 0)
+}
@@ -0,0 +1,589 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/voc/rtmp-auth/storage"
+	"google.golang.org/protobuf/proto"
+)
+
+type PostgresBackendConfig struct {
+	// Connection is a libpq connection string or URL, not logged as it may contain the password
+	Connection string `toml:"connection" json:"-"`
+}
+
+// postgresLock is the advisory lock key serializing migrations and writes across instances
+const postgresLock = 0x72746d70
+
+// postgresMigrations are applied in order, the applied count is kept in schema_version
+var postgresMigrations = []string{
+	`CREATE SEQUENCE stream_revision;
+	CREATE TABLE streams (
+		id TEXT PRIMARY KEY,
+		application TEXT NOT NULL,
+		name TEXT NOT NULL,
+		active BOOLEAN NOT NULL DEFAULT FALSE,
+		data BYTEA NOT NULL,
+		revision BIGINT NOT NULL
+	);
+	CREATE INDEX streams_application_name ON streams (application, name);
+	CREATE TABLE settings (
+		key TEXT PRIMARY KEY,
+		value BYTEA NOT NULL
+	);`,
+	`ALTER TABLE streams ADD COLUMN active_names TEXT[] NOT NULL DEFAULT '{}';`,
+	`ALTER TABLE streams ADD COLUMN last_active BIGINT NOT NULL DEFAULT 0;
+	ALTER TABLE streams ADD COLUMN publish_count BIGINT NOT NULL DEFAULT 0;`,
+	`ALTER TABLE streams ADD COLUMN publishers JSONB NOT NULL DEFAULT '{}';`,
+	`ALTER TABLE streams ADD COLUMN session_started BIGINT NOT NULL DEFAULT 0;`,
+	`CREATE TABLE used_nonces (
+		nonce TEXT PRIMARY KEY,
+		expiry BIGINT NOT NULL
+	);`,
+	// Revisions change with every write, existing rows keep the order they were read in so far
+	`CREATE SEQUENCE stream_position;
+	ALTER TABLE streams ADD COLUMN position BIGINT;
+	UPDATE streams SET position = numbered.position
+		FROM (SELECT id, row_number() OVER (ORDER BY revision) AS position FROM streams) numbered
+		WHERE streams.id = numbered.id;
+	SELECT setval('stream_position', COALESCE(MAX(position), 0) + 1, false) FROM streams;
+	ALTER TABLE streams ALTER COLUMN position SET DEFAULT nextval('stream_position'),
+		ALTER COLUMN position SET NOT NULL;`,
+	`ALTER TABLE streams ADD COLUMN history BYTEA NOT NULL DEFAULT '';`,
+}
+
+// postgresHistoryMigration is the number of the migration adding the history column,
+// the history of existing rows is moved out of their data by movePostgresHistory
+const postgresHistoryMigration = 8
+
+var errStateChanged = errors.New("state changed during request, please try again")
+
+// PostgresBackend shares the state between multiple instances.
+// Reads always query the database, stream changes are written with optimistic locking
+// on the row revision. The active state and history are only written by UpdateContext, which reads
+// them in the same transaction, so concurrent publishes on different instances are all counted.
+// They are kept apart from the revisioned data, so a publish doesn't fail a concurrent admin write
+type PostgresBackend struct {
+	db *sql.DB
+}
+
+type postgresRow struct {
+	active         bool
+	activeNames    []string
+	publishers     map[string]int32
+	lastActive     int64
+	publishCount   int64
+	sessionStarted int64
+	history        []byte
+	data           []byte
+	revision       int64
+}
+
+// sameActive compares the publish state kept outside of the revisioned data
+func (row *postgresRow) sameActive(stream *storage.Stream) bool {
+	if row.active != stream.Active || len(row.activeNames) != len(stream.ActiveNames) ||
+		row.lastActive != stream.LastActive || row.publishCount != stream.PublishCount ||
+		row.sessionStarted != stream.SessionStarted {
+		return false
+	}
+	for i := range row.activeNames {
+		if row.activeNames[i] != stream.ActiveNames[i] {
+			return false
+		}
+	}
+	if len(row.publishers) != len(stream.Publishers) {
+		return false
+	}
+	for name, count := range row.publishers {
+		if stream.Publishers[name] != count {
+			return false
+		}
+	}
+	return true
+}
+
+const postgresColumns = "id, active, active_names, publishers, last_active, publish_count, session_started, history, data, revision"
+
+// scanPostgresRow scans the postgresColumns of a stream row
+func scanPostgresRow(rows *sql.Rows) (string, postgresRow, error) {
+	var id string
+	var row postgresRow
+	var publishers []byte
+	if err := rows.Scan(&id, &row.active, pq.Array(&row.activeNames), &publishers,
+		&row.lastActive, &row.publishCount, &row.sessionStarted, &row.history, &row.data, &row.revision); err != nil {
+		return "", row, err
+	}
+	if err := json.Unmarshal(publishers, &row.publishers); err != nil {
+		return "", row, fmt.Errorf("failed to parse publishers of stream %s: %w", id, err)
+	}
+	return id, row, nil
+}
+
+// encodePublishers returns the JSON of the publisher counts, never null
+func encodePublishers(stream *storage.Stream) ([]byte, error) {
+	if len(stream.Publishers) == 0 {
+		return []byte("{}"), nil
+	}
+	return json.Marshal(stream.Publishers)
+}
+
+// encodePostgresHistory encodes the connection events of a stream for the history column
+func encodePostgresHistory(stream *storage.Stream) ([]byte, error) {
+	return proto.MarshalOptions{Deterministic: true}.Marshal(&storage.Stream{History: stream.History})
+}
+
+// decodePostgresHistory returns the connection events of a history column
+func decodePostgresHistory(history []byte) ([]*storage.ConnectionEvent, error) {
+	var stream storage.Stream
+	if err := proto.Unmarshal(history, &stream); err != nil {
+		return nil, err
+	}
+	return stream.History, nil
+}
+
+func NewPostgresBackend(config PostgresBackendConfig) (Backend, error) {
+	db, err := sql.Open("postgres", config.Connection)
+	if err != nil {
+		return nil, fmt.Errorf("open: %w", err)
+	}
+	pb := &PostgresBackend{db: db}
+
+	if err := pb.migrate(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("migrate: %w", err)
+	}
+
+	// Active state is shared with other instances and therefore not cleared here
+	state, err := pb.Read()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	// Generate secret
+	if len(state.Secret) == 0 {
+		state.Secret = make([]byte, 32)
+		if _, err := rand.Read(state.Secret); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("generate secret: %w", err)
+		}
+		if err := pb.Write(state); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	log.Println("State restored from postgres")
+	return pb, nil
+}
+
+// migrate applies all migrations not yet recorded in the database
+func (pb *PostgresBackend) migrate() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	tx, err := pb.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Instances starting at the same time wait for each other here
+	if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1)", postgresLock); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS schema_version (version INTEGER NOT NULL)"); err != nil {
+		return err
+	}
+	var version int
+	err = tx.QueryRowContext(ctx, "SELECT version FROM schema_version").Scan(&version)
+	if errors.Is(err, sql.ErrNoRows) {
+		_, err = tx.ExecContext(ctx, "INSERT INTO schema_version (version) VALUES (0)")
+	}
+	if err != nil {
+		return err
+	}
+
+	for i := version; i < len(postgresMigrations); i++ {
+		if _, err := tx.ExecContext(ctx, postgresMigrations[i]); err != nil {
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+		if i+1 == postgresHistoryMigration {
+			if err := movePostgresHistory(ctx, tx); err != nil {
+				return fmt.Errorf("migration %d: %w", i+1, err)
+			}
+		}
+		log.Printf("postgres: applied migration %d\n", i+1)
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE schema_version SET version = $1", len(postgresMigrations)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// movePostgresHistory moves the history of all streams from their data to the history column.
+// The revisions are kept, the streams didn't change
+func movePostgresHistory(ctx context.Context, tx *sql.Tx) error {
+	rows, err := tx.QueryContext(ctx, "SELECT id, data FROM streams")
+	if err != nil {
+		return err
+	}
+	data := make(map[string][]byte)
+	for rows.Next() {
+		var id string
+		var value []byte
+		if err := rows.Scan(&id, &value); err != nil {
+			rows.Close()
+			return err
+		}
+		data[id] = value
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for id, value := range data {
+		var stream storage.Stream
+		if err := proto.Unmarshal(value, &stream); err != nil {
+			return fmt.Errorf("failed to parse stream %s: %w", id, err)
+		}
+		if len(stream.History) == 0 {
+			continue
+		}
+		history, err := encodePostgresHistory(&stream)
+		if err != nil {
+			return err
+		}
+		stripped, err := encodePostgresStream(&stream)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE streams SET data = $2, history = $3 WHERE id = $1", id, stripped, history); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Read queries the current state from the database
+func (pb *PostgresBackend) Read() (*storage.State, error) {
+	return pb.ReadContext(context.Background())
+}
+
+// ReadContext is Read cancelled with ctx, queries take a second at most.
+// They run in one repeatable read transaction, so the state is a consistent snapshot
+func (pb *PostgresBackend) ReadContext(ctx context.Context) (*storage.State, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+
+	tx, err := pb.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	state, err := readPostgresState(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+	return state, tx.Commit()
+}
+
+// readPostgresState queries the state within tx
+func readPostgresState(ctx context.Context, tx *sql.Tx) (*storage.State, error) {
+	var state storage.State
+
+	err := tx.QueryRowContext(ctx, "SELECT value FROM settings WHERE key = 'secret'").Scan(&state.Secret)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("read secret: %w", err)
+	}
+	var maintenance []byte
+	err = tx.QueryRowContext(ctx, "SELECT value FROM settings WHERE key = 'maintenance'").Scan(&maintenance)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("read maintenance: %w", err)
+	}
+	state.Maintenance = string(maintenance) == "true"
+	var nextId []byte
+	err = tx.QueryRowContext(ctx, "SELECT value FROM settings WHERE key = 'next_id'").Scan(&nextId)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("read next id: %w", err)
+	}
+	if len(nextId) > 0 {
+		if state.NextId, err = strconv.ParseUint(string(nextId), 10, 64); err != nil {
+			return nil, fmt.Errorf("read next id: %w", err)
+		}
+	}
+
+	if state.UsedNonces, err = readPostgresNonces(ctx, tx); err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.QueryContext(ctx, "SELECT "+postgresColumns+" FROM streams ORDER BY position")
+	if err != nil {
+		return nil, fmt.Errorf("read streams: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		id, row, err := scanPostgresRow(rows)
+		if err != nil {
+			return nil, err
+		}
+		var stream storage.Stream
+		if err := proto.Unmarshal(row.data, &stream); err != nil {
+			return nil, fmt.Errorf("failed to parse stream %s: %w", id, err)
+		}
+		stream.Active = row.active
+		stream.ActiveNames = row.activeNames
+		stream.Publishers = row.publishers
+		stream.LastActive = row.lastActive
+		stream.PublishCount = row.publishCount
+		stream.SessionStarted = row.sessionStarted
+		if stream.History, err = decodePostgresHistory(row.history); err != nil {
+			return nil, fmt.Errorf("failed to parse history of stream %s: %w", id, err)
+		}
+		stream.Revision = row.revision
+		state.Streams = append(state.Streams, &stream)
+		if row.revision > state.Revision {
+			state.Revision = row.revision
+		}
+	}
+	return &state, rows.Err()
+}
+
+// encodePostgresStream encodes a stream without the fields kept in separate columns
+func encodePostgresStream(stream *storage.Stream) ([]byte, error) {
+	stripped := proto.Clone(stream).(*storage.Stream)
+	stripped.Active = false
+	stripped.ActiveNames = nil
+	stripped.Publishers = nil
+	stripped.LastActive = 0
+	stripped.PublishCount = 0
+	stripped.SessionStarted = 0
+	stripped.History = nil
+	stripped.Revision = 0
+	return proto.MarshalOptions{Deterministic: true}.Marshal(stripped)
+}
+
+// Write applies the changes made to a previously read state.
+// Fails if another instance changed or removed a modified stream in the meantime.
+// Streams added by other instances after the state was read are kept
+func (pb *PostgresBackend) Write(state *storage.State) error {
+	return pb.WriteContext(context.Background(), state)
+}
+
+// WriteContext is Write cancelled with ctx, the transaction takes a second at most.
+// The active state and history of existing streams are left as is, they may have changed since state was read
+func (pb *PostgresBackend) WriteContext(ctx context.Context, state *storage.State) error {
+	if state == nil {
+		return errors.New("state should not be nil")
+	}
+	return pb.transaction(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		return writePostgresState(ctx, tx, state, false)
+	})
+}
+
+// UpdateContext reads the state, applies update and writes the result including the active state and history,
+// all while holding the write lock in one transaction taking a second at most
+func (pb *PostgresBackend) UpdateContext(ctx context.Context, update func(state *storage.State) error) error {
+	return pb.transaction(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		state, err := readPostgresState(ctx, tx)
+		if err != nil {
+			return err
+		}
+		if err := update(state); err != nil {
+			return err
+		}
+		return writePostgresState(ctx, tx, state, true)
+	})
+}
+
+// transaction runs fn in a transaction holding the write lock and commits unless fn fails
+func (pb *PostgresBackend) transaction(ctx context.Context, fn func(ctx context.Context, tx *sql.Tx) error) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+
+	tx, err := pb.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Serialize writers so revisions are assigned in commit order, reads after it see all committed writes
+	if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1)", postgresLock); err != nil {
+		return err
+	}
+	if err := fn(ctx, tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// readPostgresNonces returns the used nonces by the expiry of their token, nil if there are none
+func readPostgresNonces(ctx context.Context, tx *sql.Tx) (map[string]int64, error) {
+	rows, err := tx.QueryContext(ctx, "SELECT nonce, expiry FROM used_nonces")
+	if err != nil {
+		return nil, fmt.Errorf("read nonces: %w", err)
+	}
+	defer rows.Close()
+	var nonces map[string]int64
+	for rows.Next() {
+		var nonce string
+		var expiry int64
+		if err := rows.Scan(&nonce, &expiry); err != nil {
+			return nil, err
+		}
+		if nonces == nil {
+			nonces = make(map[string]int64)
+		}
+		nonces[nonce] = expiry
+	}
+	return nonces, rows.Err()
+}
+
+// writePostgresState writes the changes of state within tx, the active state and history only with active
+func writePostgresState(ctx context.Context, tx *sql.Tx, state *storage.State, active bool) error {
+	_, err := tx.ExecContext(ctx, `INSERT INTO settings (key, value) VALUES ('secret', $1)
+		ON CONFLICT (key) DO UPDATE SET value = excluded.value
+		WHERE settings.value IS DISTINCT FROM excluded.value`, state.Secret)
+	if err != nil {
+		return fmt.Errorf("write secret: %w", err)
+	}
+	_, err = tx.ExecContext(ctx, `INSERT INTO settings (key, value) VALUES ('maintenance', $1)
+		ON CONFLICT (key) DO UPDATE SET value = excluded.value
+		WHERE settings.value IS DISTINCT FROM excluded.value`, []byte(strconv.FormatBool(state.Maintenance)))
+	if err != nil {
+		return fmt.Errorf("write maintenance: %w", err)
+	}
+	// Another instance may have added streams since state was read, the counter only moves forward
+	_, err = tx.ExecContext(ctx, `INSERT INTO settings (key, value) VALUES ('next_id', $1)
+		ON CONFLICT (key) DO UPDATE SET value = excluded.value
+		WHERE convert_from(settings.value, 'UTF8')::numeric < convert_from(excluded.value, 'UTF8')::numeric`,
+		[]byte(strconv.FormatUint(state.NextId, 10)))
+	if err != nil {
+		return fmt.Errorf("write next id: %w", err)
+	}
+	// Nonces are only added and pruned once expired, so a state read before another instance
+	// used a token can't make it usable again
+	stored, err := readPostgresNonces(ctx, tx)
+	if err != nil {
+		return err
+	}
+	for nonce, expiry := range state.UsedNonces {
+		if _, ok := stored[nonce]; ok {
+			continue
+		}
+		_, err := tx.ExecContext(ctx, `INSERT INTO used_nonces (nonce, expiry) VALUES ($1, $2)
+			ON CONFLICT (nonce) DO NOTHING`, nonce, expiry)
+		if err != nil {
+			return fmt.Errorf("write nonce: %w", err)
+		}
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM used_nonces WHERE expiry < $1", time.Now().Unix()); err != nil {
+		return fmt.Errorf("prune nonces: %w", err)
+	}
+
+	current := make(map[string]postgresRow)
+	rows, err := tx.QueryContext(ctx, "SELECT "+postgresColumns+" FROM streams")
+	if err != nil {
+		return fmt.Errorf("read streams: %w", err)
+	}
+	for rows.Next() {
+		id, row, err := scanPostgresRow(rows)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		current[id] = row
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, stream := range state.Streams {
+		data, err := encodePostgresStream(stream)
+		if err != nil {
+			return fmt.Errorf("failed to encode stream: %w", err)
+		}
+		publishers, err := encodePublishers(stream)
+		if err != nil {
+			return fmt.Errorf("failed to encode publishers: %w", err)
+		}
+		history, err := encodePostgresHistory(stream)
+		if err != nil {
+			return fmt.Errorf("failed to encode history: %w", err)
+		}
+		row, exists := current[stream.Id]
+		delete(current, stream.Id)
+
+		if !exists {
+			if stream.Revision != 0 {
+				// removed by another instance
+				return errStateChanged
+			}
+			_, err = tx.ExecContext(ctx, `INSERT INTO streams (id, application, name, active, active_names,
+				publishers, last_active, publish_count, session_started, history, data, revision)
+				VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, nextval('stream_revision'))`,
+				stream.Id, stream.Application, stream.Name, stream.Active, pq.Array(stream.ActiveNames),
+				publishers, stream.LastActive, stream.PublishCount, stream.SessionStarted, history, data)
+			if err != nil {
+				return fmt.Errorf("write stream %s: %w", stream.Id, err)
+			}
+			continue
+		}
+		if !bytes.Equal(row.data, data) {
+			if row.revision != stream.Revision {
+				return errStateChanged
+			}
+			_, err = tx.ExecContext(ctx, `UPDATE streams SET application = $2, name = $3, data = $4,
+				revision = nextval('stream_revision') WHERE id = $1`,
+				stream.Id, stream.Application, stream.Name, data)
+			if err != nil {
+				return fmt.Errorf("write stream %s: %w", stream.Id, err)
+			}
+		}
+		if active && (!row.sameActive(stream) || !bytes.Equal(row.history, history)) {
+			// Active state and history are reported by the rtmp server and not subject to revisions,
+			// they were read in this transaction and can be written as is
+			_, err = tx.ExecContext(ctx, `UPDATE streams SET active = $2, active_names = $3, publishers = $4,
+				last_active = $5, publish_count = $6, session_started = $7, history = $8 WHERE id = $1`,
+				stream.Id, stream.Active, pq.Array(stream.ActiveNames), publishers, stream.LastActive, stream.PublishCount,
+				stream.SessionStarted, history)
+			if err != nil {
+				return fmt.Errorf("write stream %s: %w", stream.Id, err)
+			}
+		}
+	}
+
+	// Remaining rows were either removed from the state or added after it was read
+	for id, row := range current {
+		if row.revision > state.Revision {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM streams WHERE id = $1", id); err != nil {
+			return fmt.Errorf("remove stream %s: %w", id, err)
+		}
+	}
+	return nil
+}
+
+// Check verifies the database connection
+func (pb *PostgresBackend) Check() error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	return pb.db.PingContext(ctx)
+}
+
+// Close closes the database
+func (pb *PostgresBackend) Close() error {
+	return pb.db.Close()
+}
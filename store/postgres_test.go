@@ -0,0 +1,230 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/voc/rtmp-auth/storage"
+)
+
+// postgresConnection returns the connection string of RTMP_AUTH_POSTGRES or starts a postgres container
+// removed after the test, the test is skipped if docker isn't available
+func postgresConnection(t *testing.T) string {
+	t.Helper()
+	if connection := os.Getenv("RTMP_AUTH_POSTGRES"); connection != "" {
+		return connection
+	}
+	if testing.Short() {
+		t.Skip("postgres container skipped in short mode")
+	}
+	if _, err := exec.LookPath("docker"); err != nil {
+		t.Skip("docker not found, set RTMP_AUTH_POSTGRES to test against a running database")
+	}
+	out, err := exec.Command("docker", "run", "-d", "--rm", "-e", "POSTGRES_PASSWORD=test",
+		"-p", "127.0.0.1::5432", "postgres:16-alpine").Output()
+	if err != nil {
+		t.Skipf("failed to start postgres container: %v", err)
+	}
+	container := strings.TrimSpace(string(out))
+	t.Cleanup(func() {
+		exec.Command("docker", "rm", "-f", container).Run()
+	})
+	out, err = exec.Command("docker", "port", container, "5432/tcp").Output()
+	if err != nil {
+		t.Fatalf("failed to get postgres port: %v", err)
+	}
+	address := strings.TrimSpace(strings.Split(string(out), "\n")[0])
+	connection := fmt.Sprintf("postgres://postgres:test@%s/postgres?sslmode=disable", address)
+
+	// The server only listens on tcp once initialized
+	db, err := sql.Open("postgres", connection)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	deadline := time.Now().Add(time.Minute)
+	for db.Ping() != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("postgres container didn't start within a minute")
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+	return connection
+}
+
+func newPostgresStore(t *testing.T, connection string) *Store {
+	t.Helper()
+	store, err := NewStore(StoreConfig{Backend: "postgres", Postgres: PostgresBackendConfig{Connection: connection}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+// Two instances sharing the database count all publishes and unpublishes reported to them at the same time
+func TestPostgresConcurrentActive(t *testing.T) {
+	connection := postgresConnection(t)
+	first := newPostgresStore(t, connection)
+	second := newPostgresStore(t, connection)
+
+	const publishes = 20
+	// Unique names allow running the tests repeatedly against the same database
+	name := fmt.Sprintf("race-%d", time.Now().UnixNano())
+	stream := &storage.Stream{Application: "live", Name: name, AuthKeys: []string{"abcdefgh1"}, AuthExpire: -1, MaxPublishers: 2 * publishes}
+	if err := first.AddStream(stream); err != nil {
+		t.Fatal(err)
+	}
+	publishers := func() int32 {
+		t.Helper()
+		state, err := second.Get()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, s := range state.Streams {
+			if s.Id == stream.Id {
+				return publishersFor(s, name)
+			}
+		}
+		t.Fatal("stream not found")
+		return 0
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < publishes; i++ {
+		for _, store := range []*Store{first, second} {
+			wg.Add(1)
+			go func(store *Store) {
+				defer wg.Done()
+				if _, err := store.SetActive(context.Background(), stream.Id, name, "10.0.0.1", PolicyDeny); err != nil {
+					t.Error(err)
+				}
+			}(store)
+		}
+	}
+	wg.Wait()
+	if got := publishers(); got != 2*publishes {
+		t.Fatalf("publishers after concurrent publishes = %d, want %d", got, 2*publishes)
+	}
+
+	for i := 0; i < publishes; i++ {
+		for _, store := range []*Store{first, second} {
+			wg.Add(1)
+			go func(store *Store) {
+				defer wg.Done()
+				if !store.SetInactive(context.Background(), "live", name, "10.0.0.1") {
+					t.Error("unpublish failed")
+				}
+			}(store)
+		}
+	}
+	wg.Wait()
+	if got := publishers(); got != 0 {
+		t.Fatalf("publishers after concurrent unpublishes = %d, want 0", got)
+	}
+}
+
+// A write of a state read before another instance's publish keeps that publish
+func TestPostgresWriteKeepsActive(t *testing.T) {
+	connection := postgresConnection(t)
+	first := newPostgresStore(t, connection)
+	second := newPostgresStore(t, connection)
+
+	name := fmt.Sprintf("stale-%d", time.Now().UnixNano())
+	stream := &storage.Stream{Application: "live", Name: name, AuthKeys: []string{"abcdefgh1"}, AuthExpire: -1}
+	other := &storage.Stream{Application: "live", Name: name + "-other", AuthKeys: []string{"abcdefgh2"}, AuthExpire: -1}
+	if err := first.AddStream(stream); err != nil {
+		t.Fatal(err)
+	}
+	if err := first.AddStream(other); err != nil {
+		t.Fatal(err)
+	}
+	state, err := first.backend.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := second.SetActive(context.Background(), stream.Id, name, "10.0.0.1", PolicyDeny); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range state.Streams {
+		if s.Id == other.Id {
+			s.Blocked = true
+		}
+	}
+	if err := first.backend.Write(state); err != nil {
+		t.Fatal(err)
+	}
+
+	current, err := second.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range current.Streams {
+		if s.Id == stream.Id && !s.Active {
+			t.Error("publish of another instance was overwritten by a stale write")
+		}
+		if s.Id == other.Id && !s.Blocked {
+			t.Error("block wasn't written")
+		}
+	}
+}
+
+// A change to a stream which another instance published since it was read is written,
+// and streams keep the order they were added in
+func TestPostgresWriteAfterPublish(t *testing.T) {
+	connection := postgresConnection(t)
+	first := newPostgresStore(t, connection)
+	second := newPostgresStore(t, connection)
+
+	name := fmt.Sprintf("order-%d", time.Now().UnixNano())
+	var ids []string
+	for i := 0; i < 3; i++ {
+		stream := &storage.Stream{Application: "live", Name: fmt.Sprintf("%s-%d", name, i), AuthKeys: []string{fmt.Sprintf("abcdefgh%d", i)}, AuthExpire: -1}
+		if err := first.AddStream(stream); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, stream.Id)
+	}
+	state, err := first.backend.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := second.SetActive(context.Background(), ids[0], name+"-0", "10.0.0.1", PolicyDeny); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range state.Streams {
+		if s.Id == ids[0] {
+			s.Notes = "changed"
+		}
+	}
+	if err := first.backend.Write(state); err != nil {
+		t.Fatalf("write after a publish of another instance: %v", err)
+	}
+
+	current, err := second.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var order []string
+	for _, s := range current.Streams {
+		for _, id := range ids {
+			if s.Id == id {
+				order = append(order, id)
+			}
+		}
+		if s.Id == ids[0] && (!s.Active || s.Notes != "changed" || len(s.History) != 1) {
+			t.Errorf("stream after write = active %v, notes %q, %d events, want active, changed, 1 event",
+				s.Active, s.Notes, len(s.History))
+		}
+	}
+	if strings.Join(order, ",") != strings.Join(ids, ",") {
+		t.Errorf("stream order = %v, want %v", order, ids)
+	}
+}
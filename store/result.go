@@ -0,0 +1,73 @@
+package store
+
+// AuthReason tells why a publish was authorized or rejected
+type AuthReason int
+
+const (
+	ReasonOK AuthReason = iota
+	// ReasonNotFound means no stream is defined for the application and name
+	ReasonNotFound
+	// ReasonBadKey means streams exist, but none matched the key
+	ReasonBadKey
+	ReasonBlocked
+	ReasonExpired
+	// ReasonIPDenied means the source address is outside the stream's ip restrictions
+	ReasonIPDenied
+	// ReasonConflict means another stream is live under the same name
+	ReasonConflict
+	// ReasonOutsideWindow means the publish is before or after the stream's activation window
+	ReasonOutsideWindow
+	// ReasonDisabled means the stream was disabled administratively, see SetDisabled
+	ReasonDisabled
+	// ReasonUnavailable means the state couldn't be read, e.g. because the request was cancelled
+	// or the backend didn't answer in time
+	ReasonUnavailable
+	// ReasonMaintenance means a new publish was rejected as maintenance mode is on, see SetMaintenance
+	ReasonMaintenance
+	// ReasonCountryDenied means the source address is located outside the stream's allowed countries
+	ReasonCountryDenied
+	// ReasonTokenReplayed means a one-time token was already used for a publish, see UseToken
+	ReasonTokenReplayed
+)
+
+var reasonNames = map[AuthReason]string{
+	ReasonOK:       "ok",
+	ReasonNotFound: "not_found",
+	ReasonBadKey:   "bad_key",
+	ReasonBlocked:  "blocked",
+	ReasonExpired:  "expired",
+	ReasonIPDenied: "ip_denied",
+	ReasonConflict: "conflict",
+
+	ReasonOutsideWindow: "outside_window",
+	ReasonDisabled:      "disabled",
+	ReasonUnavailable:   "unavailable",
+	ReasonMaintenance:   "maintenance",
+	ReasonCountryDenied: "country_denied",
+	ReasonTokenReplayed: "token_replayed",
+}
+
+func (reason AuthReason) String() string {
+	if name, ok := reasonNames[reason]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// ParseReason returns the reason String returns name for, ok is false for unknown names
+func ParseReason(name string) (reason AuthReason, ok bool) {
+	for reason, reasonName := range reasonNames {
+		if reasonName == name {
+			return reason, true
+		}
+	}
+	return ReasonOK, false
+}
+
+// AuthResult is the outcome of an auth check
+type AuthResult struct {
+	Authorized bool
+	// Id of the matched stream, empty if none matched
+	Id     string
+	Reason AuthReason
+}
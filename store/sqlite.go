@@ -0,0 +1,300 @@
+package store
+
+import (
+	"bytes"
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/voc/rtmp-auth/storage"
+	"google.golang.org/protobuf/proto"
+	_ "modernc.org/sqlite"
+)
+
+type SQLiteBackendConfig struct {
+	Path string
+}
+
+// sqliteMigrations are applied in order, the applied count is kept in user_version
+var sqliteMigrations = []string{
+	`CREATE TABLE streams (
+		id TEXT PRIMARY KEY,
+		application TEXT NOT NULL,
+		name TEXT NOT NULL,
+		data BLOB NOT NULL
+	);
+	CREATE INDEX streams_application_name ON streams (application, name);
+	CREATE TABLE settings (
+		key TEXT PRIMARY KEY,
+		value BLOB NOT NULL
+	);`,
+	`CREATE TABLE used_nonces (
+		nonce TEXT PRIMARY KEY,
+		expiry INTEGER NOT NULL
+	);`,
+}
+
+// SQLiteBackend stores each stream as a row, writes only touch changed rows
+type SQLiteBackend struct {
+	db    *sql.DB
+	cache *storage.State
+	// rows holds the last written encoding of each stream by id
+	rows  map[string][]byte
+	mutex sync.RWMutex
+}
+
+func NewSQLiteBackend(config SQLiteBackendConfig) (Backend, error) {
+	db, err := sql.Open("sqlite", config.Path)
+	if err != nil {
+		return nil, fmt.Errorf("open: %w", err)
+	}
+	// SQLite only supports a single writer
+	db.SetMaxOpenConns(1)
+
+	if err := migrateSQLite(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("migrate: %w", err)
+	}
+
+	sb := &SQLiteBackend{db: db, cache: &storage.State{}, rows: make(map[string][]byte)}
+	state, err := sb.read()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	// Clear active information for old streams
+	for _, stream := range state.Streams {
+		stream.Active = false
+		stream.ActiveNames = nil
+		stream.Publishers = nil
+		stream.SessionStarted = 0
+	}
+
+	// Generate secret
+	if len(state.Secret) == 0 {
+		state.Secret = make([]byte, 32)
+		if _, err := rand.Read(state.Secret); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("generate secret: %w", err)
+		}
+	}
+
+	if err := sb.Write(state); err != nil {
+		db.Close()
+		return nil, err
+	}
+	log.Println("State restored from", config.Path)
+	return sb, nil
+}
+
+// migrateSQLite applies all migrations not yet recorded in the database
+func migrateSQLite(db *sql.DB) error {
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return err
+	}
+	for i := version; i < len(sqliteMigrations); i++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(sqliteMigrations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+		// PRAGMA doesn't support placeholders
+		if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", i+1)); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		log.Printf("sqlite: applied migration %d\n", i+1)
+	}
+	return nil
+}
+
+// read loads the full state from the database
+func (sb *SQLiteBackend) read() (*storage.State, error) {
+	var state storage.State
+
+	err := sb.db.QueryRow("SELECT value FROM settings WHERE key = 'secret'").Scan(&state.Secret)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("read secret: %w", err)
+	}
+	var maintenance []byte
+	err = sb.db.QueryRow("SELECT value FROM settings WHERE key = 'maintenance'").Scan(&maintenance)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("read maintenance: %w", err)
+	}
+	state.Maintenance = string(maintenance) == "true"
+	var nextId []byte
+	err = sb.db.QueryRow("SELECT value FROM settings WHERE key = 'next_id'").Scan(&nextId)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("read next id: %w", err)
+	}
+	if len(nextId) > 0 {
+		if state.NextId, err = strconv.ParseUint(string(nextId), 10, 64); err != nil {
+			return nil, fmt.Errorf("read next id: %w", err)
+		}
+	}
+
+	nonces, err := sb.db.Query("SELECT nonce, expiry FROM used_nonces")
+	if err != nil {
+		return nil, fmt.Errorf("read nonces: %w", err)
+	}
+	defer nonces.Close()
+	for nonces.Next() {
+		var nonce string
+		var expiry int64
+		if err := nonces.Scan(&nonce, &expiry); err != nil {
+			return nil, err
+		}
+		if state.UsedNonces == nil {
+			state.UsedNonces = make(map[string]int64)
+		}
+		state.UsedNonces[nonce] = expiry
+	}
+	if err := nonces.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err := sb.db.Query("SELECT id, data FROM streams ORDER BY rowid")
+	if err != nil {
+		return nil, fmt.Errorf("read streams: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		var data []byte
+		if err := rows.Scan(&id, &data); err != nil {
+			return nil, err
+		}
+		var stream storage.Stream
+		if err := proto.Unmarshal(data, &stream); err != nil {
+			return nil, fmt.Errorf("failed to parse stream %s: %w", id, err)
+		}
+		state.Streams = append(state.Streams, &stream)
+		sb.rows[id] = data
+	}
+	return &state, rows.Err()
+}
+
+func (sb *SQLiteBackend) Read() (*storage.State, error) {
+	sb.mutex.RLock()
+	defer sb.mutex.RUnlock()
+	res := proto.Clone(sb.cache).(*storage.State)
+	return res, nil
+}
+
+// Write applies the difference to the last written state in a single transaction
+func (sb *SQLiteBackend) Write(state *storage.State) error {
+	if state == nil {
+		return errors.New("state should not be nil")
+	}
+	sb.mutex.Lock()
+	defer sb.mutex.Unlock()
+
+	tx, err := sb.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if !bytes.Equal(state.Secret, sb.cache.Secret) {
+		_, err := tx.Exec(`INSERT INTO settings (key, value) VALUES ('secret', ?)
+			ON CONFLICT (key) DO UPDATE SET value = excluded.value`, state.Secret)
+		if err != nil {
+			return fmt.Errorf("write secret: %w", err)
+		}
+	}
+	if state.Maintenance != sb.cache.Maintenance {
+		_, err := tx.Exec(`INSERT INTO settings (key, value) VALUES ('maintenance', ?)
+			ON CONFLICT (key) DO UPDATE SET value = excluded.value`, []byte(strconv.FormatBool(state.Maintenance)))
+		if err != nil {
+			return fmt.Errorf("write maintenance: %w", err)
+		}
+	}
+	if state.NextId != sb.cache.NextId {
+		_, err := tx.Exec(`INSERT INTO settings (key, value) VALUES ('next_id', ?)
+			ON CONFLICT (key) DO UPDATE SET value = excluded.value`, []byte(strconv.FormatUint(state.NextId, 10)))
+		if err != nil {
+			return fmt.Errorf("write next id: %w", err)
+		}
+	}
+	for nonce, expiry := range state.UsedNonces {
+		if old, ok := sb.cache.UsedNonces[nonce]; ok && old == expiry {
+			continue
+		}
+		_, err := tx.Exec(`INSERT INTO used_nonces (nonce, expiry) VALUES (?, ?)
+			ON CONFLICT (nonce) DO UPDATE SET expiry = excluded.expiry`, nonce, expiry)
+		if err != nil {
+			return fmt.Errorf("write nonce: %w", err)
+		}
+	}
+	for nonce := range sb.cache.UsedNonces {
+		if _, ok := state.UsedNonces[nonce]; !ok {
+			if _, err := tx.Exec("DELETE FROM used_nonces WHERE nonce = ?", nonce); err != nil {
+				return fmt.Errorf("remove nonce: %w", err)
+			}
+		}
+	}
+
+	rows := make(map[string][]byte, len(state.Streams))
+	for _, stream := range state.Streams {
+		data, err := proto.Marshal(stream)
+		if err != nil {
+			return fmt.Errorf("failed to encode stream: %w", err)
+		}
+		rows[stream.Id] = data
+		if old, ok := sb.rows[stream.Id]; ok && bytes.Equal(old, data) {
+			continue
+		}
+		_, err = tx.Exec(`INSERT INTO streams (id, application, name, data) VALUES (?, ?, ?, ?)
+			ON CONFLICT (id) DO UPDATE SET application = excluded.application,
+			name = excluded.name, data = excluded.data`,
+			stream.Id, stream.Application, stream.Name, data)
+		if err != nil {
+			return fmt.Errorf("write stream %s: %w", stream.Id, err)
+		}
+	}
+
+	var removed []interface{}
+	for id := range sb.rows {
+		if _, ok := rows[id]; !ok {
+			removed = append(removed, id)
+		}
+	}
+	if len(removed) > 0 {
+		placeholders := strings.TrimSuffix(strings.Repeat("?,", len(removed)), ",")
+		_, err := tx.Exec("DELETE FROM streams WHERE id IN ("+placeholders+")", removed...)
+		if err != nil {
+			return fmt.Errorf("remove streams: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	sb.rows = rows
+	// The caller may still hold and change state
+	sb.cache = proto.Clone(state).(*storage.State)
+	return nil
+}
+
+// Check verifies the database connection
+func (sb *SQLiteBackend) Check() error {
+	return sb.db.Ping()
+}
+
+// Close closes the database
+func (sb *SQLiteBackend) Close() error {
+	return sb.db.Close()
+}
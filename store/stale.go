@@ -0,0 +1,103 @@
+package store
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/voc/rtmp-auth/storage"
+)
+
+// HistoryReaped is an unpublish the rtmp server never sent, recorded when a stale publisher is reaped
+const HistoryReaped = "reaped"
+
+// SetStaleTimeout sets publishers inactive which showed no activity for timeout, 0 disables it.
+// Activity is a publish or a heartbeat reported with Touch, checked every session interval.
+// Publishers found active without any activity seen, e.g. after a restart, get the full timeout
+func (store *Store) SetStaleTimeout(timeout time.Duration) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.staleTimeout = timeout
+	store.seen = make(map[string]time.Time)
+}
+
+// Touch records activity of a publisher of app/name, e.g. a repeated auth request during its session
+func (store *Store) Touch(app string, name string) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.touch(app, name)
+}
+
+// touch expects the mutex to be held
+func (store *Store) touch(app string, name string) {
+	if store.staleTimeout > 0 {
+		store.seen[app+"/"+name] = time.Now()
+	}
+}
+
+// ReapStale sets publishers inactive which showed no activity within the stale timeout,
+// for rtmp servers which went away without sending the unpublish
+func (store *Store) ReapStale() {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if store.staleTimeout <= 0 {
+		return
+	}
+
+	var reaped []*storage.Stream
+	var names [][]string
+	err := store.updateContext(context.Background(), func(state *storage.State) error {
+		now := time.Now()
+		live := make(map[string]bool)
+		reaped, names = nil, nil
+		for _, stream := range state.Streams {
+			var streamNames []string
+			for _, name := range activeNames(stream) {
+				key := stream.Application + "/" + name
+				live[key] = true
+				last, ok := store.seen[key]
+				if !ok {
+					store.seen[key] = now
+					continue
+				}
+				if now.Sub(last) < store.staleTimeout {
+					continue
+				}
+				log.Printf("Reaping stale publishers of %s/%s, no activity for %v\n", stream.Application, name,
+					now.Sub(last).Round(time.Second))
+				store.cancelInactive(stream.Application, name)
+				delete(store.evicted, key)
+				setPublishersFor(stream, name, 0)
+				store.recordEvent(stream, newConnectionEvent(HistoryReaped, name, ""))
+				streamNames = append(streamNames, name)
+			}
+			if len(streamNames) > 0 {
+				if !stream.Active {
+					stream.SessionStarted = 0
+				}
+				reaped = append(reaped, stream)
+				names = append(names, streamNames)
+			}
+		}
+		// Forget publishers which are gone
+		for key := range store.seen {
+			if !live[key] {
+				delete(store.seen, key)
+			}
+		}
+		if len(reaped) == 0 {
+			return errUnchanged
+		}
+		return nil
+	})
+	if err != nil {
+		log.Println("reap stale", err)
+		return
+	}
+	for i, stream := range reaped {
+		for _, name := range names[i] {
+			delete(store.seen, stream.Application+"/"+name)
+			store.emit(stream.Id, stream.Application, name, EventUnpublish)
+		}
+	}
+}
@@ -1,126 +1,1458 @@
 package store
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/google/uuid"
+	"github.com/voc/rtmp-auth/storage"
+)
+
+type StoreConfig struct {
+	Backend string
+	File    FileBackendConfig
+	Consul  ConsulBackendConfig
+	SQLite  SQLiteBackendConfig
+	// Postgres allows sharing the state between multiple instances
+	Postgres PostgresBackendConfig
+	// PlaintextKeys disables hashing of auth keys
+	PlaintextKeys bool `toml:"plaintext-keys"`
+	// ExpireInterval is how often streams are checked for expiry
+	ExpireInterval time.Duration `toml:"expire-interval"`
+	// ExpireGrace is how long expired streams stay blocked before removal
+	ExpireGrace time.Duration `toml:"expire-grace"`
+	// KeepExpired only blocks expired streams instead of removing them
+	KeepExpired bool `toml:"keep-expired"`
+	// InactiveGrace delays unpublish so publishers reconnecting within it stay active, 0 disables
+	InactiveGrace time.Duration `toml:"inactive-grace"`
+	// RemoveRetention is how long removed streams can be restored before they are purged
+	RemoveRetention time.Duration `toml:"remove-retention"`
+	// SessionInterval is how often publishing sessions are checked against their maximum duration
+	SessionInterval time.Duration `toml:"session-interval"`
+	// OpenPlay allows playing streams without a play key without any key,
+	// otherwise their publish keys are checked
+	OpenPlay bool `toml:"open-play"`
+	// PersistMaintenance keeps the maintenance mode in the backend, so it survives restarts
+	// and is shared by instances using the same postgres database
+	PersistMaintenance bool `toml:"persist-maintenance"`
+	// IdScheme generates the ids of new streams, uuid, slug or sequence, see IdSchemeUUID
+	IdScheme string `toml:"id-scheme"`
+	// HistoryLength is how many publishes and unpublishes are kept per stream, 0 keeps 50 and -1 none
+	HistoryLength int `toml:"history-length"`
+}
+
+type Store struct {
+	backend     Backend
+	hashKeys    bool
+	openPlay    bool
+	subscribers subscribers
+	// mutex serializes read-modify-write cycles on the backend state. Reads don't take it,
+	// backends return a private copy of a consistent state and keep a copy of what is written,
+	// so neither side can change the other's streams
+	mutex sync.Mutex
+
+	expireGrace time.Duration
+	keepExpired bool
+	stop        chan struct{}
+	done        sync.WaitGroup
+
+	inactiveGrace   time.Duration
+	removeRetention time.Duration
+	// pending holds the scheduled inactive transitions by app/name, guarded by mutex
+	pending map[string]*time.Timer
+	// evicted counts the publishers replaced by a takeover by app/name, guarded by mutex
+	evicted map[string]int
+
+	// tokenSecret verifies signed tokens, tokens are rejected if empty
+	tokenSecret []byte
+	// liveExpiryGrace keeps authorizing expired streams for publishers already live under the name
+	liveExpiryGrace time.Duration
+	// openApps are the applications publishing and playing without a key
+	openApps map[string]bool
+	// quotas limit the number of streams per application
+	quotas map[string]int
+	// keyPrefixes are the prefixes the keys of an application must start with
+	keyPrefixes map[string]string
+
+	// expiryWarning is the lead time of EventExpiring, 0 disables it.
+	// warned holds the expiry each stream was warned about by id, both guarded by mutex
+	expiryWarning time.Duration
+	warned        map[string]int64
+
+	// maintenance denies new publishes, kept in the state instead if persistMaintenance is set
+	maintenance        atomic.Bool
+	persistMaintenance bool
+
+	// autoBlock counts wrong keys per stream, nil if auto-blocking is disabled
+	autoBlock *autoBlocker
+	// geo resolves the countries of publishers, nil if no GeoIP database is configured
+	geo *geoCache
+	// newId generates the ids of new streams, see assignIds
+	newId idGenerator
+	// oneTimeTokens rejects tokens without a nonce, see UseToken
+	oneTimeTokens bool
+	// historyLength limits the connection events kept per stream, see recordEvent
+	historyLength int
+	// staleTimeout is how long publishers stay active without activity, 0 disables reaping.
+	// seen holds the last activity by app/name, both guarded by mutex
+	staleTimeout time.Duration
+	seen         map[string]time.Time
+}
+
+func NewStore(config StoreConfig) (*Store, error) {
+	newId, err := newIdGenerator(config.IdScheme)
+	if err != nil {
+		return nil, err
+	}
+	var backend Backend
+	switch config.Backend {
+	case "file":
+		backend, err = NewFileBackend(config.File)
+	case "consul":
+		backend, err = NewConsulBackend(config.Consul)
+	case "sqlite":
+		backend, err = NewSQLiteBackend(config.SQLite)
+	case "postgres":
+		backend, err = NewPostgresBackend(config.Postgres)
+	default:
+		err = fmt.Errorf("Unknown backend %s", config.Backend)
+	}
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("store: using %s backend\n", config.Backend)
+	return newStore(config, backend, newId), nil
+}
+
+// NewStoreWithBackend returns a store keeping its state in backend, config.Backend and the backend configs are ignored
+func NewStoreWithBackend(config StoreConfig, backend Backend) (*Store, error) {
+	newId, err := newIdGenerator(config.IdScheme)
+	if err != nil {
+		return nil, err
+	}
+	return newStore(config, backend, newId), nil
+}
+
+func newStore(config StoreConfig, backend Backend, newId idGenerator) *Store {
+	store := &Store{
+		backend:     backend,
+		hashKeys:    !config.PlaintextKeys,
+		openPlay:    config.OpenPlay,
+		expireGrace: config.ExpireGrace,
+		keepExpired: config.KeepExpired,
+		stop:        make(chan struct{}),
+
+		inactiveGrace: config.InactiveGrace,
+		pending:       make(map[string]*time.Timer),
+		evicted:       make(map[string]int),
+		warned:        make(map[string]int64),
+
+		persistMaintenance: config.PersistMaintenance,
+		newId:              newId,
+	}
+	store.removeRetention = config.RemoveRetention
+	if store.removeRetention == 0 {
+		store.removeRetention = 24 * time.Hour
+	}
+	store.historyLength = config.HistoryLength
+	if store.historyLength == 0 {
+		store.historyLength = defaultHistoryLength
+	}
+	if state, err := backend.Read(); err == nil {
+		warnDuplicates(state)
+		if store.inMaintenance(state) {
+			log.Println("store: maintenance mode is on, new publishes are denied")
+		}
+	}
+
+	interval := config.ExpireInterval
+	if interval == 0 {
+		interval = 5 * time.Minute
+	}
+	store.done.Add(1)
+	go store.expireLoop(interval)
+
+	sessionInterval := config.SessionInterval
+	if sessionInterval == 0 {
+		sessionInterval = 10 * time.Second
+	}
+	store.done.Add(1)
+	go store.sessionLoop(sessionInterval)
+
+	return store
+}
+
+// warnDuplicates logs streams sharing application and name, which were possible before AddStream rejected them
+func warnDuplicates(state *storage.State) {
+	seen := make(map[string]bool, len(state.Streams))
+	for _, stream := range state.Streams {
+		if stream.Removed != 0 {
+			continue
+		}
+		key := stream.Application + "/" + stream.Name
+		if seen[key] {
+			log.Printf("store: duplicate stream %s (%s), the first with a matching key is used\n", key, stream.Id)
+		}
+		seen[key] = true
+	}
+}
+
+// expireLoop periodically expires streams until the store is stopped
+func (store *Store) expireLoop(interval time.Duration) {
+	defer store.done.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-store.stop:
+			return
+		case <-ticker.C:
+			store.Expire()
+		}
+	}
+}
+
+// sessionLoop periodically ends sessions exceeding their maximum duration and reaps stale publishers
+// until the store is stopped
+func (store *Store) sessionLoop(interval time.Duration) {
+	defer store.done.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-store.stop:
+			return
+		case <-ticker.C:
+			store.EndSessions()
+			store.ReapStale()
+		}
+	}
+}
+
+// EndSessions sets streams inactive whose publishing session exceeded the maximum duration
+// and blocks them if configured, so the next auth check of the rtmp server fails
+func (store *Store) EndSessions() {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	var ended []*storage.Stream
+	var names [][]string
+	err := store.updateContext(context.Background(), func(state *storage.State) error {
+		now := time.Now().Unix()
+		ended, names = nil, nil
+		for _, stream := range state.Streams {
+			if stream.MaxSessionDuration <= 0 || stream.SessionStarted == 0 ||
+				now-stream.SessionStarted < stream.MaxSessionDuration {
+				continue
+			}
+			log.Printf("Ending session of %s/%s after %v\n", stream.Application, stream.Name,
+				time.Duration(now-stream.SessionStarted)*time.Second)
+			streamNames := activeNames(stream)
+			for _, name := range streamNames {
+				store.cancelInactive(stream.Application, name)
+			}
+			stream.Active = false
+			stream.ActiveNames = nil
+			stream.Publishers = nil
+			stream.SessionStarted = 0
+			if stream.BlockAfterSession {
+				stream.Blocked = true
+			}
+			ended = append(ended, stream)
+			names = append(names, streamNames)
+		}
+		if len(ended) == 0 {
+			return errUnchanged
+		}
+		return nil
+	})
+	if err != nil {
+		log.Println("end sessions", err)
+		return
+	}
+	for i, stream := range ended {
+		for _, name := range names[i] {
+			store.emit(stream.Id, stream.Application, name, EventUnpublish)
+		}
+		if stream.BlockAfterSession {
+			store.emit(stream.Id, stream.Application, stream.Name, EventBlock)
+		}
+	}
+}
+
+// Close ends the background expiry, writes the final state and releases the backend
+func (store *Store) Close() error {
+	close(store.stop)
+	store.done.Wait()
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	// Apply pending unpublishes, a restart is no reconnect
+	for key, timer := range store.pending {
+		timer.Stop()
+		delete(store.pending, key)
+		app, name, _ := strings.Cut(key, "/")
+		store.setInactive(context.Background(), app, name, nil)
+	}
+	state, err := store.backend.Read()
+	if err == nil {
+		err = store.backend.Write(state)
+	}
+	if closer, ok := store.backend.(io.Closer); ok {
+		if cerr := closer.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// GetAppNameActive returns true if there is an active stream on app/name
+func getAppNameActive(state *storage.State, app string, name string) bool {
+	active := false
+	for _, stream := range state.Streams {
+		if stream.Application == app && activeFor(stream, name) {
+			active = true
+		}
+	}
+	return active
+}
+
+// lockContext takes the mutex unless ctx is done first. A lock acquired after ctx is done
+// is released again in the background
+func (store *Store) lockContext(ctx context.Context) error {
+	if ctx.Done() == nil {
+		store.mutex.Lock()
+		return nil
+	}
+	locked := make(chan struct{})
+	go func() {
+		store.mutex.Lock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+		if err := ctx.Err(); err != nil {
+			store.mutex.Unlock()
+			return err
+		}
+		return nil
+	case <-ctx.Done():
+		go func() {
+			<-locked
+			store.mutex.Unlock()
+		}()
+		return ctx.Err()
+	}
+}
+
+// readContext reads the state, backends implementing ContextBackend are cancelled with ctx
+func (store *Store) readContext(ctx context.Context) (*storage.State, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if backend, ok := store.backend.(ContextBackend); ok {
+		return backend.ReadContext(ctx)
+	}
+	return store.backend.Read()
+}
+
+// writeContext writes the state, backends implementing ContextBackend are cancelled with ctx
+func (store *Store) writeContext(ctx context.Context, state *storage.State) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if backend, ok := store.backend.(ContextBackend); ok {
+		return backend.WriteContext(ctx, state)
+	}
+	return store.backend.Write(state)
+}
+
+// errUnchanged is returned by update functions of updateContext which changed nothing to skip the write
+var errUnchanged = errors.New("unchanged")
+
+// updateContext applies update to the state and writes it, in one transaction on backends implementing Updater.
+// Used for the active state, which instances sharing a backend change concurrently. Expects the mutex to be held
+func (store *Store) updateContext(ctx context.Context, update func(state *storage.State) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	var err error
+	if backend, ok := store.backend.(Updater); ok {
+		err = backend.UpdateContext(ctx, update)
+	} else {
+		var state *storage.State
+		if state, err = store.readContext(ctx); err == nil {
+			if err = update(state); err == nil {
+				err = store.writeContext(ctx, state)
+			}
+		}
+	}
+	if errors.Is(err, errUnchanged) {
+		return nil
+	}
+	return err
+}
+
+// Auth looks up if a given app/name/key tuple is allowed to publish from ip.
+// Returns success (bool) and the matched streams id string, see CheckAuth for the details
+func (store *Store) Auth(ctx context.Context, app string, name string, auth string, ip string) (success bool, id string) {
+	result := store.CheckAuth(ctx, app, name, auth, ip)
+	return result.Authorized, result.Id
+}
+
+// SplitNameKey splits a stream name carrying the auth key, like "name_KEY", at delimiter.
+// As the delimiter may also appear in names and keys, the longest prefix with a matching
+// stream in app is taken as name, falling back to the last delimiter.
+// ok is false if name contains no delimiter
+func (store *Store) SplitNameKey(app string, name string, delimiter string) (stream string, auth string, ok bool) {
+	last := strings.LastIndex(name, delimiter)
+	if delimiter == "" || last == -1 {
+		return name, "", false
+	}
+	state, err := store.backend.Read()
+	if err != nil {
+		log.Println("read", err)
+	}
+	for i := last; i > 0 && state != nil; i = strings.LastIndex(name[:i], delimiter) {
+		if len(matchingStreams(state, app, name[:i])) > 0 {
+			return name[:i], name[i+len(delimiter):], true
+		}
+	}
+	return name[:last], name[last+len(delimiter):], true
+}
+
+// CheckAuth looks up if a given app/name/key tuple is allowed to publish from ip
+// and why, without changing the active state.
+// Stream names may be patterns, see matchingStreams for the precedence.
+// auth may be a stored key or a token signed with the token secret.
+// Fails with ReasonUnavailable if the state can't be read before ctx is done.
+// Wrong keys count towards the auto-block and matching plaintext keys are upgraded to hashes
+func (store *Store) CheckAuth(ctx context.Context, app string, name string, auth string, ip string) AuthResult {
+	return store.checkAuth(ctx, app, name, auth, ip, false)
+}
+
+// DryRunAuth answers like CheckAuth without side effects, wrong keys aren't counted and keys aren't upgraded
+func (store *Store) DryRunAuth(ctx context.Context, app string, name string, auth string, ip string) AuthResult {
+	return store.checkAuth(ctx, app, name, auth, ip, true)
+}
+
+func (store *Store) checkAuth(ctx context.Context, app string, name string, auth string, ip string, dryRun bool) AuthResult {
+	state, err := store.readContext(ctx)
+	if err != nil {
+		log.Println("read", err)
+		return AuthResult{Reason: ReasonUnavailable}
+	}
+
+	streams := matchingStreams(state, app, name)
+	// Stored keys still work if a token doesn't verify
+	if len(store.tokenSecret) > 0 && IsToken(auth) {
+		err := verifyToken(store.tokenSecret, auth, app, name)
+		if _, _, once := tokenNonce(auth); err == nil && store.oneTimeTokens && !once {
+			// Tokens without a nonce could be replayed
+			err = errors.New("one-time tokens are required")
+		}
+		if err == nil {
+			// Tokens don't need a stream, but a matching one may still block or restrict them
+			if len(streams) == 0 {
+				if getAppNameActive(state, app, name) {
+					return AuthResult{Reason: ReasonConflict}
+				}
+				if store.inMaintenance(state) {
+					log.Printf("Rejected %s/%s, maintenance mode is on\n", app, name)
+					return AuthResult{Reason: ReasonMaintenance}
+				}
+				return AuthResult{Authorized: true}
+			}
+			return store.authorize(state, streams[0], app, name, ip)
+		}
+		log.Printf("Token for %s/%s rejected: %v\n", app, name, err)
+	}
+
+	// Unknown streams of open applications are added by OpenStream on publish
+	if store.openApps[app] {
+		if len(streams) == 0 {
+			if store.inMaintenance(state) {
+				log.Printf("Rejected %s/%s, maintenance mode is on\n", app, name)
+				return AuthResult{Reason: ReasonMaintenance}
+			}
+			return AuthResult{Authorized: true}
+		}
+		return store.authorize(state, streams[0], app, name, ip)
+	}
+
+	if len(streams) == 0 {
+		return AuthResult{Reason: ReasonNotFound}
+	}
+	if !store.hasKeyPrefix(app, auth) {
+		log.Printf("Rejected key for %s/%s without the prefix of the application\n", app, name)
+		if !dryRun {
+			store.recordBadKey(streams)
+		}
+		return AuthResult{Reason: ReasonBadKey}
+	}
+	// Duplicates with the same application and name can't be added anymore, but may
+	// still exist in older state. They are tried in state order and the first whose
+	// key matches decides, so a blocked duplicate doesn't shadow another one's key
+	for _, stream := range streams {
+		if matched, index := matchAnyKey(StreamKeys(stream), auth); matched {
+			if !dryRun {
+				store.upgradeAuthKey(ctx, stream, index, auth)
+				store.resetBadKeys(stream.Id)
+			}
+			return store.authorize(state, stream, app, name, ip)
+		}
+	}
+	if !dryRun {
+		store.recordBadKey(streams)
+	}
+	return AuthResult{Reason: ReasonBadKey}
+}
+
+// InWindow reports whether now lies within the activation window of the stream, unset bounds are open
+func InWindow(stream *storage.Stream, now int64) bool {
+	if stream.ActiveFrom != 0 && now < stream.ActiveFrom {
+		return false
+	}
+	return stream.ActiveUntil == 0 || now < stream.ActiveUntil
+}
+
+// authorize checks the restrictions, maintenance mode and conflicts of an authenticated publish.
+// Maintenance mode only rejects publishes to names which aren't live, so sessions already running continue
+func (store *Store) authorize(state *storage.State, stream *storage.Stream, app string, name string, ip string) AuthResult {
+	result := store.restrict(stream, app, name, ip)
+	if !result.Authorized {
+		return result
+	}
+	switch {
+	case !activeFor(stream, name) && store.inMaintenance(state):
+		log.Printf("Rejected %s/%s, maintenance mode is on\n", app, name)
+		return AuthResult{Id: stream.Id, Reason: ReasonMaintenance}
+	case !activeFor(stream, name) && getAppNameActive(state, app, name):
+		return AuthResult{Id: stream.Id, Reason: ReasonConflict}
+	}
+	return result
+}
+
+// restrict checks ip and country restrictions, blocking, expiry and the activation window,
+// which apply to publish and play alike
+func (store *Store) restrict(stream *storage.Stream, app string, name string, ip string) AuthResult {
+	result := AuthResult{Id: stream.Id}
+	now := time.Now()
+	expired := stream.AuthExpire != -1 && stream.AuthExpire < now.Unix()
+	if expired && store.inLiveExpiryGrace(stream, name, now) {
+		log.Printf("Authorized %s/%s under expiry grace, expired %s ago\n",
+			app, name, now.Sub(time.Unix(stream.AuthExpire, 0)).Truncate(time.Second))
+		expired = false
+	}
+	country, countryAllowed := store.countryAllowed(stream, ip)
+	switch {
+	case !ipAllowed(stream, ip):
+		log.Printf("Rejected %s/%s from %s by ip restriction\n", app, name, ip)
+		result.Reason = ReasonIPDenied
+	case !countryAllowed:
+		if country == "" {
+			country = "unknown"
+		}
+		log.Printf("Rejected %s/%s from %s in country %s by country restriction\n", app, name, ip, country)
+		result.Reason = ReasonCountryDenied
+	case stream.Blocked:
+		result.Reason = ReasonBlocked
+	case stream.Disabled:
+		result.Reason = ReasonDisabled
+	// Streams expire right away, not only once the expire loop blocked them
+	case expired:
+		result.Reason = ReasonExpired
+	case !InWindow(stream, now.Unix()):
+		result.Reason = ReasonOutsideWindow
+	default:
+		result.Authorized = true
+	}
+	return result
+}
+
+// SetMaintenance turns maintenance mode on or off. While it's on, publishes to names which aren't live
+// are rejected with ReasonMaintenance, live sessions, play and unpublish aren't affected
+func (store *Store) SetMaintenance(enabled bool) error {
+	if store.persistMaintenance {
+		store.mutex.Lock()
+		defer store.mutex.Unlock()
+		state, err := store.backend.Read()
+		if err != nil {
+			return err
+		}
+		if state.Maintenance == enabled {
+			return nil
+		}
+		state.Maintenance = enabled
+		if err := store.backend.Write(state); err != nil {
+			return err
+		}
+	} else if store.maintenance.Swap(enabled) == enabled {
+		return nil
+	}
+	if enabled {
+		log.Println("Maintenance mode on, new publishes are denied")
+	} else {
+		log.Println("Maintenance mode off")
+	}
+	return nil
+}
+
+// inMaintenance reports whether maintenance mode is on, state is only used with persistMaintenance
+func (store *Store) inMaintenance(state *storage.State) bool {
+	if store.persistMaintenance {
+		return state.Maintenance
+	}
+	return store.maintenance.Load()
+}
+
+// SetExpiryWarning enables EventExpiring for streams expiring within lead,
+// checked together with the expiry every expire interval
+func (store *Store) SetExpiryWarning(lead time.Duration) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.expiryWarning = lead
+}
+
+// SetOpenApplications disables the key check of apps, any key or none is authorized.
+// Blocking, expiry and ip restrictions of defined streams still apply
+func (store *Store) SetOpenApplications(apps []string) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.openApps = make(map[string]bool, len(apps))
+	for _, app := range apps {
+		store.openApps[app] = true
+	}
+}
+
+// SetApplicationQuotas limits the number of streams per application, removed streams don't count.
+// Adding, restoring or moving streams into an application at its quota fails with ErrQuotaReached
+func (store *Store) SetApplicationQuotas(quotas map[string]int) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.quotas = quotas
+}
+
+// SetKeyPrefixes requires the keys of applications to start with their prefix, e.g. "live_" for live,
+// so a key pasted into a stream of the wrong application doesn't authorize. Regenerated keys get the prefix
+func (store *Store) SetKeyPrefixes(prefixes map[string]string) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.keyPrefixes = prefixes
+}
+
+// hasKeyPrefix reports whether auth starts with the key prefix of app, empty keys are left to the key check
+func (store *Store) hasKeyPrefix(app string, auth string) bool {
+	return auth == "" || strings.HasPrefix(auth, store.keyPrefixes[app])
+}
+
+// ErrQuotaReached is returned when adding a stream to an application which has all the streams its quota allows
+var ErrQuotaReached = errors.New("application quota reached")
+
+// checkQuota returns ErrQuotaReached if adding n streams to app would exceed its quota
+func (store *Store) checkQuota(state *storage.State, app string, n int) error {
+	quota, ok := store.quotas[app]
+	if !ok || quota <= 0 {
+		return nil
+	}
+	count := 0
+	for _, stream := range state.Streams {
+		if stream.Removed == 0 && stream.Application == app {
+			count++
+		}
+	}
+	if count+n > quota {
+		return fmt.Errorf("%w, %v is limited to %d streams", ErrQuotaReached, app, quota)
+	}
+	return nil
+}
+
+// IsOpen reports whether app is an open application
+func (store *Store) IsOpen(app string) bool {
+	return store.openApps[app]
+}
+
+// OpenStream returns the id of the stream for exactly app/name of an open application,
+// adding it with a random key if there is none, so publishes to open applications are tracked
+func (store *Store) OpenStream(app string, name string) (string, error) {
+	if !store.openApps[app] {
+		return "", fmt.Errorf("application %v isn't open", app)
+	}
+	key, err := GenerateKey()
+	if err != nil {
+		return "", err
+	}
+	stream := &storage.Stream{
+		Application: app,
+		Name:        name,
+		AuthKeys:    []string{key},
+		AuthExpire:  -1,
+		Notes:       "added by a publish to the open application",
+	}
+	if err := store.prepareStream(stream); err != nil {
+		return "", err
+	}
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	state, err := store.backend.Read()
+	if err != nil {
+		return "", err
+	}
+	if existing := findStream(state, app, name); existing != nil {
+		return existing.Id, nil
+	}
+	if err := store.checkQuota(state, app, 1); err != nil {
+		return "", err
+	}
+	if err := store.assignIds(state, stream); err != nil {
+		return "", err
+	}
+	log.Printf("Adding %s/%s of open application\n", app, name)
+	state.Streams = append(state.Streams, stream)
+	return stream.Id, store.backend.Write(state)
+}
+
+// SetLiveExpiryGrace keeps authorizing streams for up to grace past their expiry while they are
+// live under the published name, e.g. for repeated auth during a session or a reconnect within the inactive grace.
+// New publishes are denied at the expiry
+func (store *Store) SetLiveExpiryGrace(grace time.Duration) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.liveExpiryGrace = grace
+}
+
+// inLiveExpiryGrace reports whether the expired stream is live under name and within the live expiry grace
+func (store *Store) inLiveExpiryGrace(stream *storage.Stream, name string, now time.Time) bool {
+	if store.liveExpiryGrace <= 0 || stream.AuthExpire == -1 || !activeFor(stream, name) {
+		return false
+	}
+	return now.Before(time.Unix(stream.AuthExpire, 0).Add(store.liveExpiryGrace))
+}
+
+// SetTokenSecret enables publishing with tokens signed by secret, see MintToken
+func (store *Store) SetTokenSecret(secret []byte) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.tokenSecret = secret
+}
+
+// PlayAuth looks up if a given app/name/key tuple is allowed to play.
+// Returns success (bool) and the matched streams id string, see CheckPlayAuth for the details
+func (store *Store) PlayAuth(ctx context.Context, app string, name string, auth string, ip string) (success bool, id string) {
+	result := store.CheckPlayAuth(ctx, app, name, auth, ip)
+	return result.Authorized, result.Id
+}
+
+// CheckPlayAuth looks up if a given app/name/key tuple is allowed to play and why.
+// Streams without a PlayKey fall back to checking the AuthKey, or need no key with OpenPlay.
+// Matched streams are restricted like publishes, but maintenance mode and conflicts don't apply
+func (store *Store) CheckPlayAuth(ctx context.Context, app string, name string, auth string, ip string) AuthResult {
+	state, err := store.readContext(ctx)
+	if err != nil {
+		log.Println("read", err)
+		return AuthResult{Reason: ReasonUnavailable}
+	}
+
+	streams := matchingStreams(state, app, name)
+	if len(streams) == 0 {
+		if store.openApps[app] {
+			return AuthResult{Authorized: true}
+		}
+		return AuthResult{Reason: ReasonNotFound}
+	}
+	prefixed := store.hasKeyPrefix(app, auth)
+	// Duplicates with the same application and name can't be added anymore, but may
+	// still exist in older state. They are tried in state order and the first whose
+	// key matches decides, so a blocked duplicate doesn't shadow another one's key
+	for _, stream := range streams {
+		keys := StreamKeys(stream)
+		if stream.PlayKey != "" {
+			keys = []string{stream.PlayKey}
+		}
+		open := store.openApps[app] || (store.openPlay && stream.PlayKey == "")
+		if matched, _ := matchAnyKey(keys, auth); (matched && prefixed) || open {
+			return store.restrict(stream, app, name, ip)
+		}
+	}
+	return AuthResult{Reason: ReasonBadKey}
+}
+
+// upgradeAuthKey replaces the matched plaintext auth key at index with its hash.
+// It is skipped if ctx is done first and tried again on the next auth
+func (store *Store) upgradeAuthKey(ctx context.Context, matched *storage.Stream, index int, auth string) {
+	if !store.hashKeys || index < 0 || IsHashedKey(StreamKeys(matched)[index]) {
+		return
+	}
+	hash, err := hashKey(auth)
+	if err != nil {
+		log.Println("hash key:", err)
+		return
+	}
+
+	if err := store.lockContext(ctx); err != nil {
+		return
+	}
+	defer store.mutex.Unlock()
+	state, err := store.readContext(ctx)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	for _, stream := range state.Streams {
+		if stream.Id != matched.Id {
+			continue
+		}
+		// The state may have changed since auth, find the key again
+		migrateKeys(stream)
+		for i, key := range stream.AuthKeys {
+			if key == auth {
+				stream.AuthKeys[i] = hash
+				if err := store.writeContext(ctx, state); err != nil {
+					log.Println(err)
+					return
+				}
+				log.Printf("Upgraded auth key of %s/%s to hash\n", stream.Application, stream.Name)
+				return
+			}
+		}
+	}
+}
+
+// ErrPublisherLimit is returned by SetActive if the stream has reached its maximum concurrent publishers
+var ErrPublisherLimit = errors.New("too many publishers")
+
+// PublishPolicy decides about a publish to a stream which already has MaxPublishers publishers
+type PublishPolicy int
+
+const (
+	// PolicyDeny rejects the new publisher
+	PolicyDeny PublishPolicy = iota
+	// PolicyTakeover accepts the new publisher in place of the oldest one, whose unpublish is then ignored.
+	// The rtmp server has to drop the old publisher itself
+	PolicyTakeover
+)
+
+// SetActive adds a publisher from ip to a stream by its id for the published name.
+// If the stream already has MaxPublishers publishers under that name it fails with ErrPublisherLimit,
+// or with PolicyTakeover replaces one of them and returns true.
+// Returns the error of ctx if it is done before the publisher was added
+func (store *Store) SetActive(ctx context.Context, id string, name string, ip string, policy PublishPolicy) (bool, error) {
+	if err := store.lockContext(ctx); err != nil {
+		return false, err
+	}
+	defer store.mutex.Unlock()
+
+	var app string
+	var reconnect, takeover bool
+	err := store.updateContext(ctx, func(state *storage.State) error {
+		for _, stream := range state.Streams {
+			if stream.Id != id {
+				continue
+			}
+			app = stream.Application
+			// A reconnect within the grace period never gave up its slot
+			reconnect = store.cancelInactive(stream.Application, name)
+			count := publishersFor(stream, name)
+			action := HistoryReconnect
+			if !reconnect {
+				action = EventPublish
+				if count >= MaxPublishers(stream) {
+					if policy != PolicyTakeover {
+						log.Printf("Rejected duplicate publish of %s/%s, %d of %d publishers active\n",
+							stream.Application, name, count, MaxPublishers(stream))
+						return ErrPublisherLimit
+					}
+					log.Printf("Duplicate publish of %s/%s takes over from an active publisher, %d of %d publishers active\n",
+						stream.Application, name, count, MaxPublishers(stream))
+					takeover = true
+					action = HistoryTakeover
+				} else {
+					count++
+				}
+				stream.PublishCount++
+			} else if count == 0 {
+				count = 1
+			}
+			setPublishersFor(stream, name, count)
+			stream.LastActive = time.Now().Unix()
+			stream.SessionStarted = stream.LastActive
+			store.recordEvent(stream, newConnectionEvent(action, name, ip))
+			return nil
+		}
+		return fmt.Errorf("stream %v not found", id)
+	})
+	if err != nil {
+		return false, err
+	}
+	if takeover {
+		store.evicted[app+"/"+name]++
+		store.emit(id, app, name, EventUnpublish)
+	}
+	store.touch(app, name)
+	if !reconnect {
+		store.emit(id, app, name, EventPublish)
+	}
+	return takeover, nil
+}
+
+// consumeEvicted reports whether the unpublish of app/name belongs to a publisher replaced by a takeover
+// and forgets it, expects the mutex to be held
+func (store *Store) consumeEvicted(app string, name string) bool {
+	key := app + "/" + name
+	if store.evicted[key] == 0 {
+		return false
+	}
+	store.evicted[key]--
+	if store.evicted[key] == 0 {
+		delete(store.evicted, key)
+	}
+	return true
+}
+
+// cancelInactive cancels a scheduled inactive transition of app/name, expects the mutex to be held.
+// Returns true if one was pending
+func (store *Store) cancelInactive(app string, name string) bool {
+	key := app + "/" + name
+	timer, ok := store.pending[key]
+	if !ok {
+		return false
+	}
+	// A timer that already fired notices its removal from pending
+	timer.Stop()
+	delete(store.pending, key)
+	return true
+}
+
+// SetInactive removes a publisher from ip from all streams defined for or matching app/name, returns success.
+// With an inactive grace period the transition is scheduled and cancelled by a publish of app/name,
+// the unpublish is recorded in the history right away.
+// Fails if ctx is done first, the stream then stays active
+func (store *Store) SetInactive(ctx context.Context, app string, name string, ip string) bool {
+	if err := store.lockContext(ctx); err != nil {
+		log.Printf("Unpublish of %s/%s failed: %v\n", app, name, err)
+		return false
+	}
+	defer store.mutex.Unlock()
+	event := newConnectionEvent(EventUnpublish, name, ip)
+	// The replacing publisher keeps the slot
+	if store.consumeEvicted(app, name) {
+		store.recordUnpublish(ctx, app, name, event)
+		return true
+	}
+	if store.inactiveGrace <= 0 {
+		return store.setInactive(ctx, app, name, event)
+	}
+
+	// Only the last publisher leaving is debounced, others free their slot right away
+	state, err := store.readContext(ctx)
+	if err != nil {
+		return false
+	}
+	for _, stream := range state.Streams {
+		if stream.Application == app && publishersFor(stream, name) > 1 {
+			return store.setInactive(ctx, app, name, event)
+		}
+	}
+	store.recordUnpublish(ctx, app, name, event)
+
+	key := app + "/" + name
+	if timer, ok := store.pending[key]; ok {
+		timer.Stop()
+	}
+	var timer *time.Timer
+	timer = time.AfterFunc(store.inactiveGrace, func() {
+		store.mutex.Lock()
+		defer store.mutex.Unlock()
+		// cancelled or rescheduled while waiting for the mutex
+		if store.pending[key] != timer {
+			return
+		}
+		delete(store.pending, key)
+		store.setInactive(context.Background(), app, name, nil)
+	})
+	store.pending[key] = timer
+	return true
+}
+
+// setInactive removes a publisher of app/name and records event unless it is nil, expects the mutex to be held
+func (store *Store) setInactive(ctx context.Context, app string, name string, event *storage.ConnectionEvent) bool {
+	var ids []string
+	err := store.updateContext(ctx, func(state *storage.State) error {
+		ids = nil
+		for _, stream := range state.Streams {
+			if stream.Application == app && activeFor(stream, name) {
+				setPublishersFor(stream, name, publishersFor(stream, name)-1)
+				if !stream.Active {
+					stream.SessionStarted = 0
+				}
+				store.recordEvent(stream, event)
+				ids = append(ids, stream.Id)
+			}
+		}
+		if len(ids) == 0 {
+			return errUnchanged
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Unpublish of %s/%s failed: %v\n", app, name, err)
+		return false
+	}
+	for _, id := range ids {
+		store.emit(id, app, name, EventUnpublish)
+	}
+	return len(ids) > 0
+}
+
+// SetBlocked changes a streams blocked state
+func (store *Store) SetBlocked(id string, isBlocked bool) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	state, err := store.backend.Read()
+	if err != nil {
+		return err
+	}
+
+	for _, stream := range state.Streams {
+		if stream.Id == id {
+			changed := stream.Blocked != isBlocked
+			stream.Blocked = isBlocked
+			if err := store.backend.Write(state); err != nil {
+				return err
+			}
+			if changed {
+				store.emit(stream.Id, stream.Application, stream.Name, blockEvent(isBlocked))
+			}
+			if !isBlocked {
+				store.resetBadKeys(id)
+			}
+			return nil
+		}
+	}
+	return nil
+}
+
+// SetDisabled changes whether a stream is disabled. Disabled streams are rejected like blocked ones,
+// but are meant for administrative holds and are reported apart from blocking
+func (store *Store) SetDisabled(id string, disabled bool) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	state, err := store.backend.Read()
+	if err != nil {
+		return err
+	}
 
-	"github.com/voc/rtmp-auth/storage"
-)
+	for _, stream := range state.Streams {
+		if stream.Id == id {
+			changed := stream.Disabled != disabled
+			stream.Disabled = disabled
+			if err := store.backend.Write(state); err != nil {
+				return err
+			}
+			if changed {
+				action := EventEnable
+				if disabled {
+					action = EventDisable
+				}
+				store.emit(stream.Id, stream.Application, stream.Name, action)
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %v", ErrNotFound, id)
+}
 
-type StoreConfig struct {
-	Backend string
-	File    FileBackendConfig
-	Consul  ConsulBackendConfig
+// ErrNotFound is returned if no stream matches
+var ErrNotFound = errors.New("stream not found")
+
+// SetBlockedByName changes the blocked state of the streams defined for exactly app/name,
+// wildcard streams aren't affected by concrete names. Returns the changed streams or ErrNotFound
+func (store *Store) SetBlockedByName(app string, name string, isBlocked bool) ([]*storage.Stream, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	state, err := store.backend.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	var changed []*storage.Stream
+	var toggled []bool
+	for _, stream := range state.Streams {
+		if stream.Removed == 0 && stream.Application == app && stream.Name == name {
+			toggled = append(toggled, stream.Blocked != isBlocked)
+			stream.Blocked = isBlocked
+			changed = append(changed, stream)
+		}
+	}
+	if len(changed) == 0 {
+		return nil, fmt.Errorf("%w: %v/%v", ErrNotFound, app, name)
+	}
+	if err := store.backend.Write(state); err != nil {
+		return nil, err
+	}
+	for i, stream := range changed {
+		if toggled[i] {
+			store.emit(stream.Id, stream.Application, stream.Name, blockEvent(isBlocked))
+		}
+	}
+	return changed, nil
 }
 
-type Store struct {
-	backend Backend
+// SetBlockedByApplication changes the blocked state of all streams of app with a single write.
+// Returns the number of streams in app and the ones whose state changed, ErrNotFound if app has none
+func (store *Store) SetBlockedByApplication(app string, isBlocked bool) (int, []*storage.Stream, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	state, err := store.backend.Read()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	matched := 0
+	var changed []*storage.Stream
+	for _, stream := range state.Streams {
+		if stream.Removed != 0 || stream.Application != app {
+			continue
+		}
+		matched++
+		if stream.Blocked != isBlocked {
+			stream.Blocked = isBlocked
+			changed = append(changed, stream)
+		}
+	}
+	if matched == 0 {
+		return 0, nil, fmt.Errorf("%w: no streams in %v", ErrNotFound, app)
+	}
+	if len(changed) == 0 {
+		return matched, nil, nil
+	}
+	if err := store.backend.Write(state); err != nil {
+		return 0, nil, err
+	}
+	log.Printf("Set blocked=%v on %d of %d streams in %s\n", isBlocked, len(changed), matched, app)
+	for _, stream := range changed {
+		store.emit(stream.Id, stream.Application, stream.Name, blockEvent(isBlocked))
+	}
+	return matched, changed, nil
 }
 
-func NewStore(config StoreConfig) (*Store, error) {
-	var backend Backend
+// ErrNeverExpires is returned by ExtendExpiry when extending a stream which doesn't expire
+var ErrNeverExpires = errors.New("stream never expires")
+
+// ExtendExpiry sets the expiry of a stream by its id, -1 for never. With a non zero by the current
+// expiry is pushed back by that duration instead, counting from now if it already passed.
+// Returns the updated stream
+func (store *Store) ExtendExpiry(id string, expiry int64, by time.Duration) (*storage.Stream, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	state, err := store.backend.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, stream := range state.Streams {
+		if stream.Id != id || stream.Removed != 0 {
+			continue
+		}
+		if by != 0 {
+			if stream.AuthExpire == -1 {
+				return nil, fmt.Errorf("%w: %v/%v", ErrNeverExpires, stream.Application, stream.Name)
+			}
+			from := time.Unix(stream.AuthExpire, 0)
+			if now := time.Now(); from.Before(now) {
+				from = now
+			}
+			expiry = from.Add(by).Unix()
+		}
+		stream.AuthExpire = expiry
+		if err := store.backend.Write(state); err != nil {
+			return nil, err
+		}
+		return stream, nil
+	}
+	return nil, fmt.Errorf("%w: %v", ErrNotFound, id)
+}
+
+// prepareStream resets the state and hashes the keys of a stream about to be added,
+// its id is assigned by assignIds once the existing ones are known
+func (store *Store) prepareStream(stream *storage.Stream) error {
 	var err error
-	switch config.Backend {
-	case "file":
-		backend, err = NewFileBackend(config.File)
-	case "consul":
-		backend, err = NewConsulBackend(config.Consul)
-	default:
-		err = fmt.Errorf("Unknown backend %s", config.Backend)
+	stream.Blocked = false
+	stream.Disabled = false
+	migrateKeys(stream)
+	if store.hashKeys {
+		for i, key := range stream.AuthKeys {
+			if stream.AuthKeys[i], err = hashKey(key); err != nil {
+				return fmt.Errorf("hash auth key: %w", err)
+			}
+		}
+		if stream.PlayKey, err = hashKey(stream.PlayKey); err != nil {
+			return fmt.Errorf("hash play key: %w", err)
+		}
+	}
+	return nil
+}
+
+// ErrDuplicate is returned when adding a stream with the application and name of an existing one
+var ErrDuplicate = errors.New("duplicate stream")
+
+// findStream returns the first stream which isn't removed with exactly app/name
+func findStream(state *storage.State, app string, name string) *storage.Stream {
+	for _, stream := range state.Streams {
+		if stream.Removed == 0 && stream.Application == app && stream.Name == name {
+			return stream
+		}
+	}
+	return nil
+}
+
+// AddStream adds a new stream, ErrDuplicate is returned if one with the same application and name exists
+func (store *Store) AddStream(stream *storage.Stream) error {
+	if err := store.prepareStream(stream); err != nil {
+		return err
 	}
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	state, err := store.backend.Read()
 	if err != nil {
-		return nil, err
+		return err
 	}
-	log.Printf("store: using %s backend\n", config.Backend)
-	return &Store{backend: backend}, nil
+	if findStream(state, stream.Application, stream.Name) != nil {
+		return fmt.Errorf("%w %v/%v", ErrDuplicate, stream.Application, stream.Name)
+	}
+	if err := store.checkQuota(state, stream.Application, 1); err != nil {
+		return err
+	}
+	if err := store.assignIds(state, stream); err != nil {
+		return err
+	}
+	state.Streams = append(state.Streams, stream)
+
+	if err := store.backend.Write(state); err != nil {
+		return err
+	}
+
+	return nil
 }
 
-// GetAppNameActive returns true if there is an active stream on app/name
-func getAppNameActive(state *storage.State, app string, name string) bool {
-	active := false
+// OverwriteStream adds a new stream or updates the existing one with the same application and name
+// like UpdateStream. Returns the id of the added or updated stream
+func (store *Store) OverwriteStream(stream *storage.Stream) (string, error) {
+	if err := store.prepareStream(stream); err != nil {
+		return "", err
+	}
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	state, err := store.backend.Read()
+	if err != nil {
+		return "", err
+	}
+	existing := findStream(state, stream.Application, stream.Name)
+	if existing == nil {
+		if err := store.checkQuota(state, stream.Application, 1); err != nil {
+			return "", err
+		}
+		if err := store.assignIds(state, stream); err != nil {
+			return "", err
+		}
+		state.Streams = append(state.Streams, stream)
+		return stream.Id, store.backend.Write(state)
+	}
+	applyUpdate(existing, stream)
+	return existing.Id, store.backend.Write(state)
+}
+
+// AddStreams adds all streams with a single write and returns the indices of
+// duplicates, either of existing streams or earlier ones in the list.
+// Duplicates are left out if skipDuplicates is set, otherwise nothing is added and ErrDuplicate is returned
+func (store *Store) AddStreams(streams []*storage.Stream, skipDuplicates bool) (duplicates []int, err error) {
+	for _, stream := range streams {
+		if err := store.prepareStream(stream); err != nil {
+			return nil, err
+		}
+	}
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	state, err := store.backend.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	existing := make(map[string]bool, len(state.Streams)+len(streams))
 	for _, stream := range state.Streams {
-		if stream.Application == app && stream.Name == name && stream.Active {
-			active = true
+		if stream.Removed != 0 {
+			continue
 		}
+		existing[stream.Application+"/"+stream.Name] = true
 	}
-	return active
+	var added []*storage.Stream
+	for i, stream := range streams {
+		key := stream.Application + "/" + stream.Name
+		if existing[key] {
+			duplicates = append(duplicates, i)
+			continue
+		}
+		existing[key] = true
+		added = append(added, stream)
+	}
+	if len(duplicates) > 0 && !skipDuplicates {
+		return duplicates, ErrDuplicate
+	}
+	if len(added) == 0 {
+		return duplicates, nil
+	}
+	perApp := make(map[string]int)
+	for _, stream := range added {
+		perApp[stream.Application]++
+	}
+	for app, n := range perApp {
+		if err := store.checkQuota(state, app, n); err != nil {
+			return duplicates, err
+		}
+	}
+
+	if err := store.assignIds(state, added...); err != nil {
+		return duplicates, err
+	}
+	state.Streams = append(state.Streams, added...)
+	return duplicates, store.backend.Write(state)
 }
 
-// Auth looks up if a given app/name/key tuple is allowed to publish.
-// Returns success (bool) and the matched streams id string
-// TODO: Return error values to distinguish i.e. 401 Unauthorized
-// and 409 Conflict return codes in the publish request handler
-func (store *Store) Auth(app string, name string, auth string) (success bool, id string) {
+// UpdateStream changes application, name, expiry, notes, ip restrictions, publisher limit, max bitrate, session cap, tags, activation window and keys of a stream in place.
+// Keys are only replaced if the update carries any, active, blocked and disabled state is kept
+func (store *Store) UpdateStream(id string, update *storage.Stream) error {
+	migrateKeys(update)
+	if store.hashKeys {
+		var err error
+		for i, key := range update.AuthKeys {
+			if update.AuthKeys[i], err = hashKey(key); err != nil {
+				return fmt.Errorf("hash auth key: %w", err)
+			}
+		}
+		if update.PlayKey, err = hashKey(update.PlayKey); err != nil {
+			return fmt.Errorf("hash play key: %w", err)
+		}
+	}
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
 	state, err := store.backend.Read()
 	if err != nil {
-		return false, ""
+		return err
 	}
 
 	for _, stream := range state.Streams {
-		if stream.Application == app && stream.Name == name && stream.AuthKey == auth {
-			if !stream.Blocked {
-				var conflict bool
-				if stream.Active {
-					conflict = false
-				} else {
-					conflict = getAppNameActive(state, app, name)
+		if stream.Id == id {
+			// Renaming onto another stream would make auth ambiguous
+			if other := findStream(state, update.Application, update.Name); other != nil && other.Id != id {
+				return fmt.Errorf("%w %v/%v", ErrDuplicate, update.Application, update.Name)
+			}
+			if stream.Removed == 0 && update.Application != stream.Application {
+				if err := store.checkQuota(state, update.Application, 1); err != nil {
+					return err
 				}
-				return !conflict, stream.Id
-			} else {
-				return false, stream.Id
 			}
+			applyUpdate(stream, update)
+			return store.backend.Write(state)
 		}
 	}
-	return false, ""
+	return fmt.Errorf("stream %v not found", id)
+}
+
+// applyUpdate copies the editable fields of update to stream
+func applyUpdate(stream *storage.Stream, update *storage.Stream) {
+	stream.Application = update.Application
+	stream.Name = update.Name
+	stream.AuthExpire = update.AuthExpire
+	stream.Notes = update.Notes
+	stream.AllowedIps = update.AllowedIps
+	stream.DeniedIps = update.DeniedIps
+	stream.AllowedCountries = update.AllowedCountries
+	stream.MaxPublishers = update.MaxPublishers
+	stream.MaxBitrateKbps = update.MaxBitrateKbps
+	stream.MaxSessionDuration = update.MaxSessionDuration
+	stream.BlockAfterSession = update.BlockAfterSession
+	stream.Tags = update.Tags
+	stream.ActiveFrom = update.ActiveFrom
+	stream.ActiveUntil = update.ActiveUntil
+	stream.Owner = update.Owner
+	if len(update.AuthKeys) > 0 {
+		stream.AuthKey = ""
+		stream.AuthKeys = update.AuthKeys
+	}
+	if update.PlayKey != "" {
+		stream.PlayKey = update.PlayKey
+	}
 }
 
-// SetActive sets a stream to active state by its id, returns success
-func (store *Store) SetActive(id string) bool {
+// SetPlayKey replaces the play key of a stream, an empty key removes it
+func (store *Store) SetPlayKey(id string, key string) error {
+	if store.hashKeys {
+		var err error
+		if key, err = hashKey(key); err != nil {
+			return fmt.Errorf("hash play key: %w", err)
+		}
+	}
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
 	state, err := store.backend.Read()
 	if err != nil {
-		return false
+		return err
 	}
 
-	success := false
 	for _, stream := range state.Streams {
 		if stream.Id == id {
-			stream.Active = true
-			if err := store.backend.Write(state); err != nil {
-				log.Println(err)
-			} else {
-				success = true
-			}
+			stream.PlayKey = key
+			return store.backend.Write(state)
 		}
 	}
-	return success
+	return fmt.Errorf("stream %v not found", id)
 }
 
-// SetInactive unsets the active state for all streams defined for app/name, returns success
-func (store *Store) SetInactive(app string, name string) bool {
+// AddKey adds an auth key to a stream, allowing key rotation without downtime
+func (store *Store) AddKey(id string, key string) error {
+	if key == "" {
+		return errors.New("key must not be empty")
+	}
+	if store.hashKeys {
+		var err error
+		if key, err = hashKey(key); err != nil {
+			return fmt.Errorf("hash auth key: %w", err)
+		}
+	}
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
 	state, err := store.backend.Read()
 	if err != nil {
-		return false
+		return err
 	}
 
-	success := false
 	for _, stream := range state.Streams {
-		if stream.Application == app && stream.Name == name {
-			stream.Active = false
-			if err := store.backend.Write(state); err != nil {
-				log.Println(err)
-			} else {
-				success = true
-			}
+		if stream.Id == id {
+			migrateKeys(stream)
+			stream.AuthKeys = append(stream.AuthKeys, key)
+			return store.backend.Write(state)
 		}
 	}
-	return success
+	return fmt.Errorf("stream %v not found", id)
 }
 
-// SetBlocked changes a streams blocked state
-func (store *Store) SetBlocked(id string, isBlocked bool) error {
+// RemoveKey removes the auth key at index from a stream
+func (store *Store) RemoveKey(id string, index int) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
 	state, err := store.backend.Read()
 	if err != nil {
 		return err
@@ -128,38 +1460,102 @@ func (store *Store) SetBlocked(id string, isBlocked bool) error {
 
 	for _, stream := range state.Streams {
 		if stream.Id == id {
-			stream.Blocked = isBlocked
-			if err := store.backend.Write(state); err != nil {
-				return err
+			migrateKeys(stream)
+			if index < 0 || index >= len(stream.AuthKeys) {
+				return fmt.Errorf("key %v not found", index)
 			}
-			return nil
+			// Removing the last key would allow publishing without auth
+			if len(stream.AuthKeys) == 1 {
+				return errors.New("can't remove the last key, remove the stream instead")
+			}
+			stream.AuthKeys = append(stream.AuthKeys[:index], stream.AuthKeys[index+1:]...)
+			return store.backend.Write(state)
 		}
 	}
-	return nil
+	return fmt.Errorf("stream %v not found", id)
 }
 
-func (store *Store) AddStream(stream *storage.Stream) error {
-	id, err := uuid.NewUUID()
+// RegenerateKey replaces all auth keys of a stream with a new random one and returns it,
+// starting with the key prefix of its application
+func (store *Store) RegenerateKey(id string) (string, error) {
+	key, err := GenerateKey()
 	if err != nil {
-		return err
+		return "", fmt.Errorf("generate key: %w", err)
+	}
+	// Looked up before taking the mutex, which isn't held while hashing
+	if state, err := store.backend.Read(); err == nil {
+		for _, stream := range state.Streams {
+			if stream.Id == id {
+				key = store.keyPrefixes[stream.Application] + key
+			}
+		}
+	}
+	stored := key
+	if store.hashKeys {
+		if stored, err = hashKey(key); err != nil {
+			return "", fmt.Errorf("hash auth key: %w", err)
+		}
 	}
 
-	stream.Id = id.String()
-	stream.Blocked = false
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
 	state, err := store.backend.Read()
 	if err != nil {
-		return err
+		return "", err
 	}
-	state.Streams = append(state.Streams, stream)
 
-	if err := store.backend.Write(state); err != nil {
+	for _, stream := range state.Streams {
+		if stream.Id == id {
+			stream.AuthKey = ""
+			stream.AuthKeys = []string{stored}
+			if err := store.backend.Write(state); err != nil {
+				return "", err
+			}
+			return key, nil
+		}
+	}
+	return "", fmt.Errorf("stream %v not found", id)
+}
+
+// RemoveStream marks a stream as removed, it no longer authorizes and is purged after the retention period
+func (store *Store) RemoveStream(id string) error {
+	return store.setRemoved(id, time.Now().Unix())
+}
+
+// RestoreStream undoes the removal of a stream
+func (store *Store) RestoreStream(id string) error {
+	return store.setRemoved(id, 0)
+}
+
+func (store *Store) setRemoved(id string, removed int64) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	state, err := store.backend.Read()
+	if err != nil {
 		return err
 	}
 
-	return nil
+	for _, stream := range state.Streams {
+		if stream.Id == id {
+			if (stream.Removed != 0) == (removed != 0) {
+				return fmt.Errorf("stream %v already in that state", id)
+			}
+			if removed == 0 {
+				if err := store.checkQuota(state, stream.Application, 1); err != nil {
+					return err
+				}
+			}
+			stream.Removed = removed
+			return store.backend.Write(state)
+		}
+	}
+	return fmt.Errorf("stream %v not found", id)
 }
 
-func (store *Store) RemoveStream(id string) error {
+// PurgeStream deletes a stream permanently
+func (store *Store) PurgeStream(id string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
 	state, err := store.backend.Read()
 	if err != nil {
 		return err
@@ -188,28 +1584,164 @@ func (store *Store) RemoveStream(id string) error {
 	return nil
 }
 
-// Expire old streams
+// Expire blocks streams past their expiry and removes them after the grace period.
+// Removed streams are purged once the retention period has passed
 func (store *Store) Expire() {
-	var toDelete []string
-	now := time.Now().Unix()
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	now := time.Now()
 
 	state, err := store.backend.Read()
 	if err != nil {
 		log.Println("read", err)
+		return
 	}
+	store.warnExpiring(state, now)
 
+	changed := false
+	var blocked []*storage.Stream
+	streams := make([]*storage.Stream, 0, len(state.Streams))
 	for _, stream := range state.Streams {
-		if stream.AuthExpire != -1 && stream.AuthExpire < now {
-			log.Printf("Expiring %s/%s\n", stream.Application, stream.Name)
-			toDelete = append(toDelete, stream.Id)
+		if stream.Removed != 0 {
+			if now.Sub(time.Unix(stream.Removed, 0)) >= store.removeRetention {
+				log.Printf("Purging removed %s/%s\n", stream.Application, stream.Name)
+				changed = true
+				continue
+			}
+			streams = append(streams, stream)
+			continue
+		}
+
+		// Never expiring streams are skipped
+		if stream.AuthExpire == -1 || stream.AuthExpire >= now.Unix() {
+			streams = append(streams, stream)
+			continue
+		}
+
+		expiredFor := now.Sub(time.Unix(stream.AuthExpire, 0))
+		// Live streams are blocked once the live expiry grace passed
+		if stream.Active && expiredFor < store.liveExpiryGrace {
+			streams = append(streams, stream)
+			continue
 		}
+		if !store.keepExpired && expiredFor >= store.expireGrace {
+			log.Printf("Removing expired %s/%s\n", stream.Application, stream.Name)
+			changed = true
+			continue
+		}
+		if !stream.Blocked {
+			log.Printf("Blocking expired %s/%s\n", stream.Application, stream.Name)
+			stream.Blocked = true
+			blocked = append(blocked, stream)
+			changed = true
+		}
+		streams = append(streams, stream)
+	}
+
+	if !changed {
+		return
 	}
+	state.Streams = streams
+	if err := store.backend.Write(state); err != nil {
+		log.Println("expire:", err)
+		return
+	}
+	for _, stream := range blocked {
+		store.emit(stream.Id, stream.Application, stream.Name, EventBlock)
+	}
+}
 
-	for _, id := range toDelete {
-		store.RemoveStream(id)
+// warnExpiring emits EventExpiring once for streams expiring within the warning lead time.
+// Streams are warned again if their expiry changed, warnings are only tracked in memory.
+// Expects the mutex to be held
+func (store *Store) warnExpiring(state *storage.State, now time.Time) {
+	if store.expiryWarning <= 0 {
+		return
+	}
+	due := make(map[string]bool)
+	for _, stream := range state.Streams {
+		// Never expiring streams are skipped
+		if stream.Removed != 0 || stream.AuthExpire == -1 {
+			continue
+		}
+		left := time.Unix(stream.AuthExpire, 0).Sub(now)
+		if left <= 0 || left > store.expiryWarning {
+			continue
+		}
+		due[stream.Id] = true
+		if store.warned[stream.Id] == stream.AuthExpire {
+			continue
+		}
+		store.warned[stream.Id] = stream.AuthExpire
+		log.Printf("%s/%s expires in %v\n", stream.Application, stream.Name, left.Round(time.Second))
+		store.emitEvent(Event{
+			Id:          stream.Id,
+			Application: stream.Application,
+			Name:        stream.Name,
+			Action:      EventExpiring,
+			AuthExpire:  stream.AuthExpire,
+		})
+	}
+	// Extended, expired and removed streams get a new warning next time they are due
+	for id := range store.warned {
+		if !due[id] {
+			delete(store.warned, id)
+		}
 	}
 }
 
+// Get returns the state without removed streams, Maintenance is set whether or not it's persisted.
+// The state is a deep copy, callers may sort, filter and change it without affecting the store
+// or other requests, changes are only persisted through the store's methods
 func (store *Store) Get() (*storage.State, error) {
-	return store.backend.Read()
+	state, err := store.backend.Read()
+	if err != nil {
+		return nil, err
+	}
+	res := filterRemoved(state, false)
+	res.Maintenance = store.inMaintenance(state)
+	return res, nil
+}
+
+// Removed returns copies of the removed streams, which can still be restored
+func (store *Store) Removed() ([]*storage.Stream, error) {
+	state, err := store.backend.Read()
+	if err != nil {
+		return nil, err
+	}
+	return filterRemoved(state, true).Streams, nil
+}
+
+// filterRemoved returns a state holding either only the removed or only the remaining streams.
+// The streams are shared with state, its slice isn't
+func filterRemoved(state *storage.State, removed bool) *storage.State {
+	res := &storage.State{
+		Secret:      state.Secret,
+		Revision:    state.Revision,
+		Maintenance: state.Maintenance,
+		Streams:     make([]*storage.Stream, 0, len(state.Streams)),
+	}
+	for _, stream := range state.Streams {
+		if (stream.Removed != 0) == removed {
+			res.Streams = append(res.Streams, stream)
+		}
+	}
+	return res
+}
+
+// Ping checks that the state can be read
+func (store *Store) Ping() error {
+	_, err := store.backend.Read()
+	return err
+}
+
+// Check checks that the state can be read and the backend storage is usable
+func (store *Store) Check() error {
+	if err := store.Ping(); err != nil {
+		return err
+	}
+	if checker, ok := store.backend.(Checker); ok {
+		return checker.Check()
+	}
+	return nil
 }
@@ -0,0 +1,197 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/voc/rtmp-auth/storage"
+)
+
+func newFileStore(t *testing.T) *Store {
+	t.Helper()
+	store, err := NewStore(StoreConfig{Backend: "file", File: FileBackendConfig{Path: filepath.Join(t.TempDir(), "store.db")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func streamNames(streams []*storage.Stream) []string {
+	names := make([]string, len(streams))
+	for i, stream := range streams {
+		names[i] = stream.Name
+	}
+	return names
+}
+
+// Sorting and changing the state returned by Get doesn't change the store's order or streams
+func TestGetReturnsCopy(t *testing.T) {
+	store := newFileStore(t)
+	for _, name := range []string{"b", "c", "a"} {
+		if err := store.AddStream(&storage.Stream{Application: "live", Name: name, AuthKeys: []string{"abcdefgh1" + name}, AuthExpire: -1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	state, err := store.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := streamNames(state.Streams)
+
+	sort.Slice(state.Streams, func(i, j int) bool { return state.Streams[i].Name > state.Streams[j].Name })
+	state.Streams[0].Blocked = true
+	state.Streams = state.Streams[:1]
+
+	again, err := store.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := streamNames(again.Streams)
+	if len(got) != len(want) {
+		t.Fatalf("streams after sorting a copy = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("streams after sorting a copy = %v, want %v", got, want)
+		}
+	}
+	for _, stream := range again.Streams {
+		if stream.Blocked {
+			t.Errorf("stream %s blocked by changing a copy", stream.Name)
+		}
+	}
+}
+
+// DryRunAuth leaves plaintext keys and the auto-block count alone, CheckAuth upgrades and counts
+func TestDryRunAuth(t *testing.T) {
+	store := newFileStore(t)
+	store.SetAutoBlock(2, time.Minute)
+	stream := &storage.Stream{Application: "live", Name: "foo", AuthKeys: []string{"abcdefgh1"}, AuthExpire: -1}
+	if err := store.AddStream(stream); err != nil {
+		t.Fatal(err)
+	}
+	// Keys of states written before hashing are plaintext
+	state, err := store.backend.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.Streams[0].AuthKeys = []string{"abcdefgh1"}
+	if err := store.backend.Write(state); err != nil {
+		t.Fatal(err)
+	}
+	current := func() *storage.Stream {
+		t.Helper()
+		state, err := store.Get()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return state.Streams[0]
+	}
+
+	ctx := context.Background()
+	for i := 0; i < 5; i++ {
+		if result := store.DryRunAuth(ctx, "live", "foo", "wrong", ""); result.Reason != ReasonBadKey {
+			t.Fatalf("dry run with a wrong key = %v, want bad key", result.Reason)
+		}
+	}
+	if result := store.DryRunAuth(ctx, "live", "foo", "abcdefgh1", ""); !result.Authorized {
+		t.Fatalf("dry run with the key = %v, want authorized", result.Reason)
+	}
+	if s := current(); s.Blocked || IsHashedKey(s.AuthKeys[0]) {
+		t.Fatalf("dry runs changed the stream, blocked %v, keys %v", s.Blocked, s.AuthKeys)
+	}
+
+	if result := store.CheckAuth(ctx, "live", "foo", "abcdefgh1", ""); !result.Authorized {
+		t.Fatalf("auth with the key = %v, want authorized", result.Reason)
+	}
+	if s := current(); !IsHashedKey(s.AuthKeys[0]) {
+		t.Errorf("auth didn't upgrade the key, keys %v", s.AuthKeys)
+	}
+	store.CheckAuth(ctx, "live", "foo", "wrong", "")
+	store.CheckAuth(ctx, "live", "foo", "wrong", "")
+	if !current().Blocked {
+		t.Error("wrong keys didn't block the stream")
+	}
+}
+
+// Play is rejected by the same stream restrictions as publish
+func TestPlayRestrictions(t *testing.T) {
+	tests := []struct {
+		name   string
+		stream *storage.Stream
+		ip     string
+		want   AuthReason
+	}{
+		{"allowed", &storage.Stream{AuthExpire: -1}, "192.0.2.1", ReasonOK},
+		{"expired", &storage.Stream{AuthExpire: time.Now().Add(-time.Hour).Unix()}, "192.0.2.1", ReasonExpired},
+		{"outside window", &storage.Stream{AuthExpire: -1, ActiveFrom: time.Now().Add(time.Hour).Unix()}, "192.0.2.1", ReasonOutsideWindow},
+		{"ip denied", &storage.Stream{AuthExpire: -1, AllowedIps: []string{"198.51.100.0/24"}}, "192.0.2.1", ReasonIPDenied},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store := newFileStore(t)
+			stream := test.stream
+			stream.Application, stream.Name = "live", "foo"
+			stream.AuthKeys, stream.PlayKey = []string{"abcdefgh1"}, "watchkey1"
+			if err := store.AddStream(stream); err != nil {
+				t.Fatal(err)
+			}
+			result := store.CheckPlayAuth(context.Background(), "live", "foo", "watchkey1", test.ip)
+			if result.Authorized != (test.want == ReasonOK) || result.Reason != test.want {
+				t.Errorf("play = %v %q, want reason %q", result.Authorized, result.Reason, test.want)
+			}
+		})
+	}
+}
+
+// Auth and reads running next to writes don't race, run with -race
+func TestConcurrentAccess(t *testing.T) {
+	store := newFileStore(t)
+	stream := &storage.Stream{Application: "live", Name: "foo", AuthKeys: []string{"abcdefgh1"}, AuthExpire: -1, MaxPublishers: 4}
+	if err := store.AddStream(stream); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				store.CheckAuth(ctx, "live", "foo", "abcdefgh1", "")
+				state, err := store.Get()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				sort.Slice(state.Streams, func(a, b int) bool { return state.Streams[a].Name > state.Streams[b].Name })
+			}
+		}()
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				added := &storage.Stream{Application: "live", Name: fmt.Sprintf("bar-%d-%d", i, j),
+					AuthKeys: []string{fmt.Sprintf("abcdefgh-%d-%d", i, j)}, AuthExpire: -1}
+				if err := store.AddStream(added); err != nil {
+					t.Error(err)
+					return
+				}
+				if _, err := store.SetActive(ctx, stream.Id, "foo", "", PolicyDeny); err != nil {
+					t.Error(err)
+				}
+				store.SetInactive(ctx, "live", "foo", "")
+				if err := store.RemoveStream(added.Id); err != nil {
+					t.Error(err)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,175 @@
+package store
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/voc/rtmp-auth/storage"
+)
+
+// tokenPrefix marks signed tokens, so they can be told apart from stored keys.
+// onceTokenPrefix marks tokens carrying a nonce, which can only be used once, see SetOneTimeTokens
+const (
+	tokenPrefix     = "v1."
+	onceTokenPrefix = "v2."
+)
+
+// ErrTokenReplayed is returned by UseToken for a one-time token which was used before
+var ErrTokenReplayed = errors.New("token already used")
+
+// MintToken returns a token allowing to publish app/name until expiry.
+// The token has the form v1.<expiry unix time>.<base64url HMAC-SHA256 signature>
+// and can be used in a query string as is
+func MintToken(secret []byte, app string, name string, expiry time.Time) string {
+	expires := strconv.FormatInt(expiry.Unix(), 10)
+	return tokenPrefix + expires + "." + signToken(secret, app, name, expires)
+}
+
+func signToken(secret []byte, app string, name string, expires string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(strings.Join([]string{tokenPrefix, app, name, expires}, "\n")))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// MintOnceToken returns a token allowing a single publish of app/name until expiry.
+// The token has the form v2.<expiry unix time>.<nonce>.<signature>, where the signature also covers the nonce
+func MintOnceToken(secret []byte, app string, name string, expiry time.Time) (string, error) {
+	random := make([]byte, 12)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	nonce := base64.RawURLEncoding.EncodeToString(random)
+	expires := strconv.FormatInt(expiry.Unix(), 10)
+	return onceTokenPrefix + expires + "." + nonce + "." + signOnceToken(secret, app, name, expires, nonce), nil
+}
+
+func signOnceToken(secret []byte, app string, name string, expires string, nonce string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(strings.Join([]string{onceTokenPrefix, app, name, expires, nonce}, "\n")))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// IsToken returns true if auth has the format of a signed token
+func IsToken(auth string) bool {
+	return (strings.HasPrefix(auth, tokenPrefix) && strings.Count(auth, ".") == 2) ||
+		(strings.HasPrefix(auth, onceTokenPrefix) && strings.Count(auth, ".") == 3)
+}
+
+// tokenNonce returns the nonce and expiry of a one-time token, ok is false for other tokens
+func tokenNonce(token string) (nonce string, expiry int64, ok bool) {
+	parts := strings.Split(strings.TrimPrefix(token, onceTokenPrefix), ".")
+	if !strings.HasPrefix(token, onceTokenPrefix) || len(parts) != 3 {
+		return "", 0, false
+	}
+	expiry, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return "", 0, false
+	}
+	return parts[1], expiry, true
+}
+
+// verifyToken checks the signature and expiry of a token for app/name
+func verifyToken(secret []byte, token string, app string, name string) error {
+	var expires string
+	if strings.HasPrefix(token, onceTokenPrefix) {
+		parts := strings.Split(strings.TrimPrefix(token, onceTokenPrefix), ".")
+		if len(parts) != 3 {
+			return errors.New("malformed token")
+		}
+		var nonce, signature string
+		expires, nonce, signature = parts[0], parts[1], parts[2]
+		if !hmac.Equal([]byte(signature), []byte(signOnceToken(secret, app, name, expires, nonce))) {
+			return errors.New("invalid token signature")
+		}
+	} else {
+		parts := strings.Split(strings.TrimPrefix(token, tokenPrefix), ".")
+		if len(parts) != 2 {
+			return errors.New("malformed token")
+		}
+		var signature string
+		expires, signature = parts[0], parts[1]
+		if !hmac.Equal([]byte(signature), []byte(signToken(secret, app, name, expires))) {
+			return errors.New("invalid token signature")
+		}
+	}
+	expiry, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return errors.New("malformed token expiry")
+	}
+	if time.Now().Unix() > expiry {
+		return errors.New("token expired")
+	}
+	return nil
+}
+
+// SetOneTimeTokens only accepts tokens with a nonce, minted by MintOnceToken, and rejects
+// their use for another publish with ErrTokenReplayed, see UseToken. Used nonces are kept
+// in the state until their token expires
+func (store *Store) SetOneTimeTokens(enabled bool) {
+	store.oneTimeTokens = enabled
+}
+
+// OneTimeTokens reports whether tokens may only be used for a single publish
+func (store *Store) OneTimeTokens() bool {
+	return store.oneTimeTokens
+}
+
+// nonceUsed reports whether nonce was used by a publish before and its token hasn't expired yet
+func nonceUsed(state *storage.State, nonce string, now time.Time) bool {
+	expiry, ok := state.UsedNonces[nonce]
+	return ok && now.Unix() <= expiry
+}
+
+// TokenUsed checks whether the one-time token auth was used by a publish before,
+// so a replay can be rejected before it takes over a stream. Other auth values are never used
+func (store *Store) TokenUsed(ctx context.Context, auth string) (bool, error) {
+	nonce, _, ok := tokenNonce(auth)
+	if !store.oneTimeTokens || !ok {
+		return false, nil
+	}
+	state, err := store.readContext(ctx)
+	if err != nil {
+		return false, err
+	}
+	return nonceUsed(state, nonce, time.Now()), nil
+}
+
+// UseToken marks the one-time token auth as used, once its publish was accepted after CheckAuth.
+// Nonces of expired tokens are pruned, the tokens are rejected by their expiry anyway.
+// Returns ErrTokenReplayed if it was used before, other auth values are ignored
+func (store *Store) UseToken(ctx context.Context, auth string) error {
+	if !store.oneTimeTokens || len(store.tokenSecret) == 0 {
+		return nil
+	}
+	nonce, expiry, ok := tokenNonce(auth)
+	if !ok {
+		return nil
+	}
+	if err := store.lockContext(ctx); err != nil {
+		return err
+	}
+	defer store.mutex.Unlock()
+	now := time.Now()
+	return store.updateContext(ctx, func(state *storage.State) error {
+		if nonceUsed(state, nonce, now) {
+			return ErrTokenReplayed
+		}
+		for other, until := range state.UsedNonces {
+			if now.Unix() > until {
+				delete(state.UsedNonces, other)
+			}
+		}
+		if state.UsedNonces == nil {
+			state.UsedNonces = make(map[string]int64)
+		}
+		state.UsedNonces[nonce] = expiry
+		return nil
+	})
+}
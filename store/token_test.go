@@ -0,0 +1,90 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/voc/rtmp-auth/storage"
+)
+
+func newTokenStore(t *testing.T, path string) *Store {
+	t.Helper()
+	store, err := NewStore(StoreConfig{Backend: "file", File: FileBackendConfig{Path: path}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.SetTokenSecret([]byte("token secret"))
+	store.SetOneTimeTokens(true)
+	return store
+}
+
+// Used nonces are kept in the state, so a token can't be used again after a restart
+func TestUseToken(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.db")
+	store := newTokenStore(t, path)
+	ctx := context.Background()
+	token, err := MintOnceToken([]byte("token secret"), "live", "foo", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if used, err := store.TokenUsed(ctx, token); used || err != nil {
+		t.Fatalf("new token used = %v, %v, want false", used, err)
+	}
+	if err := store.UseToken(ctx, token); err != nil {
+		t.Fatalf("first use: %v", err)
+	}
+	if used, err := store.TokenUsed(ctx, token); !used || err != nil {
+		t.Fatalf("token used after use = %v, %v, want true", used, err)
+	}
+	if err := store.UseToken(ctx, token); !errors.Is(err, ErrTokenReplayed) {
+		t.Fatalf("second use = %v, want %v", err, ErrTokenReplayed)
+	}
+	// Keys and tokens without a nonce aren't tracked
+	if err := store.UseToken(ctx, "abcdefgh1"); err != nil {
+		t.Errorf("use of a key = %v, want nil", err)
+	}
+	store.Close()
+
+	store = newTokenStore(t, path)
+	defer store.Close()
+	if err := store.UseToken(ctx, token); !errors.Is(err, ErrTokenReplayed) {
+		t.Errorf("use after a restart = %v, want %v", err, ErrTokenReplayed)
+	}
+}
+
+// Nonces of expired tokens are pruned by the next use
+func TestUseTokenPrunes(t *testing.T) {
+	store := newTokenStore(t, filepath.Join(t.TempDir(), "store.db"))
+	defer store.Close()
+	state, err := store.backend.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.UsedNonces = map[string]int64{"expired": time.Now().Add(-time.Minute).Unix()}
+	if err := store.backend.Write(state); err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := MintOnceToken([]byte("token secret"), "live", "foo", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.UseToken(context.Background(), token); err != nil {
+		t.Fatal(err)
+	}
+	state, err = store.backend.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce, _, _ := tokenNonce(token)
+	if _, ok := state.UsedNonces["expired"]; ok || len(state.UsedNonces) != 1 || !nonceUsed(state, nonce, time.Now()) {
+		t.Errorf("used nonces = %v, want only the nonce of the new token", state.UsedNonces)
+	}
+	if nonceUsed(&storage.State{UsedNonces: map[string]int64{nonce: time.Now().Add(-time.Second).Unix()}}, nonce, time.Now()) {
+		t.Error("nonce of an expired token counts as used")
+	}
+}
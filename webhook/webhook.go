@@ -0,0 +1,226 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/voc/rtmp-auth/store"
+)
+
+// SignatureHeader carries the hex encoded HMAC-SHA256 of the request body
+const SignatureHeader = "X-Rtmp-Auth-Signature"
+
+type Config struct {
+	URLs    []string      `toml:"urls"`
+	Secret  string        `toml:"secret" json:"-"`
+	Timeout time.Duration `toml:"timeout"`
+	Retries int           `toml:"retries"`
+}
+
+// MarshalJSON redacts the urls, so logging the config doesn't leak their credentials
+func (c Config) MarshalJSON() ([]byte, error) {
+	type config Config
+	redacted := config(c)
+	redacted.URLs = make([]string, len(c.URLs))
+	for i, url := range c.URLs {
+		redacted.URLs[i] = RedactURL(url)
+	}
+	return json.Marshal(redacted)
+}
+
+// EventTest is the action of the synthetic events sent by Test
+const EventTest = "test"
+
+// deliveryLogSize is the number of recent delivery attempts kept
+const deliveryLogSize = 50
+
+// Delivery is one attempt to post an event to a webhook, secrets in the url are redacted
+type Delivery struct {
+	Time    time.Time `json:"time"`
+	URL     string    `json:"url"`
+	Event   string    `json:"event"`
+	Attempt int       `json:"attempt"`
+	// Status is the HTTP status code, 0 if no response was received
+	Status int `json:"status"`
+	// Latency is marshalled in milliseconds
+	Latency time.Duration `json:"-"`
+	Error   string        `json:"error,omitempty"`
+}
+
+// MarshalJSON reports the latency in milliseconds
+func (d Delivery) MarshalJSON() ([]byte, error) {
+	type delivery Delivery
+	return json.Marshal(struct {
+		delivery
+		Latency int64 `json:"latency_ms"`
+	}{delivery(d), d.Latency.Milliseconds()})
+}
+
+// Notifier delivers stream events to the configured webhook URLs
+type Notifier struct {
+	urls    []string
+	secret  []byte
+	retries int
+	client  *http.Client
+
+	// deliveries is a ring buffer of the recent attempts, next is the oldest once it's full
+	mutex      sync.Mutex
+	deliveries []Delivery
+	next       int
+}
+
+func NewNotifier(config Config) *Notifier {
+	timeout := config.Timeout
+	if timeout == 0 {
+		timeout = 5 * time.Second
+	}
+	retries := config.Retries
+	if retries == 0 {
+		retries = 3
+	}
+	return &Notifier{
+		urls:    config.URLs,
+		secret:  []byte(config.Secret),
+		retries: retries,
+		client:  &http.Client{Timeout: timeout},
+	}
+}
+
+// Notify sends the event to all webhooks in the background
+func (n *Notifier) Notify(event store.Event) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Println("webhook: marshal", err)
+		return
+	}
+	for _, url := range n.urls {
+		go n.deliver(url, event.Action, body)
+	}
+}
+
+// Test posts a synthetic test event to every webhook once and returns the attempts in the order of the urls
+func (n *Notifier) Test() []Delivery {
+	body, err := json.Marshal(store.Event{Action: EventTest, Timestamp: time.Now().Unix()})
+	if err != nil {
+		log.Println("webhook: marshal", err)
+		return nil
+	}
+	res := make([]Delivery, len(n.urls))
+	var wg sync.WaitGroup
+	for i, url := range n.urls {
+		wg.Add(1)
+		go func(i int, url string) {
+			defer wg.Done()
+			res[i] = n.post(url, EventTest, 1, body)
+		}(i, url)
+	}
+	wg.Wait()
+	return res
+}
+
+// Deliveries returns the recent delivery attempts, newest first
+func (n *Notifier) Deliveries() []Delivery {
+	if n == nil {
+		return nil
+	}
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	res := make([]Delivery, 0, len(n.deliveries))
+	for i := len(n.deliveries) - 1; i >= 0; i-- {
+		res = append(res, n.deliveries[(n.next+i)%len(n.deliveries)])
+	}
+	return res
+}
+
+// record adds a delivery attempt to the log, replacing the oldest one if it's full
+func (n *Notifier) record(delivery Delivery) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	if len(n.deliveries) < deliveryLogSize {
+		n.deliveries = append(n.deliveries, delivery)
+		return
+	}
+	n.deliveries[n.next] = delivery
+	n.next = (n.next + 1) % deliveryLogSize
+}
+
+// deliver posts body to url, retrying with a linear backoff
+func (n *Notifier) deliver(url string, action string, body []byte) {
+	var delivery Delivery
+	for attempt := 1; attempt <= n.retries; attempt++ {
+		if delivery = n.post(url, action, attempt, body); delivery.Error == "" {
+			return
+		}
+		if attempt < n.retries {
+			time.Sleep(time.Duration(attempt) * time.Second)
+		}
+	}
+	log.Printf("webhook: delivery to %s failed after %d attempts: %s\n", delivery.URL, n.retries, delivery.Error)
+}
+
+// post sends body to url once and records the attempt
+func (n *Notifier) post(url string, action string, attempt int, body []byte) Delivery {
+	delivery := Delivery{Time: time.Now(), URL: RedactURL(url), Event: action, Attempt: attempt}
+	status, err := n.send(url, body)
+	delivery.Latency = time.Since(delivery.Time)
+	delivery.Status = status
+	if err != nil {
+		delivery.Error = err.Error()
+	}
+	n.record(delivery)
+	return delivery
+}
+
+func (n *Notifier) send(target string, body []byte) (int, error) {
+	req, err := http.NewRequest("POST", target, bytes.NewReader(body))
+	if err != nil {
+		return 0, errors.New("invalid url")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(n.secret) > 0 {
+		mac := hmac.New(sha256.New, n.secret)
+		mac.Write(body)
+		req.Header.Set(SignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	res, err := n.client.Do(req)
+	if err != nil {
+		// The url may carry credentials, only the cause is kept
+		if urlErr := (*url.Error)(nil); errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return 0, err
+	}
+	res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return res.StatusCode, fmt.Errorf("unexpected status %s", res.Status)
+	}
+	return res.StatusCode, nil
+}
+
+// RedactURL hides the user info and query values of a url, which often carry tokens
+func RedactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "REDACTED"
+	}
+	if u.User != nil {
+		u.User = url.User("REDACTED")
+	}
+	query := u.Query()
+	for key := range query {
+		query.Set(key, "REDACTED")
+	}
+	u.RawQuery = query.Encode()
+	return u.String()
+}